@@ -3,14 +3,36 @@ package btree
 import "encoding/binary"
 
 // node structure
-// | Type (2B) | Num Keys (2B) | Pointers (8B each) | Offsets (2B each) | Key-Value Pairs |
+// | Type (2B) | Num Keys (2B) | Checksum (4B) | Pointers (8B each) | Offsets (2B each) | Key-Value Pairs |
+// the checksum is set when the page is written to the file, see checksum.go
+//
+// the high byte of the type is the format version. version 1 stores the
+// prefix shared by all the keys once, the pairs only have the rest of the keys.
+// | Type (2B) | Num Keys (2B) | Checksum (4B) | Prefix Len (2B) | Prefix | Pointers | Offsets | Key-Value Pairs |
+// see prefix.go
+//
+// version 2 is version 1 with distinct layouts, the prefix may be empty.
+// the leaves have no pointers, the keys of the internal nodes have no value.
+// | Header (8B) | Prefix Len (2B) | Prefix | Offsets | Key Len (2B) | Val Len (2B) | Key | Val | ... leaf
+// | Header (8B) | Prefix Len (2B) | Prefix | Pointers | Offsets | Key Len (2B) | Key | ...      internal
+// the updates write version 2, the older pages are rewritten when updated
+// or by Migrate.
 type BNode struct {
 	data []byte
 }
 
 const (
-	BNODE_NODE = 1
-	BNODE_LEAF = 2
+	BNODE_NODE      = 1
+	BNODE_LEAF      = 2
+	BNODE_FREE_LIST = 3
+	BNODE_OVERFLOW  = 4
+)
+
+// the format versions
+const (
+	BNODE_V0 = 0
+	BNODE_V1 = 1 // prefix compressed
+	BNODE_V2 = 2 // prefix compressed, distinct leaf and internal layouts
 )
 
 type BTree struct {
@@ -21,14 +43,51 @@ type BTree struct {
 	del func(uint64)
 }
 
-const HEADER_SIZE = 4
+const HEADER_SIZE = 8
 const BTREE_PAGE_SIZE = 4096
 const BTREE_MAX_KEY_SIZE = 1000
 const BTREE_MAX_VAL_SIZE = 3000
 
 // In first two bytes, we have stored the type of node, (node, leaf)
 func (node BNode) btype() uint16 {
-	return binary.LittleEndian.Uint16(node.data)
+	return binary.LittleEndian.Uint16(node.data) & 0xff
+}
+
+func (node BNode) version() uint16 {
+	return binary.LittleEndian.Uint16(node.data) >> 8
+}
+
+// the prefix of all the keys, empty in version 0
+func (node BNode) prefix() []byte {
+	if node.version() == BNODE_V0 {
+		return nil
+	}
+	plen := binary.LittleEndian.Uint16(node.data[HEADER_SIZE:])
+	return node.data[HEADER_SIZE+2:][:plen]
+}
+
+// where the pointers start
+func (node BNode) base() uint16 {
+	if node.version() == BNODE_V0 {
+		return HEADER_SIZE
+	}
+	return HEADER_SIZE + 2 + binary.LittleEndian.Uint16(node.data[HEADER_SIZE:])
+}
+
+// the size of a pointer, the leaves of version 2 have none
+func (node BNode) ptrSize() uint16 {
+	if node.version() == BNODE_V2 && node.btype() == BNODE_LEAF {
+		return 0
+	}
+	return 8
+}
+
+// the lengths before a key, the internal nodes of version 2 have no value
+func (node BNode) pairHeader() uint16 {
+	if node.version() == BNODE_V2 && node.btype() == BNODE_NODE {
+		return 2
+	}
+	return 4
 }
 
 // In next two bytes, we have stored the number of keys in the node, this is the metadata
@@ -39,27 +98,36 @@ func (node BNode) nkeys() uint16 {
 // This is just metadata,
 // btype tells if it is leaf node or not
 // next two bytes tell the number of keys
+// the node is in version 0
 func (node BNode) setHeader(btype uint16, nkeys uint16) {
 	binary.LittleEndian.PutUint16(node.data[0:2], btype)
 	binary.LittleEndian.PutUint16(node.data[2:4], nkeys)
 }
 
+// the header of version 2, before the pairs are added
+func (node BNode) setPrefixHeader(btype uint16, nkeys uint16, prefix []byte) {
+	node.setHeader(BNODE_V2<<8|btype, nkeys)
+	binary.LittleEndian.PutUint16(node.data[HEADER_SIZE:], uint16(len(prefix)))
+	copy(node.data[HEADER_SIZE+2:], prefix)
+}
+
 // Next is stored the pointer to each of the child node, and each ptr is of 8 bytes. so it's position becomes HEADER_SIZE + 8*idx
 func (node BNode) getPtr(idx uint16) uint64 {
-	pos := HEADER_SIZE + 8*idx
+	assertCondition(node.ptrSize() > 0)
+	pos := node.base() + 8*idx
 	return binary.LittleEndian.Uint64(node.data[pos:])
 }
 
 // This is setting the same pointer
 func (node BNode) setPtr(idx uint16, val uint64) {
-	pos := HEADER_SIZE + 8*idx
+	pos := node.base() + 8*idx
 	binary.LittleEndian.PutUint64(node.data[pos:], val)
 }
 
 // After child pointer, offsets are stored. there are (idx-1) offsets and each offset is of size 2 bytes
 // TODO: What is offset?
 func offsetPos(node BNode, idx uint16) uint16 {
-	return HEADER_SIZE + 8*node.nkeys() + 2*(idx-1)
+	return node.base() + node.ptrSize()*node.nkeys() + 2*(idx-1)
 }
 
 func (node BNode) getOffset(idx uint16) uint16 {
@@ -75,28 +143,53 @@ func (node BNode) setOffset(idx uint16, offset uint16) {
 }
 
 func (node BNode) kvPos(idx uint16) uint16 {
-	return HEADER_SIZE + 8*node.nkeys() + 2*node.nkeys() + node.getOffset(idx)
+	return node.base() + (node.ptrSize()+2)*node.nkeys() + node.getOffset(idx)
 }
 
+// the whole key, a copy in version 1
 func (node BNode) getKey(idx uint16) []byte {
+	if prefix := node.prefix(); len(prefix) > 0 {
+		suffix := node.keySuffix(idx)
+		key := make([]byte, 0, len(prefix)+len(suffix))
+		return append(append(key, prefix...), suffix...)
+	}
+	return node.keySuffix(idx)
+}
+
+// the key without the prefix, as stored in the pair
+func (node BNode) keySuffix(idx uint16) []byte {
 	pos := node.kvPos(idx)
 	klen := binary.LittleEndian.Uint16(node.data[pos:])
-	return node.data[pos+4:][:klen]
+	return node.data[pos+node.pairHeader():][:klen]
 }
 
+// empty in the internal nodes
 func (node BNode) getVal(idx uint16) []byte {
+	if node.pairHeader() == 2 {
+		return nil
+	}
 	pos := node.kvPos(idx)
 	klen := binary.LittleEndian.Uint16(node.data[pos+0:])
-	vlen := binary.LittleEndian.Uint16(node.data[pos+2:])
+	vlen := binary.LittleEndian.Uint16(node.data[pos+2:]) &^ VAL_OVERFLOW
 	return node.data[pos+4+klen:][:vlen]
 }
 
-func (node BNode) getAllKeyVal() map[uint64]string {
-	kvs := map[uint64]string{}
-	for i := uint16(0); i < node.nkeys(); i++ {
-		kvs[binary.LittleEndian.Uint64(node.getKey(i))] = string(node.getVal(i))
+// the high bit of the value length marks a value stored in overflow pages,
+// the value in the leaf is a reference to them, see overflow.go
+const VAL_OVERFLOW = 0x8000
+
+func (node BNode) valFlag(idx uint16) uint16 {
+	if node.pairHeader() == 2 {
+		return 0
 	}
-	return kvs
+	pos := node.kvPos(idx)
+	return binary.LittleEndian.Uint16(node.data[pos+2:]) & VAL_OVERFLOW
+}
+
+func (node BNode) setValFlag(idx uint16, flag uint16) {
+	pos := node.kvPos(idx)
+	vlen := binary.LittleEndian.Uint16(node.data[pos+2:]) &^ VAL_OVERFLOW
+	binary.LittleEndian.PutUint16(node.data[pos+2:], vlen|flag)
 }
 
 // Tells you the size of byte array
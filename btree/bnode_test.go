@@ -0,0 +1,186 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+// a node in any format version, encoded without the code under test.
+// the keys are whole, the prefix is removed from them.
+func encodeNode(version uint16, btype uint16, prefix []byte, keys [][]byte, vals [][]byte, ptrs []uint64) BNode {
+	node := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
+	n := len(keys)
+	binary.LittleEndian.PutUint16(node.data[0:], version<<8|btype)
+	binary.LittleEndian.PutUint16(node.data[2:], uint16(n))
+	pos := HEADER_SIZE
+	if version != BNODE_V0 {
+		binary.LittleEndian.PutUint16(node.data[pos:], uint16(len(prefix)))
+		pos += 2 + copy(node.data[pos+2:], prefix)
+	}
+	if !(version == BNODE_V2 && btype == BNODE_LEAF) {
+		for i := 0; i < n; i++ {
+			if ptrs != nil {
+				binary.LittleEndian.PutUint64(node.data[pos+8*i:], ptrs[i])
+			}
+		}
+		pos += 8 * n
+	}
+	offsets, kv := pos, pos+2*n
+	pos = kv
+	for i, key := range keys {
+		suffix := key[len(prefix):]
+		val := []byte(nil)
+		if vals != nil {
+			val = vals[i]
+		}
+		binary.LittleEndian.PutUint16(node.data[pos:], uint16(len(suffix)))
+		if version == BNODE_V2 && btype == BNODE_NODE {
+			pos += 2
+		} else {
+			binary.LittleEndian.PutUint16(node.data[pos+2:], uint16(len(val)))
+			pos += 4
+		}
+		pos += copy(node.data[pos:], suffix)
+		pos += copy(node.data[pos:], val)
+		binary.LittleEndian.PutUint16(node.data[offsets+2*i:], uint16(pos-kv))
+	}
+	assertCondition(pos <= BTREE_PAGE_SIZE)
+	return node
+}
+
+// the pairs of a node as in the test cases
+func nodeContent(node BNode) (keys [][]byte, vals [][]byte, ptrs []uint64) {
+	for i := uint16(0); i < node.nkeys(); i++ {
+		keys = append(keys, node.getKey(i))
+		if node.btype() == BNODE_LEAF {
+			vals = append(vals, node.getVal(i))
+		} else {
+			ptrs = append(ptrs, node.getPtr(i))
+		}
+	}
+	return keys, vals, ptrs
+}
+
+func checkContent(t *testing.T, node BNode, keys [][]byte, vals [][]byte, ptrs []uint64) {
+	t.Helper()
+	if msg := nodeFormatError(node); msg != "" {
+		t.Fatalf("format: %s", msg)
+	}
+	gotKeys, gotVals, gotPtrs := nodeContent(node)
+	if fmt.Sprint(gotKeys) != fmt.Sprint(keys) {
+		t.Fatalf("keys: %q, want %q", gotKeys, keys)
+	}
+	if fmt.Sprint(gotVals) != fmt.Sprint(vals) || fmt.Sprint(gotPtrs) != fmt.Sprint(ptrs) {
+		t.Fatalf("values: %q %v, want %q %v", gotVals, gotPtrs, vals, ptrs)
+	}
+}
+
+// the keys user/NNNN, the prefix of the version 1 is not empty
+func testNode(version uint16, btype uint16, first int, n int) (BNode, [][]byte, [][]byte, []uint64) {
+	var keys, vals [][]byte
+	var ptrs []uint64
+	for i := first; i < first+n; i++ {
+		keys = append(keys, []byte(fmt.Sprintf("user/%04d", i)))
+		if btype == BNODE_LEAF {
+			vals = append(vals, []byte(fmt.Sprintf("val%d", i)))
+		} else {
+			ptrs = append(ptrs, uint64(100+i))
+		}
+	}
+	prefix := []byte(nil)
+	if version != BNODE_V0 {
+		prefix = []byte("user/")
+	}
+	return encodeNode(version, btype, prefix, keys, vals, ptrs), keys, vals, ptrs
+}
+
+func TestNodeFormats(t *testing.T) {
+	for _, version := range []uint16{BNODE_V0, BNODE_V1, BNODE_V2} {
+		for _, btype := range []uint16{BNODE_NODE, BNODE_LEAF} {
+			t.Run(fmt.Sprintf("v%d/%d", version, btype), func(t *testing.T) {
+				node, keys, vals, ptrs := testNode(version, btype, 10, 50)
+				if node.version() != version || node.btype() != btype || node.nkeys() != 50 {
+					t.Fatalf("header: %d %d %d", node.version(), node.btype(), node.nkeys())
+				}
+				checkContent(t, node, keys, vals, ptrs)
+				// the lookups, including the keys outside the prefix
+				for i, key := range keys {
+					if idx, ok := nodeLookup(node, key); int(idx) != i || !ok {
+						t.Fatalf("lookup %q: %d %v", key, idx, ok)
+					}
+					if idx := nodeLookupLE(node, append(key, 0)); int(idx) != i {
+						t.Fatalf("lookup after %q: %d", key, idx)
+					}
+				}
+				if idx, ok := nodeLookup(node, []byte("a")); idx != 0 || ok {
+					t.Fatalf("lookup below: %d %v", idx, ok)
+				}
+				if idx := nodeLookupLE(node, []byte("z")); idx != 49 {
+					t.Fatalf("lookup above: %d", idx)
+				}
+
+				// the copies are in version 2, the prefix is recomputed
+				// only by nodeCompress
+				prefixes := [][]byte{node.prefix(), []byte("user/00")}
+				for i, new := range []BNode{nodeSlice(node, 0, 50), nodeCompress(node)} {
+					if new.version() != BNODE_V2 || !bytes.Equal(new.prefix(), prefixes[i]) {
+						t.Fatalf("copy: version %d, prefix %q", new.version(), new.prefix())
+					}
+					checkContent(t, new, keys, vals, ptrs)
+				}
+				new := nodeSlice(node, 20, 30)
+				checkContent(t, new, keys[20:30], sliceOrNil(vals, 20, 30), sliceOrNil(ptrs, 20, 30))
+			})
+		}
+	}
+}
+
+func sliceOrNil[T any](s []T, from int, to int) []T {
+	if s == nil {
+		return nil
+	}
+	return s[from:to]
+}
+
+// the nodes of different versions and prefixes in one node
+func TestNodeMergeFormats(t *testing.T) {
+	for _, btype := range []uint16{BNODE_NODE, BNODE_LEAF} {
+		for _, left := range []uint16{BNODE_V0, BNODE_V1, BNODE_V2} {
+			for _, right := range []uint16{BNODE_V0, BNODE_V1, BNODE_V2} {
+				t.Run(fmt.Sprintf("%d/v%d+v%d", btype, left, right), func(t *testing.T) {
+					l, keys, vals, ptrs := testNode(left, btype, 0, 20)
+					r, rkeys, rvals, rptrs := testNode(right, btype, 990, 20)
+					merged := BNode{data: make([]byte, 2*BTREE_PAGE_SIZE)}
+					nodeMerge(merged, l, r)
+					keys, vals, ptrs = append(keys, rkeys...), append(vals, rvals...), append(ptrs, rptrs...)
+					checkContent(t, nodeCompress(merged), keys, vals, ptrs)
+					if len(nodeCompress(merged).prefix()) != len("user/") {
+						t.Fatalf("prefix: %q", nodeCompress(merged).prefix())
+					}
+				})
+			}
+		}
+	}
+}
+
+// the internal nodes of version 2 have no values and the leaves no pointers
+func TestNodeV2Layout(t *testing.T) {
+	keys := [][]byte{[]byte("ab1"), []byte("ab2")}
+	leaf := encodeNode(BNODE_V2, BNODE_LEAF, []byte("ab"), keys, [][]byte{[]byte("x"), []byte("y")}, nil)
+	internal := encodeNode(BNODE_V2, BNODE_NODE, []byte("ab"), keys, nil, []uint64{7, 8})
+	// header, prefix, offsets, pairs
+	if want := HEADER_SIZE + 4 + 2*2 + 2*(4+1+1); int(leaf.nbytes()) != want {
+		t.Fatalf("leaf size %d, want %d", leaf.nbytes(), want)
+	}
+	// header, prefix, pointers, offsets, pairs
+	if want := HEADER_SIZE + 4 + 2*8 + 2*2 + 2*(2+1); int(internal.nbytes()) != want {
+		t.Fatalf("internal size %d, want %d", internal.nbytes(), want)
+	}
+	if leaf.ptrSize() != 0 || internal.getVal(1) != nil || internal.getPtr(1) != 8 {
+		t.Fatal("layout")
+	}
+	checkContent(t, leaf, keys, [][]byte{[]byte("x"), []byte("y")}, nil)
+	checkContent(t, internal, keys, nil, []uint64{7, 8})
+}
@@ -51,11 +51,13 @@ func newC(t *testing.T) *C {
 }
 
 func (c *C) add(key string, val string) {
-	c.tree.Insert([]byte(key), []byte(val))
+	assertCondition(c.tree.Insert([]byte(key), []byte(val)) == nil)
 	c.ref[key] = val
 }
 
 func (c *C) Del(key string) bool {
 	delete(c.ref, key)
-	return c.tree.Delete([]byte(key))
+	deleted, err := c.tree.Delete([]byte(key))
+	assertCondition(err == nil)
+	return deleted
 }
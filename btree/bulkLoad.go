@@ -0,0 +1,214 @@
+package btree
+
+import "bytes"
+
+// The bulk load builds a new tree bottom-up from keys in ascending order,
+// instead of inserting them one by one. The nodes of each level are filled
+// up to the fill factor and written once, the first key of each full node
+// goes to the level above. The keys of the old tree are merged in, the
+// added values replace the old ones, and the old nodes are freed when the
+// new tree replaces the old one. The old values in overflow pages are
+// moved to the new tree without copying.
+
+const BULK_FILL = 0.9 // the default fill factor
+
+// BulkLoader adds keys to a new tree, see BTree.BulkLoad.
+type BulkLoader struct {
+	tree    *BTree
+	limit   int         // the page size times the fill factor
+	old     *BIter      // the next key of the old tree to merge
+	oldRoot uint64      // freed by Finish
+	levels  []bulkLevel // the nodes being filled, the leaves first
+	last    []byte      // the last key added
+	check   func(error) error
+	err     error // the loader can't be used after an error
+}
+
+// the keys of the node being filled at a level
+type bulkLevel struct {
+	keys    [][]byte
+	vals    [][]byte // the leaves, overflow references for VAL_OVERFLOW
+	flags   []uint16
+	ptrs    []uint64 // the internal nodes
+	payload int      // the bytes of the keys and the values
+}
+
+// BulkLoad starts building a new tree, it replaces the tree on Finish.
+// the fill factor is in (0, 1], the nodes are filled up to this part of
+// a page. the tree must not be modified until Finish.
+func (tree *BTree) BulkLoad(fill float64) (*BulkLoader, error) {
+	if !(fill > 0 && fill <= 1) {
+		return nil, ErrBadFill
+	}
+	bl := &BulkLoader{
+		tree:    tree,
+		limit:   int(fill * BTREE_PAGE_SIZE),
+		oldRoot: tree.root,
+		check:   func(err error) error { return err },
+	}
+	// the dummy key of the new tree, the old one is skipped
+	bl.add(0, nil, nil, 0, 0)
+	if tree.root != 0 {
+		err := func() (err error) {
+			defer recoverCorrupt(&err)
+			bl.old = tree.SeekLE(nil)
+			bl.old.Next()
+			return nil
+		}()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return bl, nil
+}
+
+// Add adds a key greater than the keys added before.
+// On ErrCorrupt the loader fails and the tree is unchanged.
+func (bl *BulkLoader) Add(key []byte, val []byte) (err error) {
+	if bl.err != nil {
+		return bl.err
+	}
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if len(val) > BTREE_MAX_BLOB_SIZE {
+		return ErrValueTooLarge
+	}
+	if bl.last != nil && bytes.Compare(key, bl.last) <= 0 {
+		return ErrUnsorted
+	}
+	defer bl.fail(&err)
+	// the old keys before it, an old key replaced is dropped
+	for bl.old != nil && bl.old.Valid() {
+		cmp := bytes.Compare(bl.old.Key(), key)
+		if cmp > 0 {
+			break
+		}
+		if cmp == 0 {
+			leaf := bl.old.path[len(bl.old.path)-1]
+			if idx := bl.old.pos[len(bl.old.pos)-1]; leaf.valFlag(idx) == VAL_OVERFLOW {
+				overflowFree(bl.tree, leaf.getVal(idx))
+			}
+		} else {
+			bl.addOld()
+		}
+		bl.old.Next()
+	}
+	stored, flag := leafStore(bl.tree, val)
+	bl.last = append([]byte{}, key...)
+	bl.add(0, bl.last, append([]byte{}, stored...), flag, 0)
+	return nil
+}
+
+// Finish writes the nodes left and replaces the tree with the new one.
+func (bl *BulkLoader) Finish() (err error) {
+	if bl.err != nil {
+		return bl.err
+	}
+	defer bl.fail(&err)
+	for bl.old != nil && bl.old.Valid() {
+		bl.addOld()
+		bl.old.Next()
+	}
+	root := uint64(0)
+	for level := 0; root == 0; level++ {
+		lvl := &bl.levels[level]
+		switch {
+		case level+1 < len(bl.levels):
+			first, ptr := bl.flush(level)
+			bl.add(level+1, first, nil, 0, ptr)
+		case level > 0 && len(lvl.keys) == 1:
+			root = lvl.ptrs[0] // no root with a single kid
+		default:
+			_, root = bl.flush(level)
+		}
+	}
+	treeFree(bl.tree, bl.oldRoot)
+	bl.tree.root = root
+	bl.err = errBulkDone
+	return nil
+}
+
+// the loader fails on ErrCorrupt, used with defer
+func (bl *BulkLoader) fail(err *error) {
+	recoverCorrupt(err)
+	if *err != nil {
+		bl.err = bl.check(*err)
+	}
+}
+
+// the key of the old tree at the iterator, its value is moved as is
+func (bl *BulkLoader) addOld() {
+	leaf := bl.old.path[len(bl.old.path)-1]
+	idx := bl.old.pos[len(bl.old.pos)-1]
+	key := append([]byte{}, leaf.getKey(idx)...)
+	val := append([]byte{}, leaf.getVal(idx)...)
+	bl.add(0, key, val, leaf.valFlag(idx), 0)
+}
+
+// add a key to the node of a level, the node is written first if the key
+// would take it over the fill factor. a node has at least 2 keys if they
+// fit, otherwise the levels would never narrow.
+func (bl *BulkLoader) add(level int, key []byte, val []byte, flag uint16, ptr uint64) {
+	if level == len(bl.levels) {
+		bl.levels = append(bl.levels, bulkLevel{})
+	}
+	lvl := &bl.levels[level]
+	if n := len(lvl.keys); n > 0 {
+		btype := uint16(BNODE_NODE)
+		if level == 0 {
+			btype = BNODE_LEAF
+		}
+		size := pageSize(btype, n+1, lvl.payload+len(key)+len(val), commonPrefixLen(lvl.keys[0], key))
+		if size > BTREE_PAGE_SIZE || (size > bl.limit && n >= 2) {
+			first, ptr := bl.flush(level)
+			bl.add(level+1, first, nil, 0, ptr)
+			lvl = &bl.levels[level] // the slice may have grown
+		}
+	}
+	lvl.keys = append(lvl.keys, key)
+	lvl.payload += len(key) + len(val)
+	if level == 0 {
+		lvl.vals = append(lvl.vals, val)
+		lvl.flags = append(lvl.flags, flag)
+	} else {
+		lvl.ptrs = append(lvl.ptrs, ptr)
+	}
+}
+
+// write the node of a level, returns its first key and its pointer
+func (bl *BulkLoader) flush(level int) ([]byte, uint64) {
+	lvl := &bl.levels[level]
+	n := len(lvl.keys)
+	prefix := lvl.keys[0][:commonPrefixLen(lvl.keys[0], lvl.keys[n-1])]
+	node := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
+	if level == 0 {
+		node.setPrefixHeader(BNODE_LEAF, uint16(n), prefix)
+		for i := range lvl.keys {
+			nodeAppendKV(node, uint16(i), 0, lvl.keys[i], lvl.vals[i])
+			node.setValFlag(uint16(i), lvl.flags[i])
+		}
+	} else {
+		node.setPrefixHeader(BNODE_NODE, uint16(n), prefix)
+		for i := range lvl.keys {
+			nodeAppendKV(node, uint16(i), lvl.ptrs[i], lvl.keys[i], nil)
+		}
+	}
+	first := lvl.keys[0]
+	*lvl = bulkLevel{}
+	return first, bl.tree.newNode(node)
+}
+
+// deallocate the nodes of a tree, not the overflow pages
+func treeFree(tree *BTree, ptr uint64) {
+	if ptr == 0 {
+		return
+	}
+	node := tree.node(ptr)
+	if node.btype() == BNODE_NODE {
+		for i := uint16(0); i < node.nkeys(); i++ {
+			treeFree(tree, node.getPtr(i))
+		}
+	}
+	tree.del(ptr)
+}
@@ -0,0 +1,145 @@
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// a value in an overflow page for some keys
+func bulkVal(i int, tag string) string {
+	val := fmt.Sprintf("%s%d", tag, i)
+	if i%37 == 0 {
+		val = strings.Repeat(val, 1500)
+	}
+	return val
+}
+
+// bulk load into a tree with keys: the old keys are kept, the duplicates
+// are replaced and the new keys go before, between and after them
+func TestBulkLoadMerge(t *testing.T) {
+	for _, fill := range []float64{0.3, BULK_FILL, 1} {
+		t.Run(fmt.Sprintf("fill=%v", fill), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "db")
+			db := openKV(t, path, false)
+			ref := map[string]string{}
+			// the old keys 1000..2999, odd ones only
+			for i := 1001; i < 3000; i += 2 {
+				key, val := fmt.Sprintf("k%05d", i), bulkVal(i, "old")
+				if err := db.Set([]byte(key), []byte(val)); err != nil {
+					t.Fatal(err)
+				}
+				ref[key] = val
+			}
+
+			// 0..999 before, the even keys between, the odd keys of
+			// 2000..2999 replaced, and 3000..3999 after
+			keys := []int{}
+			for i := 0; i < 4000; i++ {
+				if i < 1000 || i%2 == 0 || i >= 2000 {
+					keys = append(keys, i)
+				}
+			}
+			tx := db.Begin()
+			bl, err := tx.BulkLoad(fill)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, i := range keys {
+				key, val := fmt.Sprintf("k%05d", i), bulkVal(i+1, "new")
+				if err := bl.Add([]byte(key), []byte(val)); err != nil {
+					t.Fatal(err)
+				}
+				ref[key] = val
+			}
+			// the same key twice
+			last := fmt.Sprintf("k%05d", keys[len(keys)-1])
+			if err := bl.Add([]byte(last), []byte("again")); !errors.Is(err, ErrUnsorted) {
+				t.Fatalf("duplicate: %v", err)
+			}
+			if err := bl.Finish(); err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			checkKV(t, db, ref)
+
+			// the old value of a replaced key is gone, the others are kept
+			if val, _, _ := db.Get([]byte("k02001")); string(val) != bulkVal(2002, "new") {
+				t.Fatalf("replaced: %q", val)
+			}
+			if val, _, _ := db.Get([]byte("k01001")); string(val) != bulkVal(1001, "old") {
+				t.Fatalf("kept: %q", val)
+			}
+			// in order
+			got := []string{}
+			iter := db.Scan(nil, nil)
+			for ; iter.Valid(); iter.Next() {
+				got = append(got, string(iter.Key()))
+			}
+			iter.Close()
+			if len(got) != len(ref) || !sort.StringsAreSorted(got) {
+				t.Fatalf("%d keys, sorted %v", len(got), sort.StringsAreSorted(got))
+			}
+			db.Close()
+			if r := checkVerify(t, path); r.Keys != len(ref) || r.Overflows == 0 {
+				t.Fatalf("%d keys, %d overflow pages", r.Keys, r.Overflows)
+			}
+
+			// the tree is still usable
+			db = openKV(t, path, false)
+			defer db.Close()
+			for i := 0; i < 4000; i += 3 {
+				key := fmt.Sprintf("k%05d", i)
+				if _, err := db.Del([]byte(key)); err != nil {
+					t.Fatal(err)
+				}
+				delete(ref, key)
+			}
+			checkKV(t, db, ref)
+		})
+	}
+}
+
+// the old keys all replaced by the same keys, or none added at all
+func TestBulkLoadSameKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := openKV(t, path, false)
+	ref := map[string]string{}
+	for i := 0; i < 2000; i++ {
+		key, val := fmt.Sprintf("k%05d", i), bulkVal(i, "old")
+		if err := db.Set([]byte(key), []byte(val)); err != nil {
+			t.Fatal(err)
+		}
+		ref[key] = val
+	}
+	for _, tag := range []string{"new", ""} {
+		tx := db.Begin()
+		bl, err := tx.BulkLoad(BULK_FILL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2000 && tag != ""; i++ {
+			key, val := fmt.Sprintf("k%05d", i), bulkVal(i+1, tag)
+			if err := bl.Add([]byte(key), []byte(val)); err != nil {
+				t.Fatal(err)
+			}
+			ref[key] = val
+		}
+		if err := bl.Finish(); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		checkKV(t, db, ref)
+	}
+	db.Close()
+	if r := checkVerify(t, path); r.Keys != 2000 {
+		t.Fatalf("%d keys", r.Keys)
+	}
+}
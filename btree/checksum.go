@@ -0,0 +1,57 @@
+package btree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// Every page written to the file carries a CRC32C of its content in the
+// node header, computed with the checksum field itself treated as empty.
+// | Type (2B) | ... (2B) | Checksum (4B) | ...
+// The page is verified when it's read from the mmap.
+
+// CorruptError reports a page that can't be trusted.
+type CorruptError struct {
+	Page   uint64
+	Reason string
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("corrupted page %d: %s", e.Page, e.Reason)
+}
+
+// errors.Is(err, ErrCorrupt) is true for any corrupted page
+func (e *CorruptError) Unwrap() error {
+	return ErrCorrupt
+}
+
+func pageChecksum(data []byte) uint32 {
+	sum := crc32.Checksum(data[:4], crc32c)
+	return crc32.Update(sum, crc32c, data[8:BTREE_PAGE_SIZE])
+}
+
+func setChecksum(data []byte) {
+	binary.LittleEndian.PutUint32(data[4:8], pageChecksum(data))
+}
+
+// the B-tree and the free list callbacks can't return errors,
+// a bad page aborts the operation with a panic caught by recoverCorrupt.
+func verifyPage(ptr uint64, node BNode) BNode {
+	if binary.LittleEndian.Uint32(node.data[4:8]) != pageChecksum(node.data) {
+		panic(&CorruptError{Page: ptr, Reason: "bad checksum"})
+	}
+	return node
+}
+
+// turn the panic from verifyPage and the node checks into an error,
+// used with defer
+func recoverCorrupt(err *error) {
+	if r := recover(); r != nil {
+		corrupt, ok := r.(*CorruptError)
+		if !ok {
+			panic(r)
+		}
+		*err = corrupt
+	}
+}
@@ -0,0 +1,209 @@
+package btree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Compaction.
+// The file never shrinks, the freed pages are only reused. Compact copies
+// the pages in use into a new file without the free list: the tree in
+// depth-first order, each value in overflow pages next to its leaf. The new
+// file replaces the old one with an atomic rename, like
+// filedb.SaveDataWithBetterPersistenceUsingFsync. The updates wait for the
+// copy, the readers don't: the readers started before keep the mapping of
+// the old file, it is unmapped when the last of them ends.
+
+const COMPACT_SUFFIX = ".compact" // the new file before the rename
+
+// a file replaced by Compact
+type retiredFile struct {
+	fp      *os.File
+	chunks  [][]byte
+	readers map[*KVReader]struct{} // still using it
+}
+
+func (old *retiredFile) close() {
+	for _, chunk := range old.chunks {
+		err := syscall.Munmap(chunk)
+		assertCondition(err == nil)
+	}
+	_ = old.fp.Close()
+}
+
+// the reader is ended, unmap the old files it was the last reader of.
+// the caller holds db.mu.
+func endRetired(db *KV, reader *KVReader) {
+	kept := db.retired[:0]
+	for _, old := range db.retired {
+		delete(old.readers, reader)
+		if len(old.readers) == 0 {
+			old.close()
+		} else {
+			kept = append(kept, old)
+		}
+	}
+	db.retired = kept
+}
+
+// the new file being written
+type compactor struct {
+	tree *BTree
+	w    *bufio.Writer
+	next uint64 // the pointer of the next page written
+}
+
+// append a page, returns its pointer in the new file
+func (c *compactor) write(node BNode) uint64 {
+	setChecksum(node.data)
+	_, _ = c.w.Write(node.data) // the error is returned by Flush
+	c.next++
+	return c.next - 1
+}
+
+// copy a subtree, the kids first to have their new pointers
+func (c *compactor) copyTree(ptr uint64) uint64 {
+	node := c.tree.node(ptr)
+	new := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
+	copy(new.data, node.data)
+	for i := uint16(0); i < node.nkeys(); i++ {
+		switch {
+		case node.btype() == BNODE_NODE:
+			new.setPtr(i, c.copyTree(node.getPtr(i)))
+		case node.valFlag(i) == VAL_OVERFLOW:
+			ref := new.getVal(i)
+			binary.LittleEndian.PutUint64(ref[8:16], c.copyOverflow(ref))
+		}
+	}
+	return c.write(new)
+}
+
+// copy the pages of a value in order, returns the first one
+func (c *compactor) copyOverflow(ref []byte) uint64 {
+	size := binary.LittleEndian.Uint64(ref[0:8])
+	ptr := binary.LittleEndian.Uint64(ref[8:16])
+	first := c.next
+	for npages := (size + OVERFLOW_CAP - 1) / OVERFLOW_CAP; npages > 0; npages-- {
+		node := overflowPage(c.tree, ptr)
+		new := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
+		copy(new.data, node.data)
+		ptr = binary.LittleEndian.Uint64(node.data[8:16])
+		next := uint64(0)
+		if npages > 1 {
+			next = c.next + 1
+		}
+		binary.LittleEndian.PutUint64(new.data[8:16], next)
+		c.write(new)
+	}
+	return first
+}
+
+// write the new file, returns the new root and the number of pages.
+// On ErrCorrupt the file is incomplete.
+func compactWrite(db *KV, fp *os.File) (root uint64, npages uint64, err error) {
+	defer recoverCorrupt(&err)
+	c := &compactor{tree: &db.tree, w: bufio.NewWriter(fp), next: 1}
+	if _, err := fp.Seek(BTREE_PAGE_SIZE, 0); err != nil {
+		return 0, 0, err
+	}
+	if db.tree.root != 0 {
+		root = c.copyTree(db.tree.root)
+	}
+	if err := c.w.Flush(); err != nil {
+		return 0, 0, fmt.Errorf("write: %w", err)
+	}
+	// the master page, with an empty free list
+	meta := saveMeta(db)
+	binary.LittleEndian.PutUint64(meta[16:], root)
+	binary.LittleEndian.PutUint64(meta[24:], c.next)
+	clear(meta[32:MASTER_SIZE])
+	if _, err := fp.WriteAt(masterSlot(meta, 1), 0); err != nil {
+		return 0, 0, fmt.Errorf("write master page: %w", err)
+	}
+	// the master page is the whole file if the tree is empty
+	if err := fp.Truncate(int64(c.next) * BTREE_PAGE_SIZE); err != nil {
+		return 0, 0, fmt.Errorf("truncate: %w", err)
+	}
+	if err := fp.Sync(); err != nil {
+		return 0, 0, fmt.Errorf("fsync: %w", err)
+	}
+	return root, c.next, nil
+}
+
+// Compact rewrites the pages in use into a new file that replaces the
+// database file, the space of the free pages is returned to the system.
+// The readers continue, the updates wait until it's done.
+// In the WAL mode the log is checkpointed first.
+func (db *KV) Compact() error {
+	db.writer.Lock()
+	defer db.writer.Unlock()
+	assertCondition(len(db.page.updates) == 0 && db.page.nappend == 0)
+	if db.wal.fp != nil {
+		if err := checkpoint(db); err != nil {
+			return err
+		}
+	}
+
+	path := db.Path + COMPACT_SUFFIX
+	fp, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("compact: %w", err)
+	}
+	root, npages, err := compactWrite(db, fp)
+	var chunk []byte
+	if err == nil {
+		// mapped before the rename, nothing can fail after it
+		_, chunk, err = mmapInit(fp)
+	}
+	if err == nil {
+		if err = os.Rename(path, db.Path); err != nil {
+			_ = syscall.Munmap(chunk)
+		}
+	}
+	if err != nil {
+		_ = fp.Close()
+		_ = os.Remove(path)
+		return fmt.Errorf("compact: %w", err)
+	}
+
+	// switch to the new file, the current readers keep the old one
+	db.mu.Lock()
+	old := &retiredFile{fp: db.fp, chunks: db.mmap.chunks, readers: db.readers}
+	db.readers = map[*KVReader]struct{}{}
+	if len(old.readers) > 0 {
+		db.retired = append(db.retired, old)
+	}
+	db.fp = fp
+	db.mmap.file = int(npages) * BTREE_PAGE_SIZE
+	db.mmap.total = len(chunk)
+	db.mmap.chunks = [][]byte{chunk}
+	db.mu.Unlock()
+	if len(old.readers) == 0 {
+		old.close()
+	}
+	db.tree.root = root
+	db.page.flushed = npages
+	db.free.headPage, db.free.headSeq = 0, 0
+	db.free.tailPage, db.free.tailSeq = 0, 0
+	db.master.slot, db.master.seq = 0, 1
+	db.failed = false
+	publish(db)
+	return syncDir(db.Path)
+}
+
+// make the rename durable
+func syncDir(path string) error {
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("fsync: %w", err)
+	}
+	return nil
+}
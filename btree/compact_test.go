@@ -0,0 +1,115 @@
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi.Size()
+}
+
+func TestCompactWithReader(t *testing.T) {
+	for _, wal := range []bool{false, true} {
+		t.Run(fmt.Sprintf("wal=%v", wal), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "db")
+			db := openKV(t, path, wal)
+			ref := map[string]string{}
+			for i := 0; i < 5000; i++ {
+				key, val := fmt.Sprintf("key%05d", i), fmt.Sprint(i)
+				if i%50 == 0 || i%50 == 5 {
+					val = strings.Repeat(val, 2000) // in overflow pages
+				}
+				if err := db.Set([]byte(key), []byte(val)); err != nil {
+					t.Fatal(err)
+				}
+				ref[key] = val
+			}
+			for i := 0; i < 5000; i++ {
+				if i%10 == 0 {
+					continue
+				}
+				key := fmt.Sprintf("key%05d", i)
+				if _, err := db.Del([]byte(key)); err != nil {
+					t.Fatal(err)
+				}
+				delete(ref, key)
+			}
+			// the reader started before keeps the old file
+			reader := db.BeginRead()
+			before := fileSize(t, path)
+			if err := db.Compact(); err != nil {
+				t.Fatal(err)
+			}
+			if after := fileSize(t, path); after >= before/2 {
+				t.Fatalf("size %d -> %d", before, after)
+			}
+			if _, err := os.Stat(path + COMPACT_SUFFIX); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("the new file is left: %v", err)
+			}
+			// updated after the compaction, not seen by the reader
+			if err := db.Set([]byte("key00000"), []byte("new")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.Del([]byte("key00010")); err != nil {
+				t.Fatal(err)
+			}
+			n := 0
+			for iter := reader.Scan(nil, nil); iter.Valid(); iter.Next() {
+				if ref[string(iter.Key())] != string(iter.Val()) {
+					t.Fatalf("reader: key %q", iter.Key())
+				}
+				n++
+			}
+			if n != len(ref) {
+				t.Fatalf("reader: %d keys, want %d", n, len(ref))
+			}
+			if len(db.retired) != 1 {
+				t.Fatalf("%d retired files", len(db.retired))
+			}
+			db.EndRead(reader)
+			if len(db.retired) != 0 {
+				t.Fatal("the old file is still mapped")
+			}
+
+			ref["key00000"] = "new"
+			delete(ref, "key00010")
+			checkKV(t, db, ref)
+			db.Close()
+			if r := checkVerify(t, path); r.Keys != len(ref) {
+				t.Fatalf("%d keys", r.Keys)
+			}
+			db = openKV(t, path, wal)
+			checkKV(t, db, ref)
+			db.Close()
+		})
+	}
+}
+
+func TestCompactEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := openKV(t, path, false)
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if size := fileSize(t, path); size != BTREE_PAGE_SIZE {
+		t.Fatalf("size %d", size)
+	}
+	if err := db.Set([]byte("a"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	checkVerify(t, path)
+	db = openKV(t, path, false)
+	checkKV(t, db, map[string]string{"a": "b"})
+	db.Close()
+}
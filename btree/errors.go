@@ -0,0 +1,32 @@
+package btree
+
+import "errors"
+
+// errors returned by the B-tree and the KV.
+// the invalid inputs are rejected before anything is modified.
+var (
+	ErrEmptyKey      = errors.New("empty key")
+	ErrKeyTooLarge   = errors.New("key too large")
+	ErrValueTooLarge = errors.New("value too large")
+	ErrBadMode       = errors.New("bad update mode")
+	ErrUnsorted      = errors.New("keys not in ascending order")
+	ErrBadFill       = errors.New("the fill factor must be in (0, 1]")
+	// the file was written before the page checksums, see Upgrade
+	ErrOldFormat = errors.New("the file is of an older format, it must be upgraded")
+	// the data read from the file is bad, see CorruptError
+	ErrCorrupt = errors.New("corrupted data")
+)
+
+// a BulkLoader after Finish
+var errBulkDone = errors.New("the bulk load is finished")
+
+// the empty key is the dummy key of the leftmost leaf, it can't be used
+func checkKey(key []byte) error {
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	if len(key) > BTREE_MAX_KEY_SIZE {
+		return ErrKeyTooLarge
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package btree
+
+import "encoding/binary"
+
+// free list node structure
+// | Type (2B) | Unused (2B) | Checksum (4B) | Next (8B) | Pointers (8B each) |
+// The list is a queue of deallocated pages: freed pages are pushed to the
+// tail and reused from the head. The items are addressed by monotonic
+// sequence numbers which, together with the head and tail nodes, are kept
+// in the master page.
+const FREE_LIST_HEADER = 8 + 8
+const FREE_LIST_CAP = (BTREE_PAGE_SIZE - FREE_LIST_HEADER) / 8
+
+type FreeList struct {
+	// callbacks for managing on-disk pages
+	get func(uint64) BNode  // dereference a pointer
+	new func(BNode) uint64  // append a new page
+	use func(uint64, BNode) // reuse a page
+	set func(uint64) BNode  // update an existing page
+	// persisted data in the master page
+	headPage uint64 // pointer to the list head node
+	headSeq  uint64 // monotonic sequence number to index into the list head
+	tailPage uint64
+	tailSeq  uint64
+	// in-memory states
+	maxSeq uint64 // saved `tailSeq` to prevent consuming newly added items
+}
+
+func flnNext(node BNode) uint64 {
+	return binary.LittleEndian.Uint64(node.data[8:16])
+}
+
+func flnSetNext(node BNode, next uint64) {
+	binary.LittleEndian.PutUint64(node.data[8:16], next)
+}
+
+func flnPtr(node BNode, idx int) uint64 {
+	pos := FREE_LIST_HEADER + 8*idx
+	return binary.LittleEndian.Uint64(node.data[pos:])
+}
+
+func flnSetPtr(node BNode, idx int, ptr uint64) {
+	pos := FREE_LIST_HEADER + 8*idx
+	binary.LittleEndian.PutUint64(node.data[pos:], ptr)
+}
+
+func seq2idx(seq uint64) int {
+	return int(seq % FREE_LIST_CAP)
+}
+
+// number of items in the list
+func (fl *FreeList) Total() int {
+	return int(fl.tailSeq - fl.headSeq)
+}
+
+// items at or after `maxSeq` can't be consumed, either because they were
+// added in the current update or they are still visible to a reader.
+func (fl *FreeList) SetMaxSeq(maxSeq uint64) {
+	assertCondition(fl.headSeq <= maxSeq && maxSeq <= fl.tailSeq)
+	fl.maxSeq = maxSeq
+}
+
+// get 1 item from the list head, returns 0 if nothing can be reused.
+func (fl *FreeList) PopHead() uint64 {
+	ptr, head := flPop(fl)
+	if head != 0 {
+		// the empty head node is recycled
+		fl.PushTail(head)
+	}
+	return ptr
+}
+
+// remove 1 item from the head node, and remove the head node if empty.
+func flPop(fl *FreeList) (ptr uint64, head uint64) {
+	if fl.headSeq == fl.maxSeq {
+		return 0, 0 // cannot advance
+	}
+	node := fl.get(fl.headPage)
+	ptr = flnPtr(node, seq2idx(fl.headSeq))
+	fl.headSeq++
+	// move to the next one if the head node is empty
+	if seq2idx(fl.headSeq) == 0 {
+		head, fl.headPage = fl.headPage, flnNext(node)
+		assertCondition(fl.headPage != 0)
+	}
+	return ptr, head
+}
+
+// add 1 item to the tail
+func (fl *FreeList) PushTail(ptr uint64) {
+	if fl.tailPage == 0 {
+		// the list is created on the first use
+		fl.tailPage = fl.new(flnEmpty())
+		fl.headPage = fl.tailPage
+	}
+	// add it to the tail node
+	flnSetPtr(fl.set(fl.tailPage), seq2idx(fl.tailSeq), ptr)
+	fl.tailSeq++
+	// add a new tail node if it's full (the list is never empty)
+	if seq2idx(fl.tailSeq) == 0 {
+		// try to reuse from the list head
+		next, head := flPop(fl) // may remove the head node
+		if next == 0 {
+			// or allocate a new node by appending
+			next = fl.new(flnEmpty())
+		} else {
+			fl.use(next, flnEmpty())
+		}
+		// link to the new tail node
+		flnSetNext(fl.set(fl.tailPage), next)
+		fl.tailPage = next
+		// also add the head node if it's removed
+		if head != 0 {
+			flnSetPtr(fl.set(fl.tailPage), 0, head)
+			fl.tailSeq++
+		}
+	}
+}
+
+func flnEmpty() BNode {
+	node := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
+	binary.LittleEndian.PutUint16(node.data[0:2], BNODE_FREE_LIST)
+	return node
+}
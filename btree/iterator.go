@@ -0,0 +1,156 @@
+package btree
+
+import "bytes"
+
+// comparison modes for BTree.Seek
+const (
+	CMP_GE = +3 // >=
+	CMP_GT = +2 // >
+	CMP_LT = -2 // <
+	CMP_LE = -3 // <=
+)
+
+// BIter walks the keys of a BTree in order.
+// The first key of the leftmost leaf is the dummy key inserted with the
+// first node, it acts as the position before the first real key.
+// Modifying the tree invalidates the iterator.
+type BIter struct {
+	tree *BTree
+	path []BNode  // from root to leaf
+	pos  []uint16 // indexes into nodes
+}
+
+// find the closest position that is less than or equal to the input key
+func (tree *BTree) SeekLE(key []byte) *BIter {
+	iter := &BIter{tree: tree}
+	for ptr := tree.root; ptr != 0; {
+		node := tree.node(ptr)
+		idx := nodeLookupLE(node, key)
+		iter.path = append(iter.path, node)
+		iter.pos = append(iter.pos, idx)
+		switch node.btype() {
+		case BNODE_NODE:
+			ptr = node.getPtr(idx)
+		case BNODE_LEAF:
+			ptr = 0
+		default:
+			panic("bad node")
+		}
+	}
+	return iter
+}
+
+// find the closest position to the input key satisfying the comparison
+func (tree *BTree) Seek(key []byte, cmp int) *BIter {
+	iter := tree.SeekLE(key)
+	if cmp != CMP_LE && len(iter.path) > 0 {
+		if iter.atDummy() || !cmpOK(iter.Key(), cmp, key) {
+			// off by one
+			if cmp > 0 {
+				iter.Next()
+			} else {
+				iter.Prev()
+			}
+		}
+	}
+	return iter
+}
+
+// key cmp ref
+func cmpOK(key []byte, cmp int, ref []byte) bool {
+	r := bytes.Compare(key, ref)
+	switch cmp {
+	case CMP_GE:
+		return r >= 0
+	case CMP_GT:
+		return r > 0
+	case CMP_LT:
+		return r < 0
+	case CMP_LE:
+		return r <= 0
+	default:
+		panic("bad cmp")
+	}
+}
+
+// the iterator is positioned at the dummy key of the leftmost leaf
+func (iter *BIter) atDummy() bool {
+	for _, idx := range iter.pos {
+		if idx != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// is the iterator positioned at a real key?
+func (iter *BIter) Valid() bool {
+	if len(iter.path) == 0 {
+		return false
+	}
+	leaf := len(iter.path) - 1
+	return iter.pos[leaf] < iter.path[leaf].nkeys() && !iter.atDummy()
+}
+
+// get the current key, only call this on a valid iterator
+func (iter *BIter) Key() []byte {
+	leaf := len(iter.path) - 1
+	return iter.path[leaf].getKey(iter.pos[leaf])
+}
+
+// get the current value, only call this on a valid iterator
+func (iter *BIter) Val() []byte {
+	leaf := len(iter.path) - 1
+	return leafValue(iter.tree, iter.path[leaf], iter.pos[leaf])
+}
+
+// move forward, past the last key the iterator becomes invalid
+func (iter *BIter) Next() {
+	if len(iter.path) == 0 {
+		return
+	}
+	leaf := len(iter.path) - 1
+	if !iterNext(iter, leaf) {
+		iter.pos[leaf] = iter.path[leaf].nkeys() // past the last key
+	}
+}
+
+// move backward, before the first key the iterator becomes invalid
+func (iter *BIter) Prev() {
+	if len(iter.path) == 0 {
+		return
+	}
+	iterPrev(iter, len(iter.path)-1)
+}
+
+// move to the next key at this level, the path below it is reloaded.
+// returns false if there is no next key, in which case nothing is changed.
+func iterNext(iter *BIter, level int) bool {
+	if iter.pos[level]+1 < iter.path[level].nkeys() {
+		iter.pos[level]++ // move within this node
+		return true
+	}
+	if level == 0 || !iterNext(iter, level-1) {
+		return false
+	}
+	parent := iter.path[level-1]
+	iter.path[level] = iter.tree.node(parent.getPtr(iter.pos[level-1]))
+	iter.pos[level] = 0
+	return true
+}
+
+// move to the previous key at this level, the path below it is reloaded.
+// returns false if there is no previous key, in which case nothing is changed.
+func iterPrev(iter *BIter, level int) bool {
+	if iter.pos[level] > 0 {
+		iter.pos[level]-- // move within this node
+		return true
+	}
+	if level == 0 || !iterPrev(iter, level-1) {
+		return false
+	}
+	parent := iter.path[level-1]
+	iter.path[level] = iter.tree.node(parent.getPtr(iter.pos[level-1]))
+	iter.pos[level] = iter.path[level].nkeys() - 1
+	return true
+}
@@ -5,80 +5,265 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"os"
+	"sync"
 	"syscall"
+
+	"killerDB/keyenc"
 )
 
 type KV struct {
 	Path string
+	WAL  bool // commit to a write-ahead log, see wal.go
 	// internals
 	fp   *os.File
 	tree BTree
+	free FreeList
 	mmap struct {
 		file   int      // file size, can be larger than the database size
 		total  int      // mmap size, can be larger than the file size
 		chunks [][]byte // multiple mmaps, can be non-continuous
 	}
-	page struct {
-		flushed uint64   // database size in number of pages
-		temp    [][]byte // newly allocated pages
+	failed bool // the last update failed, the master page must be rewritten
+	page   struct {
+		flushed uint64 // database size in number of pages
+		nappend int    // number of pages to be appended
+		// newly allocated or deallocated pages keyed by the pointer.
+		// nil value denotes a deallocated page.
+		updates map[uint64][]byte
+		// pages both allocated and deallocated by this update
+		pool []uint64
+	}
+	master struct {
+		slot int    // the slot of the current master page
+		seq  uint64 // incremented by each write of the master page
+	}
+	// concurrency control
+	writer  sync.Mutex // serializes the read-write transactions
+	mu      sync.Mutex // protects the fields below and mmap.chunks
+	readers map[*KVReader]struct{}
+	// the files replaced by Compact, mapped until their readers end
+	retired []*retiredFile
+	latest  struct {
+		// the last committed state, for starting new readers
+		version uint64
+		root    uint64
+		freeSeq uint64 // the free list tail
+	}
+	wal struct {
+		fp   *os.File
+		size int64 // the end of the last complete record
+		// the background checkpoint
+		kick chan struct{}
+		done chan struct{}
+		exit sync.WaitGroup
 	}
 }
 
-const DB_SIG = "BuildYourOwnDB05"
+const DB_SIG = "BuildYourOwnDB07"
+const MASTER_SIZE = 64
 
 // the master page format.
 // it contains the pointer to the root and other important bits.
-// | sig | btree_root | page_used |
-// | 16B | 8B | 8B |
+// | sig | btree_root | page_used | head_page | head_seq | tail_page | tail_seq |
+// | 16B | 8B | 8B | 8B | 8B | 8B | 8B |
+// the last 4 fields are the free list, files written before the free list
+// existed have zeros there, which is an empty list.
+//
+// the master page is stored in 2 slots of page 0, updated alternately so
+// that a torn write leaves the other one intact. the newest valid one is used.
+// | master page | seq | crc32c |
+// |     64B     | 8B  |   4B   |
+const MASTER_SLOT_SIZE = MASTER_SIZE + 12
+const MASTER_SLOT_OFFSET = BTREE_PAGE_SIZE / 2 // the second slot
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// the signatures share the prefix, the last 2 bytes are the format version.
+// the version 07 added the page checksums, the older files are converted
+// by Upgrade.
+const DB_SIG_PREFIX = "BuildYourOwnDB"
+
 func masterLoad(db *KV) error {
+	db.master.slot = 0
+	db.master.seq = 0
 	if db.mmap.file == 0 {
 		// empty file, the master page will be created on the first write.
 		db.page.flushed = 1 // reserved for the master page
 		return nil
 	}
 
-	data := db.mmap.chunks[0]
+	page := db.mmap.chunks[0][:BTREE_PAGE_SIZE]
+	var meta []byte
+	for slot := 0; slot < 2; slot++ {
+		data := page[slot*MASTER_SLOT_OFFSET:][:MASTER_SLOT_SIZE]
+		seq := binary.LittleEndian.Uint64(data[MASTER_SIZE:])
+		sum := binary.LittleEndian.Uint32(data[MASTER_SIZE+8:])
+		if crc32.Checksum(data[:MASTER_SIZE+8], crc32c) != sum {
+			continue // torn or never written
+		}
+		if !bytes.Equal([]byte(DB_SIG), data[:16]) || metaCheck(db, data) != nil {
+			continue
+		}
+		if meta == nil || seq > db.master.seq {
+			meta = data[:MASTER_SIZE]
+			db.master.slot = slot
+			db.master.seq = seq
+		}
+	}
+	if meta == nil && (bytes.HasPrefix(page, []byte(DB_SIG_V5)) ||
+		bytes.HasPrefix(page, []byte(DB_SIG_V6)) || bytes.HasPrefix(page[MASTER_SLOT_OFFSET:], []byte(DB_SIG_V6))) {
+		return ErrOldFormat
+	}
+	if meta == nil && bytes.HasPrefix(page, []byte(DB_SIG_PREFIX)) {
+		return fmt.Errorf("unsupported format version: %q", page[:16])
+	}
+	if meta == nil && isZeros(page) {
+		// a new file extended by the commits in the log before the first
+		// checkpoint, the log has the master page.
+		db.page.flushed = 1
+		return nil
+	}
+	if meta == nil {
+		return errors.New("bad master page")
+	}
+	loadMeta(db, meta)
+	return nil
+}
+
+func isZeros(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// verify the fields of a master page
+func metaCheck(db *KV, data []byte) error {
 	root := binary.LittleEndian.Uint64(data[16:])
 	used := binary.LittleEndian.Uint64(data[24:])
-	// verify the page
-	if !bytes.Equal([]byte(DB_SIG), data[:16]) {
-		return errors.New("bad signature")
-	}
+	headPage := binary.LittleEndian.Uint64(data[32:])
+	headSeq := binary.LittleEndian.Uint64(data[40:])
+	tailPage := binary.LittleEndian.Uint64(data[48:])
+	tailSeq := binary.LittleEndian.Uint64(data[56:])
 	bad := !(1 <= used && used <= uint64(db.mmap.file/BTREE_PAGE_SIZE))
 	bad = bad || !(root < used)
+	bad = bad || !(headPage < used && tailPage < used)
+	bad = bad || !(headSeq <= tailSeq)
 	if bad {
 		return errors.New("bad master page")
 	}
-	db.tree.root = root
-	db.page.flushed = used
 	return nil
 }
 
-func masterStore(db *KV) error {
-	var data [32]byte
+// serialize the in-memory states into the master page format
+func saveMeta(db *KV) []byte {
+	var data [MASTER_SIZE]byte
 	copy(data[:16], []byte(DB_SIG))
 	binary.LittleEndian.PutUint64(data[16:], db.tree.root)
 	binary.LittleEndian.PutUint64(data[24:], db.page.flushed)
+	binary.LittleEndian.PutUint64(data[32:], db.free.headPage)
+	binary.LittleEndian.PutUint64(data[40:], db.free.headSeq)
+	binary.LittleEndian.PutUint64(data[48:], db.free.tailPage)
+	binary.LittleEndian.PutUint64(data[56:], db.free.tailSeq)
+	return data[:]
+}
+
+func loadMeta(db *KV, data []byte) {
+	db.tree.root = binary.LittleEndian.Uint64(data[16:])
+	db.page.flushed = binary.LittleEndian.Uint64(data[24:])
+	db.free.headPage = binary.LittleEndian.Uint64(data[32:])
+	db.free.headSeq = binary.LittleEndian.Uint64(data[40:])
+	db.free.tailPage = binary.LittleEndian.Uint64(data[48:])
+	db.free.tailSeq = binary.LittleEndian.Uint64(data[56:])
+}
+
+// write & flush the master page into the slot not holding the current one.
+// the slots are switched only when it's durable, a failed write is retried
+// on the same slot.
+func masterStore(db *KV) error {
 	// NOTE: Updating the page via mmap is not atomic.
 	// Use the pwrite() syscall instead.
-	_, err := db.fp.WriteAt(data[:], 0)
+	seq := db.master.seq + 1
+	slot := 1 - db.master.slot
+	data := masterSlot(saveMeta(db), seq)
+	_, err := db.fp.WriteAt(data, int64(slot*MASTER_SLOT_OFFSET))
 	if err != nil {
 		return fmt.Errorf("write master page: %w", err)
 	}
+	if err := db.fp.Sync(); err != nil {
+		return fmt.Errorf("fsync: %w", err)
+	}
+	db.master.seq = seq
+	db.master.slot = slot
 	return nil
 }
 
+// the master page with the seq and the checksum, as stored in a slot
+func masterSlot(meta []byte, seq uint64) []byte {
+	data := append(meta, make([]byte, 12)...)
+	binary.LittleEndian.PutUint64(data[MASTER_SIZE:], seq)
+	binary.LittleEndian.PutUint32(data[MASTER_SIZE+8:], crc32.Checksum(data[:MASTER_SIZE+8], crc32c))
+	return data
+}
+
+// callback for BTree, allocate a new page.
 func (db *KV) pageNew(node BNode) uint64 {
-	// TODO: reuse deallocated pages
 	assertCondition(len(node.data) <= BTREE_PAGE_SIZE)
-	ptr := db.page.flushed + uint64(len(db.page.temp))
-	db.page.temp = append(db.page.temp, node.data)
+	if n := len(db.page.pool); n > 0 {
+		// reuse a page allocated and deallocated by this update
+		ptr := db.page.pool[n-1]
+		db.page.pool = db.page.pool[:n-1]
+		db.page.updates[ptr] = node.data
+		return ptr
+	}
+	if ptr := db.free.PopHead(); ptr != 0 {
+		// reuse a deallocated page
+		db.page.updates[ptr] = node.data
+		return ptr
+	}
+	// append a new page
+	return db.pageAppend(node)
+}
+
+// callback for BTree, deallocate a page.
+func (db *KV) pageDel(ptr uint64) {
+	if page := db.page.updates[ptr]; page != nil {
+		// allocated by this update, nobody else can see it
+		delete(db.page.updates, ptr)
+		db.page.pool = append(db.page.pool, ptr)
+		return
+	}
+	db.page.updates[ptr] = nil
+}
+
+// callback for FreeList, allocate a new page.
+func (db *KV) pageAppend(node BNode) uint64 {
+	assertCondition(len(node.data) <= BTREE_PAGE_SIZE)
+	ptr := db.page.flushed + uint64(db.page.nappend)
+	db.page.nappend++
+	db.page.updates[ptr] = node.data
 	return ptr
 }
 
-func (db *KV) pageDel(uint64) {
-	// TODO: implement this
+// callback for FreeList, reuse a page.
+func (db *KV) pageUse(ptr uint64, node BNode) {
+	db.page.updates[ptr] = node.data
+}
+
+// callback for FreeList, get a writable copy of an existing page.
+func (db *KV) pageWrite(ptr uint64) BNode {
+	if page, ok := db.page.updates[ptr]; ok {
+		assertCondition(page != nil)
+		return BNode{page}
+	}
+	node := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
+	copy(node.data, db.pageGetMapped(ptr).data)
+	db.page.updates[ptr] = node.data
+	return node
 }
 
 func extendFile(db *KV, npages int) error {
@@ -104,12 +289,18 @@ func extendFile(db *KV, npages int) error {
 	return nil
 }
 
+// all readers must be ended before closing the KV
 func (db *KV) Close() {
+	walClose(db)
 	for _, chunk := range db.mmap.chunks {
 		err := syscall.Munmap(chunk)
 		assertCondition(err == nil)
 	}
 	_ = db.fp.Close()
+	for _, old := range db.retired {
+		old.close()
+	}
+	db.retired = nil
 }
 
 func (db *KV) Open() error {
@@ -131,11 +322,24 @@ func (db *KV) Open() error {
 	db.tree.get = db.pageGet
 	db.tree.new = db.pageNew
 	db.tree.del = db.pageDel
+	// free list callbacks
+	db.free.get = db.pageGet
+	db.free.new = db.pageAppend
+	db.free.use = db.pageUse
+	db.free.set = db.pageWrite
+	discardPages(db)
 	// read the master page
 	err = masterLoad(db)
 	if err != nil {
 		goto fail
 	}
+	// replay the log after a crash
+	err = walOpen(db)
+	if err != nil {
+		goto fail
+	}
+	db.readers = map[*KVReader]struct{}{}
+	publish(db)
 	// done
 	return nil
 fail:
@@ -143,57 +347,203 @@ fail:
 	return fmt.Errorf("KV.Open: %w", err)
 }
 
-func (db *KV) Get(key []byte) ([]byte, bool) {
-	return db.tree.Get(key)
+// Get reads the last committed value, the returned value is a copy.
+func (db *KV) Get(key []byte) ([]byte, bool, error) {
+	reader := db.BeginRead()
+	defer db.EndRead(reader)
+	val, ok, err := reader.Get(key)
+	if !ok {
+		return nil, false, err
+	}
+	return append([]byte{}, val...), true, nil
+}
+
+// KVIter iterates over a range of keys in ascending order.
+type KVIter struct {
+	iter *BIter
+	end  []byte // exclusive, nil means no upper bound
+	err  error  // a corrupted page, the iterator is no longer valid
+	// the snapshot owned by the iterator, ended by Close
+	reader *KVReader
+}
+
+// Scan returns an iterator over the keys in [start, end) of the last
+// committed version. A nil end scans to the last key.
+// The iterator holds a snapshot and must be closed.
+func (db *KV) Scan(start []byte, end []byte) *KVIter {
+	reader := db.BeginRead()
+	iter := reader.Scan(start, end)
+	iter.reader = reader
+	return iter
+}
+
+func treeScan(tree *BTree, start []byte, end []byte) *KVIter {
+	it := &KVIter{end: end}
+	defer recoverCorrupt(&it.err)
+	it.iter = tree.Seek(start, CMP_GE)
+	return it
+}
+
+// ScanPrefix returns an iterator over the keys beginning with the prefix.
+// The iterator holds a snapshot and must be closed.
+func (db *KV) ScanPrefix(prefix []byte) *KVIter {
+	return db.Scan(prefix, keyenc.PrefixEnd(prefix))
+}
+
+func (it *KVIter) Valid() bool {
+	if it.err != nil || !it.iter.Valid() {
+		return false
+	}
+	return it.end == nil || bytes.Compare(it.iter.Key(), it.end) < 0
+}
+
+func (it *KVIter) Key() []byte {
+	return it.iter.Key()
+}
+
+// a corrupted overflow page ends the iteration with a nil value
+func (it *KVIter) Val() (val []byte) {
+	defer recoverCorrupt(&it.err)
+	return it.iter.Val()
+}
+
+func (it *KVIter) Next() {
+	defer recoverCorrupt(&it.err)
+	it.iter.Next()
+}
+
+// the error that ended the iteration early
+func (it *KVIter) Err() error {
+	return it.err
+}
+
+// release the snapshot held by an iterator from KV.Scan,
+// a no-op for iterators from transactions.
+func (it *KVIter) Close() {
+	if it.reader != nil {
+		it.reader.db.EndRead(it.reader)
+		it.reader = nil
+	}
 }
 
 func (db *KV) Set(key []byte, val []byte) error {
-	db.tree.Insert(key, val)
-	return flushPages(db)
+	tx := db.Begin()
+	if err := tx.Set(key, val); err != nil {
+		tx.Abort()
+		return err
+	}
+	return tx.Commit()
+}
+
+// a conditional update in its own transaction, see UpdateReq
+func (db *KV) Update(req *UpdateReq) (bool, error) {
+	tx := db.Begin()
+	updated, err := tx.Update(req)
+	if err != nil {
+		tx.Abort()
+		return false, err
+	}
+	return updated, tx.Commit()
 }
 
 func (db *KV) Del(key []byte) (bool, error) {
-	deleted := db.tree.Delete(key)
-	return deleted, flushPages(db)
+	tx := db.Begin()
+	deleted, err := tx.Del(key)
+	if err != nil {
+		tx.Abort()
+		return false, err
+	}
+	return deleted, tx.Commit()
+}
+
+// persist the pending pages, or revert the in-memory states on failure
+func updateOrRevert(db *KV, meta []byte) error {
+	// ensure the on-disk master page matches the in-memory one after an error
+	if db.failed {
+		var err error
+		if db.wal.fp != nil {
+			err = walTruncate(db, db.wal.size) // drop the partial record
+		} else {
+			err = syncMaster(db)
+		}
+		if err != nil {
+			return err
+		}
+		db.failed = false
+	}
+
+	err := flushPages(db)
+	if err != nil {
+		// the on-disk master page is in an unknown state,
+		// it will be rewritten before the next update.
+		db.failed = true
+		// the in-memory states are reverted immediately to allow reads
+		loadMeta(db, meta)
+		discardPages(db)
+	}
+	return err
+}
+
+// drop the pending pages
+func discardPages(db *KV) {
+	db.page.nappend = 0
+	db.page.updates = map[uint64][]byte{}
+	db.page.pool = nil
 }
 
 func flushPages(db *KV) error {
 	if err := writePages(db); err != nil {
 		return err
 	}
+	if db.wal.fp != nil {
+		return logPages(db)
+	}
 	return syncPages(db)
 }
 
 func writePages(db *KV) error {
+	// update the free list
+	freed := db.page.pool
+	for ptr, page := range db.page.updates {
+		if page == nil {
+			freed = append(freed, ptr)
+		}
+	}
+	db.page.pool = nil
+	for _, ptr := range freed {
+		db.free.PushTail(ptr)
+	}
+
 	// extend the file & mmap if needed
-	npages := int(db.page.flushed) + len(db.page.temp)
+	npages := int(db.page.flushed) + db.page.nappend
 	if err := extendFile(db, npages); err != nil {
 		return err
 	}
 	if err := extendMmap(db, npages); err != nil {
 		return err
 	}
-	// copy data to the file
-	for i, page := range db.page.temp {
-		ptr := db.page.flushed + uint64(i)
-		copy(db.pageGet(ptr).data, page)
+	// copy pages to the file
+	for ptr, page := range db.page.updates {
+		if page != nil {
+			setChecksum(page)
+			copy(mmapPage(db.mmap.chunks, ptr).data, page)
+		}
 	}
 	return nil
 }
 
 func syncPages(db *KV) error {
+	db.page.flushed += uint64(db.page.nappend)
+	discardPages(db)
+	return syncMaster(db)
+}
+
+// make the in-memory master page durable
+func syncMaster(db *KV) error {
 	// flush data to the disk. must be done before updating the master page.
 	if err := db.fp.Sync(); err != nil {
 		return fmt.Errorf("fsync: %w", err)
 	}
-	db.page.flushed += uint64(len(db.page.temp))
-	db.page.temp = db.page.temp[:0]
 	// update & flush the master page
-	if err := masterStore(db); err != nil {
-		return err
-	}
-	if err := db.fp.Sync(); err != nil {
-		return fmt.Errorf("fsync: %w", err)
-	}
-	return nil
+	return masterStore(db)
 }
@@ -0,0 +1,186 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// the lookups compare the linear scan before the binary search with
+// nodeLookupLE on leaves of different sizes. the tree operations are on an
+// in-memory tree. the fanout ones report the keys per leaf of keys of the
+// same length with and without a shared prefix.
+//
+//	go test -run XXX -bench . ./btree
+
+const BENCH_TREE_KEYS = 100000
+
+func BenchmarkLookup(b *testing.B) {
+	for _, n := range []uint16{16, 64, 128} {
+		b.Run(fmt.Sprintf("linear/%d", n), benchLookup(n, nodeLookupLinear))
+		b.Run(fmt.Sprintf("binary/%d", n), benchLookup(n, nodeLookupLE))
+	}
+}
+
+func BenchmarkLookupFanout(b *testing.B) {
+	b.Run("prefix", benchFanout(true))
+	b.Run("random", benchFanout(false))
+}
+
+func BenchmarkTreeGet(b *testing.B) {
+	tree := benchTree(BENCH_TREE_KEYS)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, ok, err := tree.Get(benchKey(rand.Intn(BENCH_TREE_KEYS)))
+		assertCondition(ok && err == nil)
+	}
+}
+
+func BenchmarkTreeInsert(b *testing.B) {
+	b.Run("seq", benchInsert(false))
+	b.Run("random", benchInsert(true))
+}
+
+func BenchmarkTreeDelete(b *testing.B) {
+	b.StopTimer()
+	tree := benchTree(b.N)
+	order := rand.Perm(b.N)
+	b.StartTimer()
+	for _, k := range order {
+		deleted, err := tree.Delete(benchKey(k))
+		assertCondition(deleted && err == nil)
+	}
+}
+
+// the lookup before the binary search, kept for the benchmarks
+func nodeLookupLinear(node BNode, key []byte) uint16 {
+	nkeys := node.nkeys()
+	found := uint16(0)
+	for i := uint16(1); i < nkeys; i++ {
+		cmp := bytes.Compare(node.getKey(i), key)
+		if cmp <= 0 {
+			found = i
+		}
+		if cmp >= 0 {
+			break
+		}
+	}
+	return found
+}
+
+// 12-byte keys, in order for the keys below 1e9
+func benchKey(i int) []byte {
+	return []byte(fmt.Sprintf("key%09d", i))
+}
+
+func benchLookup(n uint16, lookup func(BNode, []byte) uint16) func(b *testing.B) {
+	return func(b *testing.B) {
+		node := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
+		node.setPrefixHeader(BNODE_LEAF, n, nil)
+		nodeAppendKV(node, 0, 0, nil, nil)
+		for i := uint16(1); i < n; i++ {
+			nodeAppendKV(node, i, 0, benchKey(int(i)), nil)
+		}
+		assertCondition(node.nbytes() <= BTREE_PAGE_SIZE)
+		keys := make([][]byte, 1024)
+		for i := range keys {
+			keys[i] = benchKey(rand.Intn(int(n)))
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			lookup(node, keys[i%len(keys)])
+		}
+	}
+}
+
+// a tree with the pages in a map
+func memTree() *BTree {
+	pages := map[uint64]BNode{}
+	next := uint64(1)
+	return &BTree{
+		get: func(ptr uint64) BNode {
+			return pages[ptr]
+		},
+		new: func(node BNode) uint64 {
+			ptr := next
+			next++
+			pages[ptr] = node
+			return ptr
+		},
+		del: func(ptr uint64) {
+			delete(pages, ptr)
+		},
+	}
+}
+
+func benchTree(nkeys int) *BTree {
+	tree := memTree()
+	for i := 0; i < nkeys; i++ {
+		assertCondition(tree.Insert(benchKey(i), benchKey(i)) == nil)
+	}
+	return tree
+}
+
+func benchInsert(random bool) func(b *testing.B) {
+	return func(b *testing.B) {
+		tree := memTree()
+		val := make([]byte, 8)
+		for i := 0; i < b.N; i++ {
+			k := i
+			if random {
+				k = rand.Intn(1e9)
+			}
+			binary.LittleEndian.PutUint64(val, uint64(i))
+			assertCondition(tree.Insert(benchKey(k), val) == nil)
+		}
+	}
+}
+
+// 73-byte keys, the shared prefix is 64 bytes
+func fanoutKey(shared bool, i int) []byte {
+	if shared {
+		return []byte(fmt.Sprintf("tenant-%056d/%09d", 42, i))
+	}
+	return []byte(fmt.Sprintf("%016x%016x%016x%015x/%09d",
+		rand.Uint64(), rand.Uint64(), rand.Uint64(), rand.Uint64()>>4, i))
+}
+
+// Get on a tree built once, with the shape as metrics
+func benchFanout(shared bool) func(b *testing.B) {
+	var tree *BTree
+	var keys [][]byte
+	return func(b *testing.B) {
+		if tree == nil {
+			tree = memTree()
+			for i := 0; i < BENCH_TREE_KEYS; i++ {
+				keys = append(keys, fanoutKey(shared, i))
+				assertCondition(tree.Insert(keys[i], benchKey(i)) == nil)
+			}
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_, ok, err := tree.Get(keys[rand.Intn(len(keys))])
+			assertCondition(ok && err == nil)
+		}
+		// after the loop, ResetTimer clears the metrics
+		leaves, depth := treeShape(tree, tree.root, 1)
+		b.ReportMetric(float64(BENCH_TREE_KEYS)/float64(leaves), "keys/leaf")
+		b.ReportMetric(float64(depth), "depth")
+	}
+}
+
+// the number of leaves and the depth of a subtree
+func treeShape(tree *BTree, ptr uint64, depth int) (int, int) {
+	node := tree.get(ptr)
+	if node.btype() == BNODE_LEAF {
+		return 1, depth
+	}
+	leaves, maxDepth := 0, depth
+	for i := uint16(0); i < node.nkeys(); i++ {
+		n, d := treeShape(tree, node.getPtr(i), depth+1)
+		leaves, maxDepth = leaves+n, max(maxDepth, d)
+	}
+	return leaves, maxDepth
+}
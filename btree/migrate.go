@@ -0,0 +1,78 @@
+package btree
+
+// Migration of the nodes in the formats before version 2, see bnode.go.
+// The updates rewrite the nodes on their paths, Migrate rewrites the
+// others in key order, a batch of nodes per transaction.
+
+const MIGRATE_BATCH = 1000 // nodes rewritten per transaction
+
+// rewrite about max nodes of the older formats from the subtree of start.
+// returns the number of nodes rewritten and the key to continue from,
+// nil when the whole tree is done.
+// On ErrCorrupt the tree may be half updated and should be discarded.
+func (tree *BTree) Migrate(start []byte, max int) (n int, next []byte, err error) {
+	defer recoverCorrupt(&err)
+	if tree.root == 0 {
+		return 0, nil, nil
+	}
+	budget := max
+	if ptr, ok := treeMigrate(tree, tree.root, start, &budget, &next); ok {
+		tree.root = ptr
+	}
+	return max - budget, next, nil
+}
+
+// rewrite the old nodes of the subtree from the kid of start until the
+// budget runs out, next is the first key of the kids left. the parents of
+// the rewritten nodes are rewritten as well. returns false if the subtree
+// is not changed.
+func treeMigrate(tree *BTree, ptr uint64, start []byte, budget *int, next *[]byte) (uint64, bool) {
+	node := tree.node(ptr)
+	kids := map[uint16]uint64{}
+	if node.btype() == BNODE_NODE {
+		for i := nodeLookupLE(node, start); i < node.nkeys(); i++ {
+			if *budget <= 0 {
+				if *next == nil {
+					*next = append([]byte{}, node.getKey(i)...)
+				}
+				break
+			}
+			if kid, ok := treeMigrate(tree, node.getPtr(i), start, budget, next); ok {
+				kids[i] = kid
+			}
+		}
+	}
+	if len(kids) == 0 && (node.version() == BNODE_V2 || *budget <= 0) {
+		return 0, false
+	}
+	new := nodeSlice(node, 0, node.nkeys())
+	for i, kid := range kids {
+		new.setPtr(i, kid)
+	}
+	*budget--
+	tree.del(ptr)
+	return tree.newNode(new), true
+}
+
+// Migrate rewrites the nodes of the older formats, MIGRATE_BATCH per
+// transaction. returns the number of nodes rewritten.
+func (db *KV) Migrate() (int, error) {
+	total := 0
+	var start []byte
+	for {
+		tx := db.Begin()
+		n, next, err := db.tree.Migrate(start, MIGRATE_BATCH)
+		if err != nil {
+			tx.Abort()
+			return total, err
+		}
+		if err := tx.Commit(); err != nil {
+			return total, err
+		}
+		total += n
+		if next == nil {
+			return total, nil
+		}
+		start = next
+	}
+}
@@ -0,0 +1,167 @@
+package btree
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// the node in an older version, the version 1 needs a prefix
+func oldVersion(node BNode, version uint16) BNode {
+	keys, vals, ptrs := nodeContent(node)
+	var prefix []byte
+	if version == BNODE_V1 {
+		prefix = keys[0][:keysPrefixLen(node, 0, node.nkeys()-1)]
+		if len(prefix) == 0 {
+			version = BNODE_V0
+		}
+	}
+	for i := uint16(0); node.btype() == BNODE_LEAF && i < node.nkeys(); i++ {
+		assertCondition(node.valFlag(i) == 0)
+	}
+	return encodeNode(version, node.btype(), prefix, keys, vals, ptrs)
+}
+
+// rewrite the nodes of the tree in the versions 0 and 1 in turn,
+// returns the number of nodes
+func writeOldNodes(t *testing.T, path string) int {
+	t.Helper()
+	db := openKV(t, path, false)
+	pages := map[uint64]BNode{}
+	var walk func(ptr uint64)
+	walk = func(ptr uint64) {
+		node := db.tree.node(ptr)
+		pages[ptr] = oldVersion(node, uint16(len(pages)%2))
+		for i := uint16(0); node.btype() == BNODE_NODE && i < node.nkeys(); i++ {
+			walk(node.getPtr(i))
+		}
+	}
+	walk(db.tree.root)
+	db.Close()
+
+	fp, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	for ptr, node := range pages {
+		setChecksum(node.data)
+		if _, err := fp.WriteAt(node.data, int64(ptr)*BTREE_PAGE_SIZE); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return len(pages)
+}
+
+// a file with nodes in all the versions, the half-empty pages leave room
+// for the older formats
+func oldNodesFile(t *testing.T, nkeys int) (string, map[string]string) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := openKV(t, path, false)
+	ref := map[string]string{}
+	tx := db.Begin()
+	bl, err := tx.BulkLoad(0.3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < nkeys; i++ {
+		key, val := fmt.Sprintf("user/%06d", i), fmt.Sprintf("val%d", i)
+		if err := bl.Add([]byte(key), []byte(val)); err != nil {
+			t.Fatal(err)
+		}
+		ref[key] = val
+	}
+	if err := bl.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	return path, ref
+}
+
+func TestMigrate(t *testing.T) {
+	path, ref := oldNodesFile(t, 5000)
+	nodes := writeOldNodes(t, path)
+	if r := checkVerify(t, path); r.OldNodes != nodes || r.Keys != len(ref) {
+		t.Fatalf("%d old nodes, %d keys", r.OldNodes, r.Keys)
+	}
+
+	db := openKV(t, path, false)
+	checkKV(t, db, ref)
+	// the updates rewrite the nodes on their paths
+	for i := 0; i < 5000; i += 500 {
+		key := fmt.Sprintf("user/%06d", i)
+		if err := db.Set([]byte(key), []byte("new")); err != nil {
+			t.Fatal(err)
+		}
+		ref[key] = "new"
+		if _, err := db.Del([]byte(key + "x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Set([]byte("user/002500x"), []byte("added")); err != nil {
+		t.Fatal(err)
+	}
+	ref["user/002500x"] = "added"
+	checkKV(t, db, ref)
+	n, err := db.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 || n >= nodes {
+		t.Fatalf("migrated %d of %d nodes", n, nodes)
+	}
+	checkKV(t, db, ref)
+	db.Close()
+
+	if r := checkVerify(t, path); r.OldNodes != 0 || r.Keys != len(ref) {
+		t.Fatalf("%d old nodes, %d keys", r.OldNodes, r.Keys)
+	}
+	db = openKV(t, path, false)
+	defer db.Close()
+	if n, err := db.Migrate(); n != 0 || err != nil {
+		t.Fatalf("migrated again: %d %v", n, err)
+	}
+	checkKV(t, db, ref)
+}
+
+// the migration continues from the returned key
+func TestMigrateBatches(t *testing.T) {
+	path, ref := oldNodesFile(t, 5000)
+	nodes := writeOldNodes(t, path)
+	depth := checkVerify(t, path).Depth
+	db := openKV(t, path, false)
+	defer db.Close()
+	total, batches := 0, 0
+	var start []byte
+	for {
+		tx := db.Begin()
+		n, next, err := db.tree.Migrate(start, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		// and the parents of the last ones
+		if n > 7+depth {
+			t.Fatalf("%d nodes in a batch", n)
+		}
+		total, batches = total+n, batches+1
+		if next == nil {
+			break
+		}
+		start = next
+	}
+	// the parents are counted again in each batch
+	if total < nodes || batches < nodes/(7+depth) {
+		t.Fatalf("migrated %d of %d nodes in %d batches", total, nodes, batches)
+	}
+	checkKV(t, db, ref)
+	if r := checkVerify(t, path); r.OldNodes != 0 {
+		t.Fatalf("%d old nodes", r.OldNodes)
+	}
+}
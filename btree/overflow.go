@@ -0,0 +1,98 @@
+package btree
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Values larger than BTREE_MAX_VAL_SIZE are stored in a chain of overflow
+// pages. The leaf keeps a reference to the chain and marks the value with
+// VAL_OVERFLOW. The pages are never modified, an update writes a new chain
+// and frees the old one, so that readers of older versions are unaffected.
+
+// overflow page structure
+// | Type (2B) | Unused (2B) | Checksum (4B) | Next (8B) | Data |
+// each page is full except the last one.
+const OVERFLOW_HEADER = 16
+const OVERFLOW_CAP = BTREE_PAGE_SIZE - OVERFLOW_HEADER
+
+// the reference in the leaf
+// | Size (8B) | First Page (8B) |
+const OVERFLOW_REF_SIZE = 16
+
+// the largest value, about 16K overflow pages
+const BTREE_MAX_BLOB_SIZE = 64 << 20
+
+// write the value into new overflow pages, returns the reference
+func overflowWrite(tree *BTree, val []byte) []byte {
+	assertCondition(len(val) <= BTREE_MAX_BLOB_SIZE)
+	npages := (len(val) + OVERFLOW_CAP - 1) / OVERFLOW_CAP
+	// from the last page, so that each page can point to the next one
+	next := uint64(0)
+	for i := npages - 1; i >= 0; i-- {
+		node := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
+		binary.LittleEndian.PutUint16(node.data[0:2], BNODE_OVERFLOW)
+		binary.LittleEndian.PutUint64(node.data[8:16], next)
+		copy(node.data[OVERFLOW_HEADER:], val[i*OVERFLOW_CAP:])
+		next = tree.new(node)
+	}
+	ref := make([]byte, OVERFLOW_REF_SIZE)
+	binary.LittleEndian.PutUint64(ref[0:8], uint64(len(val)))
+	binary.LittleEndian.PutUint64(ref[8:16], next)
+	return ref
+}
+
+// the value to store in a leaf, large values are moved to overflow pages
+func leafStore(tree *BTree, val []byte) ([]byte, uint16) {
+	if len(val) > BTREE_MAX_VAL_SIZE {
+		return overflowWrite(tree, val), VAL_OVERFLOW
+	}
+	return val, 0
+}
+
+// reassemble the value from the overflow pages
+func overflowRead(tree *BTree, ref []byte) []byte {
+	size := binary.LittleEndian.Uint64(ref[0:8])
+	ptr := binary.LittleEndian.Uint64(ref[8:16])
+	val := make([]byte, 0, size)
+	for uint64(len(val)) < size {
+		node := overflowPage(tree, ptr)
+		n := size - uint64(len(val))
+		if n > OVERFLOW_CAP {
+			n = OVERFLOW_CAP
+		}
+		val = append(val, node.data[OVERFLOW_HEADER:][:n]...)
+		ptr = binary.LittleEndian.Uint64(node.data[8:16])
+	}
+	return val
+}
+
+// deallocate the overflow pages of a replaced or deleted value
+func overflowFree(tree *BTree, ref []byte) {
+	size := binary.LittleEndian.Uint64(ref[0:8])
+	ptr := binary.LittleEndian.Uint64(ref[8:16])
+	for npages := (size + OVERFLOW_CAP - 1) / OVERFLOW_CAP; npages > 0; npages-- {
+		next := binary.LittleEndian.Uint64(overflowPage(tree, ptr).data[8:16])
+		tree.del(ptr)
+		ptr = next
+	}
+}
+
+func overflowPage(tree *BTree, ptr uint64) BNode {
+	if ptr == 0 {
+		panic(&CorruptError{Page: ptr, Reason: "overflow pages shorter than the value"})
+	}
+	node := tree.get(ptr)
+	if node.btype() != BNODE_OVERFLOW {
+		panic(&CorruptError{Page: ptr, Reason: fmt.Sprintf("bad overflow page type %d", node.btype())})
+	}
+	return node
+}
+
+// the value at the position of a leaf, reassembled if needed
+func leafValue(tree *BTree, node BNode, idx uint16) []byte {
+	if node.valFlag(idx) == VAL_OVERFLOW {
+		return overflowRead(tree, node.getVal(idx))
+	}
+	return node.getVal(idx)
+}
@@ -0,0 +1,86 @@
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func randomBytes(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestOverflowThreshold(t *testing.T) {
+	cases := []struct {
+		size   int
+		npages int // the overflow pages
+	}{
+		{0, 0},
+		{BTREE_MAX_VAL_SIZE, 0},
+		{BTREE_MAX_VAL_SIZE + 1, 1},
+		{OVERFLOW_CAP, 1},
+		{OVERFLOW_CAP + 1, 2},
+		{3*OVERFLOW_CAP + 7, 4},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprint(c.size), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "db")
+			db := openKV(t, path, false)
+			// the largest key with the largest value inline
+			key := []byte(strings.Repeat("k", BTREE_MAX_KEY_SIZE))
+			val := randomBytes(int64(c.size), c.size)
+			if err := db.Set(key, val); err != nil {
+				t.Fatal(err)
+			}
+			got, ok, err := db.Get(key)
+			if err != nil || !ok || !bytes.Equal(got, val) {
+				t.Fatalf("get: %v %v", ok, err)
+			}
+			db.Close()
+			if r := checkVerify(t, path); r.Overflows != c.npages {
+				t.Fatalf("%d overflow pages, want %d", r.Overflows, c.npages)
+			}
+		})
+	}
+}
+
+func TestOverflowUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := openKV(t, path, false)
+	big := randomBytes(1, 3*OVERFLOW_CAP)
+	if err := db.Set([]byte("a"), big); err != nil {
+		t.Fatal(err)
+	}
+	// the reader keeps the old chain
+	reader := db.BeginRead()
+	if err := db.Set([]byte("a"), []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+	got, ok, err := reader.Get([]byte("a"))
+	if err != nil || !ok || !bytes.Equal(got, big) {
+		t.Fatalf("reader: %v %v", ok, err)
+	}
+	db.EndRead(reader)
+	// the freed pages are reused
+	if err := db.Set([]byte("b"), big); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Del([]byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set([]byte("c"), make([]byte, BTREE_MAX_BLOB_SIZE+1)); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("set: %v", err)
+	}
+	checkKV(t, db, map[string]string{"a": "small"})
+	db.Close()
+	r := checkVerify(t, path)
+	if r.Overflows != 0 || r.FreeItems == 0 {
+		t.Fatalf("overflows %d, free items %d", r.Overflows, r.FreeItems)
+	}
+}
@@ -32,32 +32,52 @@ func mmapInit(fp *os.File) (int, []byte, error) {
 	)
 
 	if err != nil {
-		return 0, nil, fmt.Errorf("mmap: %%w", err)
+		return 0, nil, fmt.Errorf("mmap: %w", err)
 	}
 
 	return int(fi.Size()), chunk, nil
 }
 
 func extendMmap(db *KV, npages int) error {
-	if db.mmap.total >= npages*BTREE_PAGE_SIZE {
-		return nil
-	}
-	chunk, err := syscall.Mmap(
-		int(db.fp.Fd()), int64(db.mmap.total), db.mmap.total,
-		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED,
-	)
-	if err != nil {
-		return fmt.Errorf("mmap: %w", err)
+	for db.mmap.total < npages*BTREE_PAGE_SIZE {
+		chunk, err := syscall.Mmap(
+			int(db.fp.Fd()), int64(db.mmap.total), db.mmap.total,
+			syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED,
+		)
+		if err != nil {
+			return fmt.Errorf("mmap: %w", err)
+		}
+		db.mmap.total += db.mmap.total
+		// readers copy the chunk list when they start
+		db.mu.Lock()
+		db.mmap.chunks = append(db.mmap.chunks, chunk)
+		db.mu.Unlock()
 	}
-	db.mmap.total += db.mmap.total
-	db.mmap.chunks = append(db.mmap.chunks, chunk)
 	return nil
 }
 
-// data is stored in chunks
+// callback for BTree & FreeList, dereference a pointer.
+// pending updates take precedence over the mapped pages.
 func (db *KV) pageGet(ptr uint64) BNode {
+	if page, ok := db.page.updates[ptr]; ok {
+		if page == nil {
+			// only a bad pointer leads to a page freed by this update
+			panic(&CorruptError{Page: ptr, Reason: "pointer to a freed page"})
+		}
+		return BNode{page}
+	}
+	return db.pageGetMapped(ptr)
+}
+
+// a page in the file, verified by the checksum
+func (db *KV) pageGetMapped(ptr uint64) BNode {
+	return verifyPage(ptr, mmapPage(db.mmap.chunks, ptr))
+}
+
+// data is stored in chunks
+func mmapPage(chunks [][]byte, ptr uint64) BNode {
 	start := uint64(0)
-	for _, chunk := range db.mmap.chunks {
+	for _, chunk := range chunks {
 		end := start + uint64(len(chunk))/BTREE_PAGE_SIZE
 		if ptr < end {
 			offset := BTREE_PAGE_SIZE * (ptr - start)
@@ -65,5 +85,5 @@ func (db *KV) pageGet(ptr uint64) BNode {
 		}
 		start = end
 	}
-	panic("bad ptr")
+	panic(&CorruptError{Page: ptr, Reason: "pointer out of the file"})
 }
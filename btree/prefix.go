@@ -0,0 +1,176 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Prefix compression, the version 1 and 2 node formats in bnode.go.
+// An update builds the new node with the prefix of the old one if the new
+// keys have it, otherwise the keys are expanded. The node is compressed
+// again with the prefix of its keys when it's allocated, and the splits
+// and merges use the sizes of the compressed pages.
+// Version 0 pages are still read, they are rewritten when updated.
+
+// the longer prefixes are cut, this bounds the size of an expanded node
+const BNODE_MAX_PREFIX = 128
+
+// the length of the common prefix, at most BNODE_MAX_PREFIX
+func commonPrefixLen(a []byte, b []byte) int {
+	n := 0
+	for n < len(a) && n < len(b) && n < BNODE_MAX_PREFIX && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// commonPrefixLen of 2 keys of a node, without copying them
+func keysPrefixLen(node BNode, i uint16, j uint16) int {
+	a, b := node.keySuffix(i), node.keySuffix(j)
+	n, k := len(node.prefix()), 0
+	for k < len(a) && k < len(b) && n < BNODE_MAX_PREFIX && a[k] == b[k] {
+		n, k = n+1, k+1
+	}
+	return n
+}
+
+// the prefix of old kept by a new node if the new keys have it
+func sharedPrefix(old BNode, keys ...[]byte) []byte {
+	prefix := old.prefix()
+	for _, key := range keys {
+		if !bytes.HasPrefix(key, prefix) {
+			return nil
+		}
+	}
+	return prefix
+}
+
+// the bytes per key of version 2 besides the key and the value:
+// the pointer, the offset and the lengths
+func keyOverhead(btype uint16) int {
+	if btype == BNODE_LEAF {
+		return 2 + 4
+	}
+	return 8 + 2 + 2
+}
+
+// the size of a page of version 2 with nkeys and payload bytes of the
+// whole keys and the values, when the keys have a common prefix of plen
+func pageSize(btype uint16, nkeys int, payload int, plen int) int {
+	return HEADER_SIZE + 2 + plen + nkeys*(keyOverhead(btype)-plen) + payload
+}
+
+// the bytes of the whole keys and the values of [from, to), in any version
+func rangePayload(node BNode, from uint16, to uint16) int {
+	n := int(to - from)
+	pairs := int(node.getOffset(to) - node.getOffset(from))
+	return pairs + n*(len(node.prefix())-int(node.pairHeader()))
+}
+
+// the size of the node in version 2 without a prefix, for the buffers of
+// the updates
+func nodeRawSize(node BNode) int {
+	nkeys := node.nkeys()
+	return pageSize(node.btype(), int(nkeys), rangePayload(node, 0, nkeys), 0)
+}
+
+// the size of the keys [from, to) in a page of their own
+func rangeSize(node BNode, from uint16, to uint16) int {
+	n := int(to - from)
+	if n == 0 {
+		return pageSize(node.btype(), 0, 0, 0)
+	}
+	payload := rangePayload(node, from, to)
+	return pageSize(node.btype(), n, payload, keysPrefixLen(node, from, to-1))
+}
+
+// the size of the page of 2 nodes merged
+func mergedSize(left BNode, right BNode) int {
+	nkeys := int(left.nkeys()) + int(right.nkeys())
+	payload := rangePayload(left, 0, left.nkeys()) + rangePayload(right, 0, right.nkeys())
+	switch {
+	case left.nkeys() == 0:
+		return rangeSize(right, 0, right.nkeys())
+	case right.nkeys() == 0:
+		return rangeSize(left, 0, left.nkeys())
+	}
+	// the keys are sorted, the common prefix of all is that of the ends
+	plen := commonPrefixLen(left.getKey(0), right.getKey(right.nkeys()-1))
+	return pageSize(left.btype(), nkeys, payload, plen)
+}
+
+// the page of a node built by an update, in version 2 with the prefix of
+// its keys. the node must fit in a page after compression.
+func nodeCompress(node BNode) BNode {
+	nkeys := node.nkeys()
+	plen := 0
+	if nkeys > 0 {
+		plen = keysPrefixLen(node, 0, nkeys-1)
+	}
+	if node.version() == BNODE_V2 && plen == len(node.prefix()) {
+		assertCondition(node.nbytes() <= BTREE_PAGE_SIZE)
+		if len(node.data) >= BTREE_PAGE_SIZE {
+			return BNode{data: node.data[:BTREE_PAGE_SIZE]}
+		}
+		new := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
+		copy(new.data, node.data)
+		return new
+	}
+	assertCondition(rangeSize(node, 0, nkeys) <= BTREE_PAGE_SIZE)
+	new := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
+	var prefix []byte
+	if plen > 0 {
+		prefix = node.getKey(0)[:plen]
+	}
+	new.setPrefixHeader(node.btype(), nkeys, prefix)
+	nodeAppendRange(new, node, 0, 0, nkeys)
+	return new
+}
+
+// nodeAppendRange between nodes of different versions or prefixes. the
+// prefix of new is either a part of the prefix of old, or longer and
+// shared by the keys.
+func nodeRecodeRange(new BNode, old BNode, newStart uint16, oldStart uint16, n uint16) {
+	oldPrefix, newPrefix := old.prefix(), new.prefix()
+	// the part of the old prefix added to the keys, or the bytes cut
+	keep, cut := []byte(nil), 0
+	if len(newPrefix) <= len(oldPrefix) {
+		keep = oldPrefix[len(newPrefix):]
+	} else {
+		cut = len(newPrefix) - len(oldPrefix)
+	}
+	// the leaves of version 2 have no pointers, those of the older
+	// versions are zeros
+	if new.ptrSize() > 0 && old.ptrSize() > 0 {
+		copy(new.data[new.base()+8*newStart:], old.data[old.base()+8*oldStart:][:8*n])
+	}
+	oldHeader, newHeader := int(old.pairHeader()), int(new.pairHeader())
+	pos, npos := int(old.kvPos(oldStart)), int(new.kvPos(newStart))
+	offset := int(new.getOffset(newStart))
+	for i := uint16(0); i < n; i++ {
+		klen := int(binary.LittleEndian.Uint16(old.data[pos:]))
+		vfield := uint16(0) // vlen and the flag
+		if oldHeader == 4 {
+			vfield = binary.LittleEndian.Uint16(old.data[pos+2:])
+		}
+		vlen := int(vfield &^ VAL_OVERFLOW)
+		size := newHeader + len(keep) + klen - cut + vlen
+		binary.LittleEndian.PutUint16(new.data[npos:], uint16(len(keep)+klen-cut))
+		if newHeader == 4 {
+			binary.LittleEndian.PutUint16(new.data[npos+2:], vfield)
+		} else {
+			assertCondition(vlen == 0)
+		}
+		copy(new.data[npos+newHeader:], keep)
+		copy(new.data[npos+newHeader+len(keep):], old.data[pos+oldHeader+cut:pos+oldHeader+klen+vlen])
+		offset += size
+		new.setOffset(newStart+i+1, uint16(offset))
+		pos += oldHeader + klen + vlen
+		npos += size
+	}
+}
+
+// allocate a page for a node built by an update
+func (tree *BTree) newNode(node BNode) uint64 {
+	return tree.new(nodeCompress(node))
+}
@@ -0,0 +1,190 @@
+package btree
+
+import (
+	"bytes"
+	"errors"
+)
+
+// KVReader is a read-only snapshot of the last committed version.
+// The tree is copy-on-write, so a snapshot only needs the root pointer and
+// the mapped pages. The pages reachable from the root are not reused until
+// the reader is ended. Many readers can run alongside a single writer.
+type KVReader struct {
+	db      *KV
+	version uint64
+	freeSeq uint64 // pages freed after this snapshot are at or after this seq
+	tree    BTree
+	mmap    struct {
+		chunks [][]byte // copied from the KV
+	}
+}
+
+// KVTX is a read-write transaction.
+// The updates are kept in the pending pages and become visible to readers
+// only when the master page is written on Commit.
+// Only one transaction may be active on a KV at a time, Begin blocks until
+// the previous one is ended.
+type KVTX struct {
+	db *KV
+	// the master page at the beginning, for the rollback
+	meta []byte
+	// an update failed halfway, the transaction can only be aborted
+	err error
+}
+
+// start a snapshot read
+func (db *KV) BeginRead() *KVReader {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	reader := &KVReader{
+		db:      db,
+		version: db.latest.version,
+		freeSeq: db.latest.freeSeq,
+	}
+	reader.mmap.chunks = db.mmap.chunks
+	reader.tree.root = db.latest.root
+	reader.tree.get = reader.pageGet
+	db.readers[reader] = struct{}{}
+	return reader
+}
+
+// end a snapshot read, the values read from it are no longer valid
+func (db *KV) EndRead(reader *KVReader) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.readers, reader)
+	endRetired(db, reader)
+}
+
+// callback for BTree, readers never see the pending pages of the writer.
+func (reader *KVReader) pageGet(ptr uint64) BNode {
+	return verifyPage(ptr, mmapPage(reader.mmap.chunks, ptr))
+}
+
+// the version number of the snapshot, incremented by each commit
+func (reader *KVReader) Version() uint64 {
+	return reader.version
+}
+
+// the returned value is valid until the reader is ended
+func (reader *KVReader) Get(key []byte) ([]byte, bool, error) {
+	return reader.tree.Get(key)
+}
+
+// the iterator is valid until the reader is ended
+func (reader *KVReader) Scan(start []byte, end []byte) *KVIter {
+	return treeScan(&reader.tree, start, end)
+}
+
+// make the last committed state visible to new readers
+func publish(db *KV) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.latest.version++
+	db.latest.root = db.tree.root
+	db.latest.freeSeq = db.free.tailSeq
+}
+
+// begin a transaction
+func (db *KV) Begin() *KVTX {
+	db.writer.Lock()
+	tx := &KVTX{db: db, meta: saveMeta(db)}
+	// the pages freed after the oldest snapshot may still be read
+	db.mu.Lock()
+	maxSeq := db.free.tailSeq
+	for reader := range db.readers {
+		if reader.freeSeq < maxSeq {
+			maxSeq = reader.freeSeq
+		}
+	}
+	db.mu.Unlock()
+	db.free.SetMaxSeq(maxSeq)
+	return tx
+}
+
+// end a transaction: commit updates
+func (tx *KVTX) Commit() error {
+	if tx.err != nil {
+		tx.Abort()
+		return tx.err
+	}
+	if bytes.Equal(saveMeta(tx.db), tx.meta) {
+		tx.Abort() // nothing changed, skip the fsync
+		return nil
+	}
+	defer tx.db.writer.Unlock()
+	if err := updateOrRevert(tx.db, tx.meta); err != nil {
+		return err
+	}
+	publish(tx.db)
+	return nil
+}
+
+// end a transaction: rollback
+func (tx *KVTX) Abort() {
+	defer tx.db.writer.Unlock()
+	// nothing written, just drop the pending pages
+	loadMeta(tx.db, tx.meta)
+	discardPages(tx.db)
+}
+
+// reads see the updates made earlier in this transaction
+func (tx *KVTX) Get(key []byte) ([]byte, bool, error) {
+	return tx.db.tree.Get(key)
+}
+
+// the iterator is valid until the next update in this transaction
+func (tx *KVTX) Scan(start []byte, end []byte) *KVIter {
+	return treeScan(&tx.db.tree, start, end)
+}
+
+// an invalid key or value is rejected without changing anything,
+// ErrCorrupt fails the transaction and Commit will abort it.
+func (tx *KVTX) Set(key []byte, val []byte) error {
+	if tx.err != nil {
+		return tx.err
+	}
+	_, err := tx.Update(&UpdateReq{Key: key, Val: val})
+	return err
+}
+
+// a conditional update, returns whether the tree was changed.
+// the previous value is in req.Old.
+func (tx *KVTX) Update(req *UpdateReq) (bool, error) {
+	if tx.err != nil {
+		return false, tx.err
+	}
+	err := tx.check(tx.db.tree.Update(req))
+	return req.Updated, err
+}
+
+func (tx *KVTX) Del(key []byte) (bool, error) {
+	if tx.err != nil {
+		return false, tx.err
+	}
+	deleted, err := tx.db.tree.Delete(key)
+	return deleted, tx.check(err)
+}
+
+// a bulk load in the transaction, see BTree.BulkLoad.
+// ErrCorrupt from the loader fails the transaction.
+func (tx *KVTX) BulkLoad(fill float64) (*BulkLoader, error) {
+	if tx.err != nil {
+		return nil, tx.err
+	}
+	bl, err := tx.db.tree.BulkLoad(fill)
+	if err != nil {
+		return nil, tx.check(err)
+	}
+	bl.check = tx.check
+	return bl, nil
+}
+
+// a corrupted page in the middle of an update leaves the tree and the
+// pending pages half updated, so the transaction is failed.
+func (tx *KVTX) check(err error) error {
+	if errors.Is(err, ErrCorrupt) {
+		tx.err = err
+	}
+	return err
+}
@@ -0,0 +1,106 @@
+package btree
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// overwrite n keys of the 1000 in one transaction, the values keep their size
+func rewriteKeys(t *testing.T, db *KV, ref map[string]string, round int, n int) {
+	t.Helper()
+	tx := db.Begin()
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%04d", (round*n+i*7)%1000)
+		val := fmt.Sprintf("%s-%06d-%0100d", key, round, i)
+		if err := tx.Set([]byte(key), []byte(val)); err != nil {
+			t.Fatal(err)
+		}
+		ref[key] = val
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkReader(t *testing.T, reader *KVReader, ref map[string]string) {
+	t.Helper()
+	n := 0
+	iter := reader.Scan(nil, nil)
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		if val, ok := ref[string(iter.Key())]; !ok || val != string(iter.Val()) {
+			t.Fatalf("reader: key %q: got %q, want %q", iter.Key(), iter.Val(), val)
+		}
+		n++
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(ref) {
+		t.Fatalf("reader: %d keys, want %d", n, len(ref))
+	}
+}
+
+// the pages freed while a reader is open are reused only after it's ended
+func TestReaderFreeList(t *testing.T) {
+	for _, wal := range []bool{false, true} {
+		t.Run(fmt.Sprintf("wal=%v", wal), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "db")
+			db := openKV(t, path, wal)
+			ref := map[string]string{}
+			rewriteKeys(t, db, ref, 0, 1000)
+			for i := 1; i < 50; i++ {
+				rewriteKeys(t, db, ref, i, 50) // fill the free list
+			}
+
+			reader := db.BeginRead()
+			snapshot := map[string]string{}
+			for k, v := range ref {
+				snapshot[k] = v
+			}
+			version := reader.Version()
+			before := fileSize(t, path)
+			for i := 50; i < 250; i++ {
+				rewriteKeys(t, db, ref, i, 50)
+				// nothing freed after the snapshot is consumed
+				if db.free.headSeq > reader.freeSeq {
+					t.Fatalf("round %d: head %d after the reader %d", i, db.free.headSeq, reader.freeSeq)
+				}
+				if i%50 == 0 {
+					checkReader(t, reader, snapshot)
+				}
+			}
+			checkReader(t, reader, snapshot)
+			if reader.Version() != version {
+				t.Fatalf("version %d -> %d", version, reader.Version())
+			}
+			if val, ok, err := reader.Get([]byte("key0000")); err != nil || !ok || string(val) != snapshot["key0000"] {
+				t.Fatalf("reader get: %q %v %v", val, ok, err)
+			}
+			held := fileSize(t, path)
+			if held <= before {
+				t.Fatalf("the file doesn't grow with a reader: %d -> %d", before, held)
+			}
+			db.EndRead(reader)
+
+			// the pages are reused, the file stops growing
+			for i := 250; i < 300; i++ {
+				rewriteKeys(t, db, ref, i, 50)
+			}
+			released := fileSize(t, path)
+			for i := 300; i < 600; i++ {
+				rewriteKeys(t, db, ref, i, 50)
+			}
+			if after := fileSize(t, path); after != released {
+				t.Fatalf("the file grows after the reader ended: %d -> %d", released, after)
+			}
+			if db.free.Total() == 0 {
+				t.Fatal("empty free list")
+			}
+			checkKV(t, db, ref)
+			db.Close()
+			checkVerify(t, path)
+		})
+	}
+}
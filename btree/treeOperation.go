@@ -6,24 +6,53 @@ import (
 	"fmt"
 )
 
+// the position of the last key <= key, the first key counts as <= key
+// since it's the dummy key or the lower bound of the subtree.
 func nodeLookupLE(node BNode, key []byte) uint16 {
-	nkeys := node.nkeys()
-	found := uint16(0)
+	idx, _ := nodeLookup(node, key)
+	return idx
+}
 
-	for i := uint16(1); i < nkeys; i++ {
-		// if node ith key is less than key, then it returns value less than 0 which means found can be updated
-		cmp := bytes.Compare(node.getKey(i), key)
+// like nodeLookupLE, also returns whether the key at the position is equal.
+// a binary search on the offsets, keys appended in order are checked
+// against the last key first. the keys are compared without the prefix.
+func nodeLookup(node BNode, key []byte) (uint16, bool) {
+	nkeys := node.nkeys()
+	if nkeys == 0 {
+		return 0, false
+	}
+	if prefix := node.prefix(); len(prefix) > 0 {
+		if !bytes.HasPrefix(key, prefix) {
+			// below or above all the keys
+			if bytes.Compare(key, prefix) < 0 {
+				return 0, false
+			}
+			return nkeys - 1, false
+		}
+		key = key[len(prefix):]
+	}
+	if nkeys > 1 {
+		cmp := bytes.Compare(node.keySuffix(nkeys-1), key)
 		if cmp <= 0 {
-			found = i
+			return nkeys - 1, cmp == 0
 		}
-
-		// if node ith key is greater than key, then it returns value greater than 0 which means found can be updated
-		if cmp >= 0 {
-			break
+	}
+	// the key at lo is <= key, the key at hi is > key
+	lo, hi := uint16(0), nkeys-1
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		cmp := bytes.Compare(node.keySuffix(mid), key)
+		if cmp == 0 {
+			return mid, true
+		}
+		if cmp < 0 {
+			lo = mid
+		} else {
+			hi = mid
 		}
 	}
-
-	return found
+	// only the first key wasn't compared
+	return lo, lo == 0 && bytes.Equal(node.keySuffix(0), key)
 }
 
 // Insert a new key value pair in the node
@@ -33,25 +62,31 @@ func nodeLookupLE(node BNode, key []byte) uint16 {
 // then we insert the new key value
 // then we copy from idx+1 to new idx
 func leafInsert(new BNode, old BNode, idx uint16, key []byte, val []byte) {
-	new.setHeader(BNODE_LEAF, old.nkeys()+1)
+	new.setPrefixHeader(BNODE_LEAF, old.nkeys()+1, sharedPrefix(old, key))
 	nodeAppendRange(new, old, 0, 0, idx)
 	nodeAppendKV(new, idx, 0, key, val)
 	nodeAppendRange(new, old, idx+1, idx, old.nkeys()-idx)
 }
 
 func leafUpdate(new BNode, old BNode, idx uint16, key []byte, val []byte) {
+	new.setPrefixHeader(BNODE_LEAF, old.nkeys(), old.prefix())
 	nodeAppendRange(new, old, 0, 0, idx)
 	nodeAppendKV(new, idx, 0, key, val)
-	nodeAppendRange(new, old, idx+1, idx+1, old.nkeys()-idx)
+	nodeAppendRange(new, old, idx+1, idx+1, old.nkeys()-(idx+1))
 }
 
 func nodeAppendRange(new BNode, old BNode, newNodeStartIdx uint16, oldNodeStartIdx uint16, len uint16) {
 	if len == 0 {
 		return
 	}
-	// Pointers
-	for i := uint16(0); i < len; i++ {
-		new.setPtr(newNodeStartIdx+i, old.getPtr(oldNodeStartIdx+i))
+	if new.version() != old.version() || !bytes.Equal(new.prefix(), old.prefix()) {
+		nodeRecodeRange(new, old, newNodeStartIdx, oldNodeStartIdx, len)
+		return
+	}
+	// Pointers, not in the leaves
+	if size := new.ptrSize(); size > 0 {
+		from := old.base() + size*oldNodeStartIdx
+		copy(new.data[new.base()+size*newNodeStartIdx:], old.data[from:from+size*len])
 	}
 
 	// offsets
@@ -60,9 +95,10 @@ func nodeAppendRange(new BNode, old BNode, newNodeStartIdx uint16, oldNodeStartI
 
 	// It's from 1 to len because whenever we insert a key at index i, then we update the offset of i+1th index, so here we are updating
 	// keys from 0 to n-1, so we are updating the offsets from 1 to n
-	for i := uint16(1); i <= len; i++ {
-		offset := newNodeBeginOffset + (old.getOffset(oldNodeStartIdx+i) - oldNodeBeginOffset)
-		new.setOffset(newNodeStartIdx+i, offset)
+	newPos, oldPos := offsetPos(new, newNodeStartIdx+1), offsetPos(old, oldNodeStartIdx+1)
+	for i := uint16(0); i < len; i++ {
+		offset := binary.LittleEndian.Uint16(old.data[oldPos+2*i:]) - oldNodeBeginOffset
+		binary.LittleEndian.PutUint16(new.data[newPos+2*i:], newNodeBeginOffset+offset)
 	}
 
 	// key values
@@ -73,43 +109,81 @@ func nodeAppendRange(new BNode, old BNode, newNodeStartIdx uint16, oldNodeStartI
 }
 
 func nodeAppendKV(new BNode, idx uint16, ptr uint64, key []byte, val []byte) {
-	new.setPtr(idx, ptr)
+	if new.ptrSize() > 0 {
+		new.setPtr(idx, ptr)
+	}
+	// the key is stored without the prefix of the node
+	prefix := new.prefix()
+	assertCondition(bytes.HasPrefix(key, prefix))
+	key = key[len(prefix):]
 
 	kvPos := new.kvPos(idx)
+	header := new.pairHeader()
 
 	keyLen := len(key)
 	valLen := len(val)
 
 	// set the offset for the next key
 	offset := new.getOffset(idx)
-	new.setOffset(idx+1, offset+4+uint16(keyLen)+uint16(valLen))
+	new.setOffset(idx+1, offset+header+uint16(keyLen)+uint16(valLen))
 
-	// set the sizes of key values
+	// set the sizes of key values, no value in the internal nodes
 	binary.LittleEndian.PutUint16(new.data[kvPos:], uint16(keyLen))
-	binary.LittleEndian.PutUint16(new.data[kvPos+2:], uint16(valLen))
+	if header == 4 {
+		binary.LittleEndian.PutUint16(new.data[kvPos+2:], uint16(valLen))
+	} else {
+		assertCondition(valLen == 0)
+	}
 
 	// set the data
-	copy(new.data[(kvPos+4):], key)
-	copy(new.data[(kvPos+4+uint16(keyLen)):], val)
+	copy(new.data[(kvPos+header):], key)
+	copy(new.data[(kvPos+header+uint16(keyLen)):], val)
 }
 
-func treeInsert(tree *BTree, node BNode, key []byte, val []byte) BNode {
+// returns an empty node if the request doesn't change the subtree.
+// req.Found and req.Old are set when the leaf is reached.
+func treeInsert(tree *BTree, node BNode, req *UpdateReq) BNode {
 	// TODO: understand it more
-	new := BNode{data: make([]byte, 2*BTREE_PAGE_SIZE)}
+	// a new key or 2 more kids, the keys are expanded if they don't
+	// have the prefix of the node
+	size := nodeRawSize(node)
+	if node.btype() == BNODE_LEAF && bytes.HasPrefix(req.Key, node.prefix()) {
+		size = int(node.nbytes())
+	}
+	new := BNode{data: make([]byte, size+BTREE_PAGE_SIZE)}
 
 	// lookup the idx
-	idx := nodeLookupLE(node, key)
+	idx, found := nodeLookup(node, req.Key)
 
 	switch node.btype() {
 	case BNODE_LEAF:
-		if bytes.Equal(key, new.getKey(idx)) {
-			leafUpdate(new, node, idx, key, val)
+		if found {
+			req.Found = true
+			req.Old = leafValue(tree, node, idx)
+			if node.valFlag(idx) != VAL_OVERFLOW {
+				// the page may be reused before the caller is done
+				req.Old = append([]byte{}, req.Old...)
+			}
+		}
+		if !req.changes() {
+			return BNode{}
+		}
+		val, vflag := leafStore(tree, req.Val)
+		if found {
+			if node.valFlag(idx) == VAL_OVERFLOW {
+				overflowFree(tree, node.getVal(idx)) // the old value
+			}
+			leafUpdate(new, node, idx, req.Key, val)
+			new.setValFlag(idx, vflag)
 		} else {
-			leafInsert(new, node, idx+1, key, val)
+			leafInsert(new, node, idx+1, req.Key, val)
+			new.setValFlag(idx+1, vflag)
 		}
 
 	case BNODE_NODE:
-		nodeInsert(tree, new, node, idx, key, val)
+		if !nodeInsert(tree, new, node, idx, req) {
+			return BNode{}
+		}
 
 	default:
 		panic("bad node")
@@ -119,54 +193,66 @@ func treeInsert(tree *BTree, node BNode, key []byte, val []byte) BNode {
 
 }
 
-func nodeInsert(tree *BTree, new BNode, node BNode, idx uint16, key []byte, val []byte) {
+// returns false if the kid is unchanged
+func nodeInsert(tree *BTree, new BNode, node BNode, idx uint16, req *UpdateReq) bool {
 	kptr := node.getPtr(idx)
-	knode := tree.get(kptr)
+	knode := treeInsert(tree, tree.node(kptr), req)
+	if len(knode.data) == 0 {
+		return false
+	}
 	tree.del(kptr)
 
-	knode = treeInsert(tree, knode, key, val)
-
 	nsplit, splited := nodeSplit3(knode)
 	// update the kid links
 	nodeReplaceKidN(tree, new, node, idx, splited[:nsplit]...)
+	return true
 }
 
-func nodeSplit2(left BNode, right BNode, old BNode) {
-	// Get the number of keys in the old node
-	nkeys := old.nkeys()
-	splitIdx := nkeys / 2 // Split approximately in half
-
-	// Copy first half of keys to left node
-	left.setHeader(old.btype(), splitIdx)
-	nodeAppendRange(left, old, 0, 0, splitIdx)
-
-	// Copy second half of keys to right node
-	right.setHeader(old.btype(), nkeys-splitIdx)
-	nodeAppendRange(right, old, 0, splitIdx, nkeys-splitIdx)
+// the keys [from, to) in a new node
+func nodeSlice(old BNode, from uint16, to uint16) BNode {
+	size := pageSize(old.btype(), int(to-from), rangePayload(old, from, to), 0)
+	new := BNode{data: make([]byte, size+len(old.prefix()))}
+	new.setPrefixHeader(old.btype(), to-from, old.prefix())
+	nodeAppendRange(new, old, 0, from, to-from)
+	return new
 }
 
+// split a node built by an update into nodes that fit in a page when
+// compressed. the keys in a page may have a longer common prefix than
+// the whole node, so the split points are chosen by the size of each page.
 func nodeSplit3(old BNode) (uint16, [3]BNode) {
-	if old.nbytes() <= BTREE_PAGE_SIZE {
-		old.data = old.data[:BTREE_PAGE_SIZE]
+	nkeys := old.nkeys()
+	if rangeSize(old, 0, nkeys) <= BTREE_PAGE_SIZE {
 		return 1, [3]BNode{old}
 	}
 
-	left := BNode{make([]byte, 2*BTREE_PAGE_SIZE)}
-	right := BNode{make([]byte, BTREE_PAGE_SIZE)}
-
-	nodeSplit2(left, right, old)
-
-	if left.nbytes() <= BTREE_PAGE_SIZE {
-		left.data = left.data[:BTREE_PAGE_SIZE]
-		return 2, [3]BNode{left, right}
+	// in 2 halves of about the same size
+	best, bestDiff := uint16(0), -1
+	for i := uint16(1); i < nkeys; i++ {
+		left, right := rangeSize(old, 0, i), rangeSize(old, i, nkeys)
+		if left > BTREE_PAGE_SIZE || right > BTREE_PAGE_SIZE {
+			continue
+		}
+		if diff := max(left-right, right-left); bestDiff < 0 || diff < bestDiff {
+			best, bestDiff = i, diff
+		}
+	}
+	if best > 0 {
+		return 2, [3]BNode{nodeSlice(old, 0, best), nodeSlice(old, best, nkeys)}
 	}
 
-	leftleft := BNode{make([]byte, BTREE_PAGE_SIZE)}
-	middle := BNode{make([]byte, BTREE_PAGE_SIZE)}
-
-	nodeSplit2(leftleft, middle, left)
-
-	return 3, [3]BNode{leftleft, middle, right}
+	// the sizes only grow with more keys, the largest first pages leave
+	// the least for the last one. the update added at most a key or 2 kids.
+	first := uint16(1)
+	for first+1 < nkeys && rangeSize(old, 0, first+1) <= BTREE_PAGE_SIZE {
+		first++
+	}
+	second := first + 1
+	for second+1 < nkeys && rangeSize(old, first, second+1) <= BTREE_PAGE_SIZE {
+		second++
+	}
+	assertCondition(rangeSize(old, second, nkeys) <= BTREE_PAGE_SIZE)
+	return 3, [3]BNode{nodeSlice(old, 0, first), nodeSlice(old, first, second), nodeSlice(old, second, nkeys)}
 }
 
 func nodeReplaceKidN(
@@ -174,30 +260,39 @@ func nodeReplaceKidN(
 	kids ...BNode,
 ) {
 	inc := uint16(len(kids))
-	new.setHeader(BNODE_NODE, old.nkeys()+inc-1)
+	prefix := old.prefix()
+	for _, node := range kids {
+		if !bytes.HasPrefix(node.getKey(0), prefix) {
+			prefix = nil
+		}
+	}
+	new.setPrefixHeader(BNODE_NODE, old.nkeys()+inc-1, prefix)
 	nodeAppendRange(new, old, 0, 0, idx)
 	for i, node := range kids {
-		nodeAppendKV(new, idx+uint16(i), tree.new(node), node.getKey(0), nil)
+		nodeAppendKV(new, idx+uint16(i), tree.newNode(node), node.getKey(0), nil)
 	}
 	nodeAppendRange(new, old, idx+inc, idx+1, old.nkeys()-(idx+1))
 }
 
 // remove a key from a leaf node
 func leafDelete(new BNode, old BNode, idx uint16) {
-	new.setHeader(BNODE_LEAF, old.nkeys()-1)
+	new.setPrefixHeader(BNODE_LEAF, old.nkeys()-1, old.prefix())
 	nodeAppendRange(new, old, 0, 0, idx)
 	nodeAppendRange(new, old, idx, idx+1, old.nkeys()-(idx+1))
 }
 
 func treeDelete(tree *BTree, node BNode, key []byte) BNode {
 	// where to find the key?
-	idx := nodeLookupLE(node, key)
+	idx, found := nodeLookup(node, key)
 	// act depending on the node type
 	switch node.btype() {
 	case BNODE_LEAF:
-		if !bytes.Equal(key, node.getKey(idx)) {
+		if !found {
 			return BNode{} // not found
 		}
+		if node.valFlag(idx) == VAL_OVERFLOW {
+			overflowFree(tree, node.getVal(idx))
+		}
 		// delete the key in the leaf
 		new := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
 		leafDelete(new, node, idx)
@@ -211,34 +306,38 @@ func treeDelete(tree *BTree, node BNode, key []byte) BNode {
 func nodeDelete(tree *BTree, node BNode, idx uint16, key []byte) BNode {
 	// recurse into the kid
 	kptr := node.getPtr(idx)
-	updated := treeDelete(tree, tree.get(kptr), key)
+	updated := treeDelete(tree, tree.node(kptr), key)
 	if len(updated.data) == 0 {
 		return BNode{} // not found
 	}
 	tree.del(kptr)
-	new := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
+	// the first key of the kid may be longer or without the prefix
+	new := BNode{data: make([]byte, nodeRawSize(node)+BTREE_PAGE_SIZE)}
 	// check for merging
 	mergeDir, sibling := shouldMerge(tree, node, idx, updated)
 	switch {
 	case mergeDir < 0: // left
-		merged := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
+		merged := BNode{data: make([]byte, nodeRawSize(sibling)+nodeRawSize(updated))}
 		nodeMerge(merged, sibling, updated)
 		tree.del(node.getPtr(idx - 1))
-		nodeReplace2Kid(new, node, idx-1, tree.new(merged), merged.getKey(0))
+		nodeReplace2Kid(new, node, idx-1, tree.newNode(merged), merged.getKey(0))
 	case mergeDir > 0: // right
-		merged := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
+		merged := BNode{data: make([]byte, nodeRawSize(sibling)+nodeRawSize(updated))}
 		nodeMerge(merged, updated, sibling)
 		tree.del(node.getPtr(idx + 1))
-		nodeReplace2Kid(new, node, idx, tree.new(merged), merged.getKey(0))
+		nodeReplace2Kid(new, node, idx, tree.newNode(merged), merged.getKey(0))
 	case mergeDir == 0:
-		nodeReplaceKidN(tree, new, node, idx, updated)
+		// the new first key of the kid may be longer, so it may not fit
+		nsplit, splited := nodeSplit3(updated)
+		nodeReplaceKidN(tree, new, node, idx, splited[:nsplit]...)
 	}
 	return new
 }
 
 // merge 2 nodes into 1
 func nodeMerge(new BNode, left BNode, right BNode) {
-	new.setHeader(left.btype(), left.nkeys()+right.nkeys())
+	prefix := left.prefix()[:commonPrefixLen(left.prefix(), right.prefix())]
+	new.setPrefixHeader(left.btype(), left.nkeys()+right.nkeys(), prefix)
 	nodeAppendRange(new, left, 0, 0, left.nkeys())
 	nodeAppendRange(new, right, left.nkeys(), 0, right.nkeys())
 }
@@ -248,75 +347,166 @@ func shouldMerge(
 	tree *BTree, node BNode,
 	idx uint16, updated BNode,
 ) (int, BNode) {
-	if updated.nbytes() > BTREE_PAGE_SIZE/4 {
+	if rangeSize(updated, 0, updated.nkeys()) > BTREE_PAGE_SIZE/4 {
 		return 0, BNode{}
 	}
 	if idx > 0 {
-		sibling := tree.get(node.getPtr(idx - 1))
-		merged := sibling.nbytes() + updated.nbytes() - HEADER_SIZE
-		if merged <= BTREE_PAGE_SIZE {
+		sibling := tree.node(node.getPtr(idx - 1))
+		if mergedSize(sibling, updated) <= BTREE_PAGE_SIZE {
 			return -1, sibling
 		}
 	}
 	if idx+1 < node.nkeys() {
-		sibling := tree.get(node.getPtr(idx + 1))
-		merged := sibling.nbytes() + updated.nbytes() - HEADER_SIZE
-		if merged <= BTREE_PAGE_SIZE {
+		sibling := tree.node(node.getPtr(idx + 1))
+		if mergedSize(updated, sibling) <= BTREE_PAGE_SIZE {
 			return +1, sibling
 		}
 	}
 	return 0, BNode{}
 }
-func (tree *BTree) Delete(key []byte) bool {
 
+// read a B-tree node, a page of another type means a corrupted pointer
+func (tree *BTree) node(ptr uint64) BNode {
+	node := tree.get(ptr)
+	if t := node.btype(); t != BNODE_NODE && t != BNODE_LEAF {
+		panic(&CorruptError{Page: ptr, Reason: fmt.Sprintf("bad node type %d", t)})
+	}
+	if v := node.version(); v > BNODE_V2 || len(node.prefix()) > BNODE_MAX_PREFIX {
+		panic(&CorruptError{Page: ptr, Reason: fmt.Sprintf("bad node format version %d", v)})
+	}
+	return node
+}
+
+// Delete removes a key, returns false if the key doesn't exist.
+// On ErrCorrupt the tree may be half updated and should be discarded.
+func (tree *BTree) Delete(key []byte) (deleted bool, err error) {
+	if err := checkKey(key); err != nil {
+		return false, err
+	}
+	defer recoverCorrupt(&err)
 	if tree.root == 0 {
-		return false
+		return false, nil
 	}
-	updated := treeDelete(tree, tree.get(tree.root), key)
+	updated := treeDelete(tree, tree.node(tree.root), key)
 	if len(updated.data) == 0 {
-		return false // not found
+		return false, nil // not found
 	}
 	tree.del(tree.root)
 	if updated.btype() == BNODE_NODE && updated.nkeys() == 1 { // remove a level
 		tree.root = updated.getPtr(0)
 	} else {
-		tree.root = tree.new(updated)
+		tree.setRoot(updated)
 	}
-	return true
+	return true, nil
 }
-func (tree *BTree) Insert(key []byte, val []byte) {
+
+// modes of the updates
+const (
+	MODE_UPSERT      = 0 // insert or replace
+	MODE_UPDATE_ONLY = 1 // update existing keys
+	MODE_INSERT_ONLY = 2 // only add new keys
+	MODE_CAS         = 3 // update the existing key if its value is Expected
+)
+
+// UpdateReq is an insert or update with a mode
+type UpdateReq struct {
+	Key      []byte
+	Val      []byte
+	Mode     int
+	Expected []byte // the old value for MODE_CAS
+	// out
+	Updated bool   // the key was added or its value was changed
+	Found   bool   // the key existed
+	Old     []byte // the previous value if found
+}
+
+// is the tree changed by the request? Found and Old are known.
+// setting a key to the value it already has is not a change,
+// a CAS that matches is Found && bytes.Equal(Old, Expected).
+func (req *UpdateReq) changes() bool {
+	if req.Found && bytes.Equal(req.Old, req.Val) {
+		return false
+	}
+	switch req.Mode {
+	case MODE_UPDATE_ONLY:
+		return req.Found
+	case MODE_INSERT_ONLY:
+		return !req.Found
+	case MODE_CAS:
+		return req.Found && bytes.Equal(req.Old, req.Expected)
+	default:
+		return true
+	}
+}
+
+// Insert adds or replaces a key.
+// On ErrCorrupt the tree may be half updated and should be discarded.
+func (tree *BTree) Insert(key []byte, val []byte) error {
+	return tree.Update(&UpdateReq{Key: key, Val: val})
+}
+
+// Update applies the request, the results are in the request.
+// Nothing is modified if the tree is not changed.
+// On ErrCorrupt the tree may be half updated and should be discarded.
+func (tree *BTree) Update(req *UpdateReq) (err error) {
+	req.Updated, req.Found, req.Old = false, false, nil
+	if err := checkKey(req.Key); err != nil {
+		return err
+	}
+	if len(req.Val) > BTREE_MAX_BLOB_SIZE {
+		return ErrValueTooLarge
+	}
+	if req.Mode < MODE_UPSERT || req.Mode > MODE_CAS {
+		return ErrBadMode
+	}
+	defer recoverCorrupt(&err)
 	if tree.root == 0 {
+		if !req.changes() {
+			return nil
+		}
+		val, vflag := leafStore(tree, req.Val)
 		// create the first node
 		root := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
-		root.setHeader(BNODE_LEAF, 2)
+		root.setPrefixHeader(BNODE_LEAF, 2, nil)
 		// a dummy key, this makes the tree cover the whole key space.
 		// thus a lookup can always find a containing node.
 		nodeAppendKV(root, 0, 0, nil, nil)
-		nodeAppendKV(root, 1, 0, key, val)
+		nodeAppendKV(root, 1, 0, req.Key, val)
+		root.setValFlag(1, vflag)
 		tree.root = tree.new(root)
-		return
+		req.Updated = true
+		return nil
+	}
+	node := treeInsert(tree, tree.node(tree.root), req)
+	if len(node.data) == 0 {
+		return nil // not changed
 	}
-	node := tree.get(tree.root)
 	tree.del(tree.root)
-	node = treeInsert(tree, node, key, val)
+	req.Updated = true
+	tree.setRoot(node)
+	return nil
+}
+
+// allocate the updated root node, split if needed
+func (tree *BTree) setRoot(node BNode) {
 	nsplit, splitted := nodeSplit3(node)
 	if nsplit > 1 {
 		// the root was split, add a new level.
 		root := BNode{data: make([]byte, BTREE_PAGE_SIZE)}
-		root.setHeader(BNODE_NODE, nsplit)
+		root.setPrefixHeader(BNODE_NODE, nsplit, nil)
 		for i, knode := range splitted[:nsplit] {
-			ptr, key := tree.new(knode), knode.getKey(0)
+			ptr, key := tree.newNode(knode), knode.getKey(0)
 			nodeAppendKV(root, uint16(i), ptr, key, nil)
 		}
 		tree.root = tree.new(root)
 	} else {
-		tree.root = tree.new(splitted[0])
+		tree.root = tree.newNode(splitted[0])
 	}
 }
 
 // nodeReplace2Kid replaces two consecutive child nodes with a single merged node
 func nodeReplace2Kid(new BNode, old BNode, idx uint16, ptr uint64, key []byte) {
-	new.setHeader(BNODE_NODE, old.nkeys()-1)
+	new.setPrefixHeader(BNODE_NODE, old.nkeys()-1, sharedPrefix(old, key))
 	// Copy nodes before idx
 	nodeAppendRange(new, old, 0, 0, idx)
 	// Add the merged node
@@ -326,23 +516,27 @@ func nodeReplace2Kid(new BNode, old BNode, idx uint16, ptr uint64, key []byte) {
 }
 
 // Get retrieves the value associated with the given key
-func (tree *BTree) Get(key []byte) ([]byte, bool) {
+func (tree *BTree) Get(key []byte) (val []byte, ok bool, err error) {
+	if err := checkKey(key); err != nil {
+		return nil, false, err
+	}
+	defer recoverCorrupt(&err)
 	if tree.root == 0 {
-		return nil, false
+		return nil, false, nil
 	}
 
-	node := tree.get(tree.root)
+	node := tree.node(tree.root)
 	for {
-		idx := nodeLookupLE(node, key)
+		idx, found := nodeLookup(node, key)
 
 		if node.btype() == BNODE_LEAF {
-			if idx < node.nkeys() && bytes.Equal(key, node.getKey(idx)) {
-				return node.getVal(idx), true
+			if found {
+				return leafValue(tree, node, idx), true, nil
 			}
-			return nil, false
+			return nil, false, nil
 		}
 
-		node = tree.get(node.getPtr(idx))
+		node = tree.node(node.getPtr(idx))
 	}
 }
 
@@ -0,0 +1,224 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+)
+
+// Upgrading the older formats.
+// The files written before the page checksums have a 4-byte node header
+// without the checksum field, so the pages can't be converted in place.
+// | Type (2B) | Num Keys (2B) | Pointers (8B each) | Offsets (2B each) | Key-Value Pairs |
+// Upgrade reads the whole old file into memory, replays its log, and bulk
+// loads the keys into a new file that replaces the old one with an atomic
+// rename, like Compact. The old free list is dropped.
+//
+// The signatures of the older formats:
+//   - 05: a single master page without the checksum, at the beginning of page 0.
+//   - 06: the master page in 2 checksummed slots, like the current format.
+
+const DB_SIG_V5 = "BuildYourOwnDB05"
+const DB_SIG_V6 = "BuildYourOwnDB06"
+const OLD_HEADER_SIZE = 4
+const UPGRADE_SUFFIX = ".upgrade" // the new file before the rename
+
+// a file of an older format in memory
+type oldFile struct {
+	data []byte
+	root uint64
+	used uint64
+}
+
+// Upgrade converts a file of an older format to the current one,
+// returns the number of keys. The database must not be open.
+func Upgrade(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) == 0 || len(data)%BTREE_PAGE_SIZE != 0 {
+		return 0, errors.New("file size not a multiple of page size")
+	}
+	old := &oldFile{data: data}
+	if err := old.loadMaster(); err != nil {
+		return 0, err
+	}
+	logData, err := os.ReadFile(path + WAL_SUFFIX)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, fmt.Errorf("read log: %w", err)
+	}
+	if err := old.replay(logData); err != nil {
+		return 0, err
+	}
+
+	newPath := path + UPGRADE_SUFFIX
+	if err := os.Remove(newPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	n, err := old.copyTo(newPath)
+	if err == nil {
+		err = os.Rename(newPath, path)
+	}
+	if err != nil {
+		_ = os.Remove(newPath)
+		return 0, fmt.Errorf("upgrade: %w", err)
+	}
+	if err := syncDir(path); err != nil {
+		return 0, err
+	}
+	// the log is in the new file
+	if err := os.Remove(path + WAL_SUFFIX); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	return n, nil
+}
+
+// the newest valid master page of the old formats
+func (old *oldFile) loadMaster() error {
+	page := old.data[:BTREE_PAGE_SIZE]
+	var meta []byte
+	seq := uint64(0)
+	for slot := 0; slot < 2; slot++ {
+		data := page[slot*MASTER_SLOT_OFFSET:][:MASTER_SLOT_SIZE]
+		sum := binary.LittleEndian.Uint32(data[MASTER_SIZE+8:])
+		if crc32.Checksum(data[:MASTER_SIZE+8], crc32c) != sum {
+			continue
+		}
+		if bytes.Equal([]byte(DB_SIG), data[:16]) {
+			return errors.New("the file is already in the current format")
+		}
+		slotSeq := binary.LittleEndian.Uint64(data[MASTER_SIZE:])
+		if !bytes.Equal([]byte(DB_SIG_V6), data[:16]) || old.setMeta(data) != nil {
+			continue
+		}
+		if meta == nil || slotSeq > seq {
+			meta, seq = data, slotSeq
+		}
+	}
+	if meta == nil && bytes.Equal([]byte(DB_SIG_V5), page[:16]) {
+		meta = page[:MASTER_SIZE]
+	}
+	if meta == nil {
+		return errors.New("bad master page")
+	}
+	return old.setMeta(meta)
+}
+
+// the tree fields of a master page, the free list is ignored
+func (old *oldFile) setMeta(meta []byte) error {
+	root := binary.LittleEndian.Uint64(meta[16:])
+	used := binary.LittleEndian.Uint64(meta[24:])
+	if !(1 <= used && used <= uint64(len(old.data)/BTREE_PAGE_SIZE) && root < used) {
+		return errors.New("bad master page")
+	}
+	old.root, old.used = root, used
+	return nil
+}
+
+// apply the complete log records, the pages are of the old format too
+func (old *oldFile) replay(data []byte) error {
+	for {
+		body, rest, ok := walNext(data)
+		if !ok {
+			return nil // the rest is a torn write
+		}
+		meta := body[:MASTER_SIZE]
+		used := binary.LittleEndian.Uint64(meta[24:])
+		if need := int(used) * BTREE_PAGE_SIZE; need > len(old.data) {
+			old.data = append(old.data, make([]byte, need-len(old.data))...)
+		}
+		for pages := body[MASTER_SIZE+4:]; len(pages) > 0; pages = pages[8+BTREE_PAGE_SIZE:] {
+			ptr := binary.LittleEndian.Uint64(pages)
+			if ptr == 0 || ptr >= used {
+				return errors.New("bad log record")
+			}
+			copy(old.data[ptr*BTREE_PAGE_SIZE:], pages[8:8+BTREE_PAGE_SIZE])
+		}
+		if err := old.setMeta(meta); err != nil {
+			return err
+		}
+		data = rest
+	}
+}
+
+// bulk load the keys into a new file
+func (old *oldFile) copyTo(path string) (int, error) {
+	db := &KV{Path: path}
+	if err := db.Open(); err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	tx := db.Begin()
+	bl, err := tx.BulkLoad(BULK_FILL)
+	if err != nil {
+		tx.Abort()
+		return 0, err
+	}
+	n := 0
+	if old.root != 0 {
+		err = old.walk(old.root, 1, func(key []byte, val []byte) error {
+			if len(key) == 0 {
+				return nil // the dummy key
+			}
+			n++
+			return bl.Add(key, val)
+		})
+	}
+	if err == nil {
+		err = bl.Finish()
+	}
+	if err != nil {
+		tx.Abort()
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// visit the pairs of a subtree in order
+func (old *oldFile) walk(ptr uint64, depth int, fn func(key []byte, val []byte) error) error {
+	if ptr == 0 || ptr >= old.used || depth > 64 {
+		return &CorruptError{Page: ptr, Reason: "bad pointer"}
+	}
+	page := old.data[ptr*BTREE_PAGE_SIZE:][:BTREE_PAGE_SIZE]
+	btype := binary.LittleEndian.Uint16(page[0:2])
+	nkeys := int(binary.LittleEndian.Uint16(page[2:4]))
+	if btype != BNODE_NODE && btype != BNODE_LEAF {
+		return &CorruptError{Page: ptr, Reason: fmt.Sprintf("bad node type %d", btype)}
+	}
+	kvStart := OLD_HEADER_SIZE + 10*nkeys
+	if nkeys == 0 || kvStart > BTREE_PAGE_SIZE {
+		return &CorruptError{Page: ptr, Reason: fmt.Sprintf("bad number of keys %d", nkeys)}
+	}
+	for i := 0; i < nkeys; i++ {
+		pos := kvStart
+		if i > 0 {
+			pos += int(binary.LittleEndian.Uint16(page[OLD_HEADER_SIZE+8*nkeys+2*(i-1):]))
+		}
+		if pos+4 > BTREE_PAGE_SIZE {
+			return &CorruptError{Page: ptr, Reason: "bad offset"}
+		}
+		klen := int(binary.LittleEndian.Uint16(page[pos:]))
+		vlen := int(binary.LittleEndian.Uint16(page[pos+2:]))
+		if pos+4+klen+vlen > BTREE_PAGE_SIZE {
+			return &CorruptError{Page: ptr, Reason: "bad key or value size"}
+		}
+		var err error
+		if btype == BNODE_NODE {
+			kid := binary.LittleEndian.Uint64(page[OLD_HEADER_SIZE+8*i:])
+			err = old.walk(kid, depth+1, fn)
+		} else {
+			err = fn(page[pos+4:][:klen], page[pos+4+klen:][:vlen])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// a page in the node format before the checksums
+func oldNodePage(btype uint16, keys []string, vals []string, ptrs []uint64) []byte {
+	page := make([]byte, BTREE_PAGE_SIZE)
+	n := len(keys)
+	binary.LittleEndian.PutUint16(page[0:], btype)
+	binary.LittleEndian.PutUint16(page[2:], uint16(n))
+	for i, ptr := range ptrs {
+		binary.LittleEndian.PutUint64(page[OLD_HEADER_SIZE+8*i:], ptr)
+	}
+	kvStart := OLD_HEADER_SIZE + 10*n
+	offset := 0
+	for i, key := range keys {
+		if i > 0 {
+			binary.LittleEndian.PutUint16(page[OLD_HEADER_SIZE+8*n+2*(i-1):], uint16(offset))
+		}
+		val := ""
+		if vals != nil {
+			val = vals[i]
+		}
+		pos := kvStart + offset
+		binary.LittleEndian.PutUint16(page[pos:], uint16(len(key)))
+		binary.LittleEndian.PutUint16(page[pos+2:], uint16(len(val)))
+		copy(page[pos+4:], key)
+		copy(page[pos+4+len(key):], val)
+		offset += 4 + len(key) + len(val)
+	}
+	return page
+}
+
+func oldMeta(sig string, root uint64, used uint64) []byte {
+	meta := make([]byte, MASTER_SIZE)
+	copy(meta, sig)
+	binary.LittleEndian.PutUint64(meta[16:], root)
+	binary.LittleEndian.PutUint64(meta[24:], used)
+	return meta
+}
+
+func oldSlot(meta []byte, seq uint64) []byte {
+	data := append(append([]byte{}, meta...), make([]byte, 12)...)
+	binary.LittleEndian.PutUint64(data[MASTER_SIZE:], seq)
+	binary.LittleEndian.PutUint32(data[MASTER_SIZE+8:], crc32.Checksum(data[:MASTER_SIZE+8], crc32c))
+	return data
+}
+
+// 2 leaves under a root, the keys k00..k19
+func oldTreePages() (pages [][]byte, ref map[string]string) {
+	ref = map[string]string{}
+	leaves := [2][]string{{""}, {}}
+	vals := [2][]string{{""}, {}}
+	for i := 0; i < 20; i++ {
+		key, val := fmt.Sprintf("k%02d", i), fmt.Sprintf("v%d", i)
+		ref[key] = val
+		leaves[i/10] = append(leaves[i/10], key)
+		vals[i/10] = append(vals[i/10], val)
+	}
+	pages = [][]byte{
+		nil, // the master page
+		oldNodePage(BNODE_NODE, []string{"", "k10"}, nil, []uint64{2, 3}),
+		oldNodePage(BNODE_LEAF, leaves[0], vals[0], nil),
+		oldNodePage(BNODE_LEAF, leaves[1], vals[1], nil),
+	}
+	return pages, ref
+}
+
+func writeOldFile(t *testing.T, path string, master []byte, pages [][]byte) {
+	pages[0] = master
+	var data []byte
+	for _, page := range pages {
+		data = append(data, page...)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkUpgraded(t *testing.T, path string, ref map[string]string) {
+	n, err := Upgrade(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(ref) {
+		t.Fatalf("upgraded %d keys, want %d", n, len(ref))
+	}
+	r, err := Verify(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.OK() {
+		t.Fatalf("verify: %v", r.Errors)
+	}
+	db := &KV{Path: path}
+	if err := db.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	count := 0
+	for it := db.Scan(nil, nil); it.Valid(); it.Next() {
+		if ref[string(it.Key())] != string(it.Val()) {
+			t.Fatalf("key %q: got %q", it.Key(), it.Val())
+		}
+		count++
+	}
+	if count != len(ref) {
+		t.Fatalf("got %d keys, want %d", count, len(ref))
+	}
+	if _, err := Upgrade(path); err == nil {
+		t.Fatal("upgraded the current format")
+	}
+}
+
+func TestUpgradeV5(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	pages, ref := oldTreePages()
+	master := make([]byte, BTREE_PAGE_SIZE)
+	copy(master, oldMeta(DB_SIG_V5, 1, 4))
+	writeOldFile(t, path, master, pages)
+
+	db := &KV{Path: path}
+	if err := db.Open(); !errors.Is(err, ErrOldFormat) {
+		t.Fatalf("open: %v", err)
+	}
+	checkUpgraded(t, path, ref)
+}
+
+func TestUpgradeV6WithLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	pages, ref := oldTreePages()
+	// the newer slot has the tree, the older one is empty
+	master := make([]byte, BTREE_PAGE_SIZE)
+	copy(master, oldSlot(oldMeta(DB_SIG_V6, 0, 1), 1))
+	copy(master[MASTER_SLOT_OFFSET:], oldSlot(oldMeta(DB_SIG_V6, 1, 4), 2))
+	writeOldFile(t, path, master, pages)
+
+	// a log record replacing the second leaf, then a torn one
+	leaf := oldNodePage(BNODE_LEAF, []string{"k10", "k99"}, []string{"new", "v99"}, nil)
+	root := oldNodePage(BNODE_NODE, []string{"", "k10"}, nil, []uint64{2, 4})
+	rec := make([]byte, WAL_HEADER)
+	rec = append(rec, oldMeta(DB_SIG_V6, 5, 6)...)
+	rec = binary.LittleEndian.AppendUint32(rec, 2)
+	rec = binary.LittleEndian.AppendUint64(rec, 4)
+	rec = append(rec, leaf...)
+	rec = binary.LittleEndian.AppendUint64(rec, 5)
+	rec = append(rec, root...)
+	binary.LittleEndian.PutUint32(rec[0:], uint32(len(rec)-WAL_HEADER))
+	binary.LittleEndian.PutUint32(rec[4:], crc32.Checksum(rec[WAL_HEADER:], crc32c))
+	torn := append(append([]byte{}, rec...), rec[:100]...)
+	if err := os.WriteFile(path+WAL_SUFFIX, torn, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 11; i < 20; i++ {
+		delete(ref, fmt.Sprintf("k%02d", i))
+	}
+	ref["k10"], ref["k99"] = "new", "v99"
+
+	db := &KV{Path: path}
+	if err := db.Open(); !errors.Is(err, ErrOldFormat) {
+		t.Fatalf("open: %v", err)
+	}
+	checkUpgraded(t, path, ref)
+	if _, err := os.Stat(path + WAL_SUFFIX); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("the log is not removed: %v", err)
+	}
+}
+
+func TestUpgradeCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	pages, _ := oldTreePages()
+	binary.LittleEndian.PutUint64(pages[1][OLD_HEADER_SIZE+8:], 99) // a bad pointer
+	master := make([]byte, BTREE_PAGE_SIZE)
+	copy(master, oldMeta(DB_SIG_V5, 1, 4))
+	writeOldFile(t, path, master, pages)
+
+	if _, err := Upgrade(path); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("upgrade: %v", err)
+	}
+	if _, err := os.Stat(path + UPGRADE_SUFFIX); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("the new file is not removed: %v", err)
+	}
+}
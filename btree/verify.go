@@ -0,0 +1,365 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// VerifyReport is the result of checking a database file offline.
+type VerifyReport struct {
+	Path string
+	// the master page
+	Slot  int
+	Seq   uint64
+	Pages uint64 // pages in use, including the master page
+	Root  uint64
+	// the B-tree
+	Depth  int
+	Nodes  int // internal nodes
+	Leaves int
+	Keys   int // not counting the dummy key
+	// nodes in the formats before version 2, see Migrate
+	OldNodes int
+	// pages of large values
+	Overflows int
+	// the free list
+	FreeNodes int
+	FreeItems int
+	// pages neither in the tree nor in the free list
+	Leaked []uint64
+	// problems found, a good file has none
+	Errors   []string
+	Warnings []string
+}
+
+const VERIFY_MAX_ERRORS = 100
+
+func (r *VerifyReport) OK() bool {
+	return len(r.Errors) == 0 && len(r.Leaked) == 0
+}
+
+func (r *VerifyReport) errorf(format string, args ...any) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+func (r *VerifyReport) Print(w io.Writer) {
+	fmt.Fprintf(w, "file:      %s\n", r.Path)
+	fmt.Fprintf(w, "master:    slot %d, seq %d\n", r.Slot, r.Seq)
+	fmt.Fprintf(w, "pages:     %d\n", r.Pages)
+	fmt.Fprintf(w, "tree:      root %d, depth %d, %d nodes, %d leaves, %d keys\n",
+		r.Root, r.Depth, r.Nodes, r.Leaves, r.Keys)
+	if r.OldNodes > 0 {
+		fmt.Fprintf(w, "format:    %d nodes in an older format\n", r.OldNodes)
+	}
+	fmt.Fprintf(w, "overflow:  %d pages\n", r.Overflows)
+	fmt.Fprintf(w, "free list: %d nodes, %d items\n", r.FreeNodes, r.FreeItems)
+	fmt.Fprintf(w, "leaked:    %d pages\n", len(r.Leaked))
+	for i, ptr := range r.Leaked {
+		if i == VERIFY_MAX_ERRORS {
+			fmt.Fprintf(w, "  ... %d more\n", len(r.Leaked)-i)
+			break
+		}
+		fmt.Fprintf(w, "  page %d\n", ptr)
+	}
+	for _, msg := range r.Warnings {
+		fmt.Fprintf(w, "warning:   %s\n", msg)
+	}
+	fmt.Fprintf(w, "errors:    %d\n", len(r.Errors))
+	for i, msg := range r.Errors {
+		if i == VERIFY_MAX_ERRORS {
+			fmt.Fprintf(w, "  ... %d more\n", len(r.Errors)-i)
+			break
+		}
+		fmt.Fprintf(w, "  %s\n", msg)
+	}
+	if r.OK() {
+		fmt.Fprintln(w, "OK")
+	} else {
+		fmt.Fprintln(w, "FAILED")
+	}
+}
+
+// the state of a verification
+type verifier struct {
+	db     KV // only the mmap and the master page are used
+	report *VerifyReport
+	seen   map[uint64]string // page -> what uses it
+	// some pages were not reached because of the errors
+	incomplete bool
+}
+
+// Verify checks a database file without modifying it.
+// An error is returned only if the file can't be checked at all,
+// the problems found are in the report.
+func Verify(path string) (*VerifyReport, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	fi, err := fp.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+	if fi.Size()%BTREE_PAGE_SIZE != 0 {
+		return nil, fmt.Errorf("file size not a multiple of page size")
+	}
+	if fi.Size() == 0 {
+		return nil, fmt.Errorf("empty file")
+	}
+	chunk, err := syscall.Mmap(int(fp.Fd()), 0, int(fi.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("mmap: %w", err)
+	}
+	defer syscall.Munmap(chunk)
+
+	v := &verifier{report: &VerifyReport{Path: path}, seen: map[uint64]string{}}
+	v.db.mmap.file = len(chunk)
+	v.db.mmap.chunks = [][]byte{chunk}
+	if err := masterLoad(&v.db); err != nil {
+		return nil, err
+	}
+	r := v.report
+	r.Slot, r.Seq = v.db.master.slot, v.db.master.seq
+	r.Pages, r.Root = v.db.page.flushed, v.db.tree.root
+	if fi, err := os.Stat(path + WAL_SUFFIX); err == nil && fi.Size() > 0 {
+		r.Warnings = append(r.Warnings, fmt.Sprintf(
+			"the log has %d bytes to be replayed by Open, the file alone may be inconsistent", fi.Size()))
+	}
+
+	if r.Root != 0 {
+		v.walkTree(r.Root, nil, nil, 1)
+	}
+	v.walkFreeList()
+	if v.incomplete {
+		r.Warnings = append(r.Warnings, "leaked pages not checked, some pages are unreachable due to the errors")
+		return r, nil
+	}
+	for ptr := uint64(1); ptr < r.Pages; ptr++ {
+		if _, ok := v.seen[ptr]; !ok {
+			r.Leaked = append(r.Leaked, ptr)
+		}
+	}
+	return r, nil
+}
+
+// mark a page as used, a page must be used only once
+func (v *verifier) use(ptr uint64, user string) bool {
+	if !(1 <= ptr && ptr < v.report.Pages) {
+		v.report.errorf("page %d (%s): out of bounds [1, %d)", ptr, user, v.report.Pages)
+		return false
+	}
+	if prev, ok := v.seen[ptr]; ok {
+		v.report.errorf("page %d (%s): already used by %s", ptr, user, prev)
+		return false
+	}
+	v.seen[ptr] = user
+	return true
+}
+
+// read a page in use, the content of the free pages is not checked
+func (v *verifier) page(ptr uint64, user string) (BNode, bool) {
+	if !v.use(ptr, user) {
+		return BNode{}, false
+	}
+	node := mmapPage(v.db.mmap.chunks, ptr)
+	if binary.LittleEndian.Uint32(node.data[4:8]) != pageChecksum(node.data) {
+		v.report.errorf("page %d (%s): bad checksum", ptr, user)
+		return BNode{}, false
+	}
+	return node, true
+}
+
+// check the layout of a node so that the accessors don't go out of bounds
+func nodeFormatError(node BNode) string {
+	n := int(node.nkeys())
+	if n == 0 {
+		return "no keys"
+	}
+	plen := 0
+	switch node.version() {
+	case BNODE_V0:
+	case BNODE_V1, BNODE_V2:
+		plen = int(binary.LittleEndian.Uint16(node.data[HEADER_SIZE:]))
+		if (plen == 0 && node.version() == BNODE_V1) || plen > BNODE_MAX_PREFIX {
+			return fmt.Sprintf("bad prefix length %d", plen)
+		}
+	default:
+		return fmt.Sprintf("bad format version %d", node.version())
+	}
+	header := int(node.pairHeader())
+	base := int(node.base()) + int(node.ptrSize()+2)*n
+	if base > BTREE_PAGE_SIZE {
+		return fmt.Sprintf("too many keys: %d", n)
+	}
+	pos := base
+	for i := 0; i < n; i++ {
+		if pos+header > BTREE_PAGE_SIZE {
+			return "key-value pairs out of the page"
+		}
+		klen := int(binary.LittleEndian.Uint16(node.data[pos:]))
+		vlen, overflow := 0, false
+		if header == 4 {
+			vlen = int(binary.LittleEndian.Uint16(node.data[pos+2:]) &^ VAL_OVERFLOW)
+			overflow = node.data[pos+3]&(VAL_OVERFLOW>>8) != 0
+		}
+		pos += header + klen + vlen
+		if pos > BTREE_PAGE_SIZE {
+			return "key-value pairs out of the page"
+		}
+		if base+int(node.getOffset(uint16(i+1))) != pos {
+			return fmt.Sprintf("bad offset of key %d", i+1)
+		}
+		if plen+klen > BTREE_MAX_KEY_SIZE || vlen > BTREE_MAX_VAL_SIZE {
+			return fmt.Sprintf("key %d too large", i)
+		}
+		if overflow && (node.btype() != BNODE_LEAF || vlen != OVERFLOW_REF_SIZE) {
+			return fmt.Sprintf("bad overflow reference at key %d", i)
+		}
+	}
+	return ""
+}
+
+// check the subtree, its keys are in [lo, hi) and the first key is lo.
+// hi is nil for the rightmost subtrees.
+func (v *verifier) walkTree(ptr uint64, lo []byte, hi []byte, depth int) {
+	r := v.report
+	node, ok := v.page(ptr, "tree")
+	if !ok {
+		v.incomplete = true
+		return
+	}
+	if msg := nodeFormatError(node); msg != "" {
+		r.errorf("page %d: %s", ptr, msg)
+		v.incomplete = true
+		return
+	}
+	if node.version() < BNODE_V2 {
+		r.OldNodes++
+	}
+	switch node.btype() {
+	case BNODE_LEAF:
+		r.Leaves++
+		r.Keys += int(node.nkeys())
+		if len(lo) == 0 {
+			r.Keys-- // the dummy key of the leftmost leaf
+		}
+		if r.Depth == 0 {
+			r.Depth = depth
+		} else if depth != r.Depth {
+			r.errorf("page %d: leaf at depth %d, others at %d", ptr, depth, r.Depth)
+		}
+	case BNODE_NODE:
+		r.Nodes++
+	default:
+		r.errorf("page %d: bad node type %d", ptr, node.btype())
+		v.incomplete = true
+		return
+	}
+
+	nkeys := node.nkeys()
+	if !bytes.Equal(node.getKey(0), lo) {
+		r.errorf("page %d: the first key %q doesn't match the parent key %q", ptr, node.getKey(0), lo)
+	}
+	for i := uint16(0); i < nkeys; i++ {
+		key := node.getKey(i)
+		if i > 0 && bytes.Compare(node.getKey(i-1), key) >= 0 {
+			r.errorf("page %d: key %d %q out of order", ptr, i, key)
+		}
+		if hi != nil && bytes.Compare(key, hi) >= 0 {
+			r.errorf("page %d: key %d %q not less than the next parent key %q", ptr, i, key, hi)
+		}
+		if node.btype() == BNODE_NODE && len(node.getVal(i)) > 0 {
+			r.errorf("page %d: internal node with value at key %d", ptr, i)
+		}
+	}
+	if node.btype() == BNODE_LEAF {
+		for i := uint16(0); i < nkeys; i++ {
+			if node.valFlag(i) == VAL_OVERFLOW {
+				v.walkOverflow(ptr, node.getKey(i), node.getVal(i))
+			}
+		}
+		return
+	}
+	for i := uint16(0); i < nkeys; i++ {
+		next := hi
+		if i+1 < nkeys {
+			next = node.getKey(i + 1)
+		}
+		v.walkTree(node.getPtr(i), node.getKey(i), next, depth+1)
+	}
+}
+
+// check the overflow pages of a large value
+func (v *verifier) walkOverflow(leaf uint64, key []byte, ref []byte) {
+	r := v.report
+	size := binary.LittleEndian.Uint64(ref[0:8])
+	ptr := binary.LittleEndian.Uint64(ref[8:16])
+	if size <= BTREE_MAX_VAL_SIZE || size > BTREE_MAX_BLOB_SIZE {
+		r.errorf("page %d: key %q: bad overflow size %d", leaf, key, size)
+		return
+	}
+	for npages := (size + OVERFLOW_CAP - 1) / OVERFLOW_CAP; npages > 0; npages-- {
+		node, ok := v.page(ptr, "overflow")
+		if !ok {
+			v.incomplete = true
+			return
+		}
+		if node.btype() != BNODE_OVERFLOW {
+			r.errorf("page %d: bad overflow page type %d", ptr, node.btype())
+			v.incomplete = true
+			return
+		}
+		r.Overflows++
+		ptr = binary.LittleEndian.Uint64(node.data[8:16])
+	}
+	if ptr != 0 {
+		r.errorf("page %d: key %q: overflow pages longer than the size %d", leaf, key, size)
+	}
+}
+
+// follow the free list from the head to the tail
+func (v *verifier) walkFreeList() {
+	r := v.report
+	fl := &v.db.free
+	if fl.tailPage == 0 {
+		if fl.headPage != 0 || fl.headSeq != 0 || fl.tailSeq != 0 {
+			r.errorf("free list: no tail node, head %d seq %d-%d", fl.headPage, fl.headSeq, fl.tailSeq)
+		}
+		return
+	}
+	ptr := fl.headPage
+	node, ok := v.freeNode(ptr)
+	for seq := fl.headSeq; ok && seq < fl.tailSeq; seq++ {
+		item := flnPtr(node, seq2idx(seq))
+		if v.use(item, "free list item") {
+			r.FreeItems++
+		}
+		if seq2idx(seq+1) == 0 {
+			ptr = flnNext(node)
+			node, ok = v.freeNode(ptr)
+		}
+	}
+	if ok && ptr != fl.tailPage {
+		r.errorf("free list: ends at page %d, the tail is %d", ptr, fl.tailPage)
+	}
+}
+
+func (v *verifier) freeNode(ptr uint64) (BNode, bool) {
+	node, ok := v.page(ptr, "free list node")
+	if !ok {
+		v.incomplete = true
+		return node, false
+	}
+	if node.btype() != BNODE_FREE_LIST {
+		v.report.errorf("page %d: bad free list node type %d", ptr, node.btype())
+		v.incomplete = true
+		return node, false
+	}
+	v.report.FreeNodes++
+	return node, true
+}
@@ -0,0 +1,229 @@
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+
+	"killerDB/filedb"
+)
+
+// The write-ahead log.
+// In the WAL mode a commit appends the new pages and the new master page to
+// the log with a single fsync, the pages are also copied to the mmap but the
+// main file is not synced. A checkpoint syncs the main file, writes the
+// master page and truncates the log. It runs in the background when the log
+// grows or periodically, and on Close.
+// The log is replayed by Open after a crash, in or out of the WAL mode.
+//
+// The pages overwritten since the last checkpoint are all in the log, so
+// replaying the complete records restores any page torn by the crash.
+
+const WAL_SUFFIX = ".wal"
+const WAL_MAX_SIZE = 16 << 20        // checkpoint when the log is larger
+const WAL_INTERVAL = 1 * time.Second // checkpoint at least this often
+
+// the log record format.
+// | size | crc32c | master page | npages | ptr | page | ptr | page | ...
+// |  4B  |   4B   |     64B     |   4B   | 8B  |  4K  | 8B  |  4K  | ...
+// the size and the checksum cover the bytes after them.
+const WAL_HEADER = 8
+
+// the pending pages and the master page as a log record
+func walRecord(db *KV) []byte {
+	rec := make([]byte, WAL_HEADER, WAL_HEADER+MASTER_SIZE+4)
+	rec = append(rec, saveMeta(db)...)
+	rec = binary.LittleEndian.AppendUint32(rec, 0)
+	npages := uint32(0)
+	for ptr, page := range db.page.updates {
+		if page != nil {
+			rec = binary.LittleEndian.AppendUint64(rec, ptr)
+			rec = append(rec, page...)
+			npages++
+		}
+	}
+	binary.LittleEndian.PutUint32(rec[WAL_HEADER+MASTER_SIZE:], npages)
+	binary.LittleEndian.PutUint32(rec[0:], uint32(len(rec)-WAL_HEADER))
+	binary.LittleEndian.PutUint32(rec[4:], crc32.Checksum(rec[WAL_HEADER:], crc32c))
+	return rec
+}
+
+// the next complete record, false at the end or at a torn write
+func walNext(data []byte) (body []byte, rest []byte, ok bool) {
+	if len(data) < WAL_HEADER {
+		return nil, nil, false
+	}
+	size := int(binary.LittleEndian.Uint32(data[0:]))
+	sum := binary.LittleEndian.Uint32(data[4:])
+	if size > len(data)-WAL_HEADER {
+		return nil, nil, false
+	}
+	body = data[WAL_HEADER : WAL_HEADER+size]
+	if crc32.Checksum(body, crc32c) != sum {
+		return nil, nil, false
+	}
+	// the checksum is good, but check the format anyway
+	if size < MASTER_SIZE+4 {
+		return nil, nil, false
+	}
+	npages := int(binary.LittleEndian.Uint32(body[MASTER_SIZE:]))
+	if size != MASTER_SIZE+4+npages*(8+BTREE_PAGE_SIZE) {
+		return nil, nil, false
+	}
+	return body, data[WAL_HEADER+size:], true
+}
+
+// apply the complete records to the main file
+func walReplay(db *KV, data []byte) error {
+	for {
+		body, rest, ok := walNext(data)
+		if !ok {
+			return nil // the rest is a torn write
+		}
+		meta := body[:MASTER_SIZE]
+		used := binary.LittleEndian.Uint64(meta[24:])
+		if err := extendFile(db, int(used)); err != nil {
+			return err
+		}
+		if err := extendMmap(db, int(used)); err != nil {
+			return err
+		}
+		for pages := body[MASTER_SIZE+4:]; len(pages) > 0; pages = pages[8+BTREE_PAGE_SIZE:] {
+			ptr := binary.LittleEndian.Uint64(pages)
+			if ptr == 0 || ptr >= used {
+				return errors.New("bad log record")
+			}
+			copy(mmapPage(db.mmap.chunks, ptr).data, pages[8:8+BTREE_PAGE_SIZE])
+		}
+		loadMeta(db, meta)
+		data = rest
+	}
+}
+
+// replay the log if there is one, then keep it open in the WAL mode
+func walOpen(db *KV) error {
+	path := db.Path + WAL_SUFFIX
+	flags := os.O_RDWR | os.O_APPEND
+	if db.WAL {
+		flags |= os.O_CREATE
+	}
+	fp, err := os.OpenFile(path, flags, 0644)
+	if !db.WAL && errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open log: %w", err)
+	}
+	db.wal.fp = fp
+	db.wal.size = 0
+	data, err := io.ReadAll(fp)
+	if err != nil {
+		return fmt.Errorf("read log: %w", err)
+	}
+	if len(data) > 0 {
+		if err := walReplay(db, data); err != nil {
+			return err
+		}
+		// also drops the torn write at the end
+		db.wal.size = int64(len(data))
+		if err := checkpoint(db); err != nil {
+			return err
+		}
+	}
+	if !db.WAL {
+		// not needed outside the WAL mode
+		db.wal.fp = nil
+		_ = fp.Close()
+		return os.Remove(path)
+	}
+
+	db.wal.kick = make(chan struct{}, 1)
+	db.wal.done = make(chan struct{})
+	db.wal.exit.Add(1)
+	go checkpointer(db)
+	return nil
+}
+
+// stop the background checkpoint and checkpoint the last commits
+func walClose(db *KV) {
+	if db.wal.done != nil {
+		close(db.wal.done)
+		db.wal.exit.Wait()
+		db.wal.done = nil
+		// the log is replayed by the next Open on errors
+		db.writer.Lock()
+		_ = checkpoint(db)
+		db.writer.Unlock()
+	}
+	if db.wal.fp != nil {
+		_ = db.wal.fp.Close()
+		db.wal.fp = nil
+	}
+}
+
+// commit by appending to the log instead of syncing the main file
+func logPages(db *KV) error {
+	db.page.flushed += uint64(db.page.nappend)
+	rec := walRecord(db)
+	if err := filedb.LogAppend(db.wal.fp, rec); err != nil {
+		return fmt.Errorf("write log: %w", err)
+	}
+	db.wal.size += int64(len(rec))
+	discardPages(db)
+	if db.wal.size >= WAL_MAX_SIZE {
+		select {
+		case db.wal.kick <- struct{}{}:
+		default: // already kicked
+		}
+	}
+	return nil
+}
+
+func walTruncate(db *KV, size int64) error {
+	if err := db.wal.fp.Truncate(size); err != nil {
+		return fmt.Errorf("truncate log: %w", err)
+	}
+	if err := db.wal.fp.Sync(); err != nil {
+		return fmt.Errorf("fsync log: %w", err)
+	}
+	return nil
+}
+
+// make the main file up to date and empty the log.
+// the caller holds the writer lock, or is the only user of the KV.
+func checkpoint(db *KV) error {
+	if db.wal.size == 0 && !db.failed {
+		return nil
+	}
+	if err := syncMaster(db); err != nil {
+		return err
+	}
+	// the records are all checkpointed, replaying them again is harmless
+	if err := walTruncate(db, 0); err != nil {
+		return err
+	}
+	db.wal.size = 0
+	db.failed = false
+	return nil
+}
+
+func checkpointer(db *KV) {
+	defer db.wal.exit.Done()
+	ticker := time.NewTicker(WAL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.wal.done:
+			return
+		case <-ticker.C:
+		case <-db.wal.kick:
+		}
+		db.writer.Lock()
+		_ = checkpoint(db) // retried later, the log is kept on errors
+		db.writer.Unlock()
+	}
+}
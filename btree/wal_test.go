@@ -0,0 +1,155 @@
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openKV(t *testing.T, path string, wal bool) *KV {
+	t.Helper()
+	db := &KV{Path: path, WAL: wal}
+	if err := db.Open(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// the keys and values of the KV are the same as ref
+func checkKV(t *testing.T, db *KV, ref map[string]string) {
+	t.Helper()
+	n := 0
+	iter := db.Scan(nil, nil)
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		val, ok := ref[string(iter.Key())]
+		if !ok || val != string(iter.Val()) {
+			t.Fatalf("key %q: got %q, want %q", iter.Key(), iter.Val(), val)
+		}
+		n++
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(ref) {
+		t.Fatalf("got %d keys, want %d", n, len(ref))
+	}
+}
+
+func checkVerify(t *testing.T, path string) *VerifyReport {
+	t.Helper()
+	r, err := Verify(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.OK() {
+		t.Fatalf("verify: %v", r.Errors)
+	}
+	return r
+}
+
+// stop the background checkpoint, Close then leaves the log as a crash does
+func walStop(db *KV) {
+	close(db.wal.done)
+	db.wal.exit.Wait()
+	db.wal.done = nil
+}
+
+func copyFile(t *testing.T, src string, dst string) {
+	t.Helper()
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWALReplay(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "db")
+	db := openKV(t, path, true)
+	walStop(db)
+	ref := map[string]string{}
+	var last int64 // the log size before the last commit
+	for i := 0; i < 100; i++ {
+		last = db.wal.size
+		key, val := fmt.Sprintf("key%03d", i), fmt.Sprintf("val%d", i)
+		if err := db.Set([]byte(key), []byte(val)); err != nil {
+			t.Fatal(err)
+		}
+		ref[key] = val
+	}
+	size := db.wal.size
+	db.Close()
+	if fi, err := os.Stat(path + WAL_SUFFIX); err != nil || fi.Size() != size {
+		t.Fatalf("log: %v", err)
+	}
+	// without the last commit
+	prev := map[string]string{}
+	for key, val := range ref {
+		prev[key] = val
+	}
+	delete(prev, "key099")
+
+	cases := []struct {
+		name string
+		edit func(log []byte) []byte
+		want map[string]string
+	}{
+		{"complete", func(log []byte) []byte { return log }, ref},
+		{"torn", func(log []byte) []byte { return log[:size-10] }, prev},
+		{"torn header", func(log []byte) []byte { return log[:last+5] }, prev},
+		{"bad checksum", func(log []byte) []byte { log[size-1] ^= 1; return log }, prev},
+		{"garbage", func(log []byte) []byte { return append(log, 1, 2, 3, 4, 5, 6, 7, 8, 9) }, ref},
+	}
+	for i, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			crash := filepath.Join(dir, fmt.Sprintf("crash%d", i))
+			copyFile(t, path, crash)
+			log, err := os.ReadFile(path + WAL_SUFFIX)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(crash+WAL_SUFFIX, c.edit(log), 0644); err != nil {
+				t.Fatal(err)
+			}
+			db := openKV(t, crash, false)
+			checkKV(t, db, c.want)
+			db.Close()
+			// checkpointed and removed outside the WAL mode
+			if _, err := os.Stat(crash + WAL_SUFFIX); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("the log is not removed: %v", err)
+			}
+			checkVerify(t, crash)
+			db = openKV(t, crash, true)
+			checkKV(t, db, c.want)
+			db.Close()
+		})
+	}
+}
+
+func TestWALCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := openKV(t, path, true)
+	ref := map[string]string{}
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if err := db.Set([]byte(key), []byte("val")); err != nil {
+			t.Fatal(err)
+		}
+		ref[key] = "val"
+	}
+	db.Close()
+	fi, err := os.Stat(path + WAL_SUFFIX)
+	if err != nil || fi.Size() != 0 {
+		t.Fatalf("the log is not checkpointed by Close: %v", err)
+	}
+	checkVerify(t, path)
+	db = openKV(t, path, false)
+	checkKV(t, db, ref)
+	db.Close()
+}
@@ -0,0 +1,197 @@
+package client
+
+import (
+	"context"
+	"fmt"
+)
+
+// Pipeline sends several commands in one round trip.
+// The commands are not atomic, each has its own result.
+type Pipeline struct {
+	c   *Client
+	ops []op
+}
+
+// Txn is like Pipeline but the commands are run in a single transaction.
+// They are sent together at the end, so the results of the reads can't
+// decide the writes in the same transaction.
+type Txn struct {
+	ops []op
+}
+
+type op struct {
+	cmd string // GET, SET or DEL
+	key []byte
+	val []byte
+}
+
+// Result is the result of a command in a Pipeline or a Txn
+type Result struct {
+	Val   []byte // the value of Get
+	Found bool   // Get: the key exists, Del: the key was deleted
+	Err   error  // a failed command doesn't fail the others
+}
+
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{c: c}
+}
+
+func (p *Pipeline) Get(key []byte) *Pipeline {
+	p.ops = append(p.ops, op{cmd: "GET", key: key})
+	return p
+}
+
+func (p *Pipeline) Set(key []byte, val []byte) *Pipeline {
+	p.ops = append(p.ops, op{cmd: "SET", key: key, val: val})
+	return p
+}
+
+func (p *Pipeline) Del(key []byte) *Pipeline {
+	p.ops = append(p.ops, op{cmd: "DEL", key: key})
+	return p
+}
+
+func (tx *Txn) Get(key []byte) *Txn {
+	tx.ops = append(tx.ops, op{cmd: "GET", key: key})
+	return tx
+}
+
+func (tx *Txn) Set(key []byte, val []byte) *Txn {
+	tx.ops = append(tx.ops, op{cmd: "SET", key: key, val: val})
+	return tx
+}
+
+func (tx *Txn) Del(key []byte) *Txn {
+	tx.ops = append(tx.ops, op{cmd: "DEL", key: key})
+	return tx
+}
+
+// Exec sends the commands and returns a result for each one in order.
+// An error is returned if the commands can't be sent or the replies can't
+// be received, in which case some commands may have been run.
+func (p *Pipeline) Exec(ctx context.Context) ([]Result, error) {
+	if p.c.local != nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		results := make([]Result, len(p.ops))
+		for i, op := range p.ops {
+			results[i] = localOp(p.c.local, op)
+		}
+		return results, nil
+	}
+
+	cmds := make([][][]byte, len(p.ops))
+	for i, op := range p.ops {
+		cmds[i] = op.args()
+	}
+	replies, err := p.c.do(ctx, cmds)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]Result, len(p.ops))
+	for i, op := range p.ops {
+		results[i] = op.result(replies[i])
+	}
+	return results, nil
+}
+
+// Txn runs the commands added by fn in a transaction. Nothing is sent if
+// fn returns an error. A failed command doesn't abort the others, like
+// MULTI/EXEC in redis.
+func (c *Client) Txn(ctx context.Context, fn func(tx *Txn) error) ([]Result, error) {
+	tx := &Txn{}
+	if err := fn(tx); err != nil {
+		return nil, err
+	}
+	if len(tx.ops) == 0 {
+		return nil, nil
+	}
+	if c.local != nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		kvtx := c.local.Begin()
+		results := make([]Result, len(tx.ops))
+		for i, op := range tx.ops {
+			results[i] = localOp(kvtx, op)
+		}
+		if err := kvtx.Commit(); err != nil {
+			return nil, err
+		}
+		return results, nil
+	}
+
+	cmds := [][][]byte{{[]byte("MULTI")}}
+	for _, op := range tx.ops {
+		cmds = append(cmds, op.args())
+	}
+	cmds = append(cmds, [][]byte{[]byte("EXEC")})
+	replies, err := c.do(ctx, cmds)
+	if err != nil {
+		return nil, err
+	}
+	// OK, QUEUED for each command, then the array of the results
+	for _, v := range replies[:len(replies)-1] {
+		if err := v.err(); err != nil {
+			return nil, err
+		}
+	}
+	exec := replies[len(replies)-1]
+	if err := exec.expect('*'); err != nil {
+		return nil, err
+	}
+	if len(exec.array) != len(tx.ops) {
+		return nil, fmt.Errorf("%w: %d results for %d commands", errProtocol, len(exec.array), len(tx.ops))
+	}
+	results := make([]Result, len(tx.ops))
+	for i, op := range tx.ops {
+		results[i] = op.result(exec.array[i])
+	}
+	return results, nil
+}
+
+func (op op) args() [][]byte {
+	if op.cmd == "SET" {
+		return [][]byte{[]byte(op.cmd), op.key, op.val}
+	}
+	return [][]byte{[]byte(op.cmd), op.key}
+}
+
+func (op op) result(v value) Result {
+	if err := v.err(); err != nil {
+		return Result{Err: err}
+	}
+	switch {
+	case op.cmd == "GET" && v.kind == '$':
+		return Result{Val: v.str, Found: !v.null}
+	case op.cmd == "SET" && v.kind == '+':
+		return Result{}
+	case op.cmd == "DEL" && v.kind == ':':
+		return Result{Found: v.int > 0}
+	default:
+		return Result{Err: fmt.Errorf("%w: unexpected reply type %q to %s", errProtocol, v.kind, op.cmd)}
+	}
+}
+
+// the operations shared by KV and KVTX
+type kvOps interface {
+	Get(key []byte) ([]byte, bool, error)
+	Set(key []byte, val []byte) error
+	Del(key []byte) (bool, error)
+}
+
+// the in-process mode
+func localOp(kv kvOps, op op) Result {
+	switch op.cmd {
+	case "GET":
+		val, ok, err := kv.Get(op.key)
+		// the value from a KVTX is only valid until the next update
+		return Result{Val: append([]byte(nil), val...), Found: ok, Err: err}
+	case "SET":
+		return Result{Err: kv.Set(op.key, op.val)}
+	default:
+		deleted, err := kv.Del(op.key)
+		return Result{Found: deleted, Err: err}
+	}
+}
@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"killerDB/btree"
+)
+
+// Client talks to a killerdb server with the RESP protocol, or uses a
+// local KV directly in the in-process mode. The methods mirror the KV
+// and are safe for concurrent use.
+//
+// The requests are retried on network errors if they were never written
+// to the connection. Once written, only the reads (Get, Scan and Ping) are
+// retried; a Set, Del or Txn fails with the network error since the
+// server may have executed it.
+type Client struct {
+	local *btree.KV // the in-process mode
+	// the remote mode
+	addr string
+	opts Options
+	pool pool
+}
+
+// Options of a remote client, the zero values are the defaults
+type Options struct {
+	PoolSize     int           // the maximum number of connections, 8
+	MaxRetries   int           // retries on network errors, 2; -1 for none
+	RetryBackoff time.Duration // before the first retry, doubled each time, 20ms
+	DialTimeout  time.Duration // 5s
+}
+
+// ServerError is an error reply from the server.
+// The errors of the KV such as btree.ErrEmptyKey can be matched with
+// errors.Is, as in the in-process mode.
+type ServerError struct {
+	Msg string
+}
+
+func (e *ServerError) Error() string {
+	return "killerdb: " + e.Msg
+}
+
+func (e *ServerError) Unwrap() error {
+	for _, err := range []error{
+		btree.ErrEmptyKey, btree.ErrKeyTooLarge, btree.ErrValueTooLarge, btree.ErrBadMode,
+	} {
+		if e.Msg == "ERR "+err.Error() {
+			return err
+		}
+	}
+	if strings.HasPrefix(e.Msg, "ERR corrupted page") {
+		return btree.ErrCorrupt
+	}
+	return nil
+}
+
+var ErrClosed = errors.New("killerdb: client closed")
+
+// Dial connects to a server at host:port, or unix:PATH for a Unix socket.
+// opts can be nil.
+func Dial(ctx context.Context, addr string, opts *Options) (*Client, error) {
+	c := &Client{addr: addr}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if c.opts.PoolSize <= 0 {
+		c.opts.PoolSize = 8
+	}
+	if c.opts.MaxRetries == 0 {
+		c.opts.MaxRetries = 2
+	}
+	if c.opts.RetryBackoff <= 0 {
+		c.opts.RetryBackoff = 20 * time.Millisecond
+	}
+	if c.opts.DialTimeout <= 0 {
+		c.opts.DialTimeout = 5 * time.Second
+	}
+	c.pool.init(c.opts.PoolSize)
+	// fail early if the server isn't there
+	if err := c.Ping(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Local wraps an opened KV, the KV is not closed by the client.
+func Local(db *btree.KV) *Client {
+	return &Client{local: db}
+}
+
+// Close closes the connections, the requests in progress are finished.
+func (c *Client) Close() error {
+	if c.local == nil {
+		c.pool.close()
+	}
+	return nil
+}
+
+func (c *Client) Ping(ctx context.Context) error {
+	if c.local != nil {
+		return ctx.Err()
+	}
+	replies, err := c.do(ctx, [][][]byte{{[]byte("PING")}})
+	if err != nil {
+		return err
+	}
+	return replies[0].expect('+')
+}
+
+// Get returns the value of a key, false if the key doesn't exist
+func (c *Client) Get(ctx context.Context, key []byte) ([]byte, bool, error) {
+	results, err := c.Pipeline().Get(key).Exec(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	return results[0].Val, results[0].Found, results[0].Err
+}
+
+func (c *Client) Set(ctx context.Context, key []byte, val []byte) error {
+	results, err := c.Pipeline().Set(key, val).Exec(ctx)
+	if err != nil {
+		return err
+	}
+	return results[0].Err
+}
+
+// Del deletes a key, returns false if the key doesn't exist
+func (c *Client) Del(ctx context.Context, key []byte) (bool, error) {
+	results, err := c.Pipeline().Del(key).Exec(ctx)
+	if err != nil {
+		return false, err
+	}
+	return results[0].Found, results[0].Err
+}
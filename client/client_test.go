@@ -0,0 +1,341 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"killerDB/btree"
+	"killerDB/resp"
+)
+
+func openKV(t *testing.T) *btree.KV {
+	t.Helper()
+	db := &btree.KV{Path: filepath.Join(t.TempDir(), "db")}
+	if err := db.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+// a resp.Server on a loopback listener
+func startServer(t *testing.T, db *btree.KV) string {
+	t.Helper()
+	ln, err := resp.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &resp.Server{KV: db}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+func dial(t *testing.T, addr string, opts *Options) *Client {
+	t.Helper()
+	c, err := Dial(context.Background(), addr, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// run the test in the in-process mode and against a server
+func bothModes(t *testing.T, test func(t *testing.T, c *Client)) {
+	t.Run("local", func(t *testing.T) {
+		test(t, Local(openKV(t)))
+	})
+	t.Run("remote", func(t *testing.T) {
+		test(t, dial(t, startServer(t, openKV(t)), nil))
+	})
+}
+
+func TestGetSetDel(t *testing.T) {
+	bothModes(t, func(t *testing.T, c *Client) {
+		ctx := context.Background()
+		if err := c.Set(ctx, []byte("a"), []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+		if val, ok, err := c.Get(ctx, []byte("a")); err != nil || !ok || string(val) != "1" {
+			t.Fatalf("get: %q %v %v", val, ok, err)
+		}
+		if _, ok, err := c.Get(ctx, []byte("b")); err != nil || ok {
+			t.Fatalf("get missing: %v %v", ok, err)
+		}
+		if ok, err := c.Del(ctx, []byte("a")); err != nil || !ok {
+			t.Fatalf("del: %v %v", ok, err)
+		}
+		if ok, err := c.Del(ctx, []byte("a")); err != nil || ok {
+			t.Fatalf("del missing: %v %v", ok, err)
+		}
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, _, err := c.Get(canceled, []byte("a")); !errors.Is(err, context.Canceled) {
+			t.Fatalf("canceled: %v", err)
+		}
+	})
+}
+
+// the errors of the KV through ServerError.Unwrap in the remote mode
+func TestErrors(t *testing.T) {
+	bothModes(t, func(t *testing.T, c *Client) {
+		ctx := context.Background()
+		if err := c.Set(ctx, nil, []byte("1")); !errors.Is(err, btree.ErrEmptyKey) {
+			t.Fatalf("empty key: %v", err)
+		}
+		key := bytes.Repeat([]byte("k"), btree.BTREE_MAX_KEY_SIZE+1)
+		if _, _, err := c.Get(ctx, key); !errors.Is(err, btree.ErrKeyTooLarge) {
+			t.Fatalf("large key: %v", err)
+		}
+		if err := c.Set(ctx, []byte("a"), make([]byte, btree.BTREE_MAX_BLOB_SIZE+1)); !errors.Is(err, btree.ErrValueTooLarge) {
+			t.Fatalf("large value: %v", err)
+		}
+		results, err := c.Pipeline().Set([]byte("a"), []byte("1")).Del(nil).Get([]byte("a")).Exec(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if results[0].Err != nil || !errors.Is(results[1].Err, btree.ErrEmptyKey) || string(results[2].Val) != "1" {
+			t.Fatalf("pipeline: %+v", results)
+		}
+		var serverErr *ServerError
+		if c.local == nil && !errors.As(results[1].Err, &serverErr) {
+			t.Fatalf("not a ServerError: %T", results[1].Err)
+		}
+	})
+}
+
+func TestTxn(t *testing.T) {
+	bothModes(t, func(t *testing.T, c *Client) {
+		ctx := context.Background()
+		if err := c.Set(ctx, []byte("a"), []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+		results, err := c.Txn(ctx, func(tx *Txn) error {
+			tx.Get([]byte("a")).Set([]byte("b"), []byte("2")).Del([]byte("a")).Del([]byte("c")).Get([]byte("b"))
+			tx.Set(nil, []byte("3")) // fails alone
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 6 {
+			t.Fatalf("%d results", len(results))
+		}
+		if r := results[0]; string(r.Val) != "1" || !r.Found || r.Err != nil {
+			t.Fatalf("get: %+v", r)
+		}
+		if r := results[1]; r.Err != nil {
+			t.Fatalf("set: %+v", r)
+		}
+		if !results[2].Found || results[3].Found {
+			t.Fatalf("del: %+v %+v", results[2], results[3])
+		}
+		if r := results[4]; string(r.Val) != "2" || !r.Found {
+			t.Fatalf("get in the txn: %+v", r)
+		}
+		if !errors.Is(results[5].Err, btree.ErrEmptyKey) {
+			t.Fatalf("bad set: %+v", results[5])
+		}
+		if _, ok, _ := c.Get(ctx, []byte("a")); ok {
+			t.Fatal("not deleted")
+		}
+
+		// nothing is sent on errors
+		results, err = c.Txn(ctx, func(tx *Txn) error {
+			tx.Set([]byte("d"), []byte("4"))
+			return errors.New("abort")
+		})
+		if err == nil || results != nil {
+			t.Fatalf("aborted: %v %v", results, err)
+		}
+		if _, ok, _ := c.Get(ctx, []byte("d")); ok {
+			t.Fatal("aborted txn is written")
+		}
+	})
+}
+
+func scanKeys(t *testing.T, c *Client, start string, end string) []string {
+	t.Helper()
+	var endKey []byte
+	if end != "" {
+		endKey = []byte(end)
+	}
+	iter := c.Scan(context.Background(), []byte(start), endKey)
+	defer iter.Close()
+	var keys []string
+	for ; iter.Valid(); iter.Next() {
+		if string(iter.Val()) != "v"+string(iter.Key()) {
+			t.Fatalf("key %q: %q", iter.Key(), iter.Val())
+		}
+		keys = append(keys, string(iter.Key()))
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestScanBatches(t *testing.T) {
+	bothModes(t, func(t *testing.T, c *Client) {
+		ctx := context.Background()
+		n := 2*CLIENT_SCAN_BATCH + 1
+		for i := 0; i < n; i++ {
+			key := fmt.Sprintf("k%04d", i)
+			if err := c.Set(ctx, []byte(key), []byte("v"+key)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cases := []struct {
+			start, end string
+			first, n   int
+		}{
+			{"", "", 0, n},
+			{"k", "k9", 0, n},
+			// exactly one batch, then an empty one
+			{"k", fmt.Sprintf("k%04d", CLIENT_SCAN_BATCH), 0, CLIENT_SCAN_BATCH},
+			{"k0001", fmt.Sprintf("k%04d", CLIENT_SCAN_BATCH+2), 1, CLIENT_SCAN_BATCH + 1},
+			{fmt.Sprintf("k%04d", n-1), "", n - 1, 1},
+			{"l", "", 0, 0},
+		}
+		for _, tc := range cases {
+			keys := scanKeys(t, c, tc.start, tc.end)
+			if len(keys) != tc.n {
+				t.Fatalf("scan [%q, %q): %d keys, want %d", tc.start, tc.end, len(keys), tc.n)
+			}
+			for i, key := range keys {
+				if key != fmt.Sprintf("k%04d", tc.first+i) {
+					t.Fatalf("scan [%q, %q): key %d is %q", tc.start, tc.end, i, key)
+				}
+			}
+		}
+	})
+}
+
+// a TCP proxy that can drop the connections to emulate a network failure
+type dropProxy struct {
+	ln     net.Listener
+	target string
+	mu     sync.Mutex
+	conns  []net.Conn
+}
+
+func startProxy(t *testing.T, target string) *dropProxy {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &dropProxy{ln: ln, target: target}
+	go p.serve()
+	t.Cleanup(func() {
+		ln.Close()
+		p.drop()
+	})
+	return p
+}
+
+func (p *dropProxy) serve() {
+	for {
+		client, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+		server, err := net.Dial("tcp", p.target)
+		if err != nil {
+			client.Close()
+			continue
+		}
+		p.mu.Lock()
+		p.conns = append(p.conns, client, server)
+		p.mu.Unlock()
+		go io.Copy(server, client)
+		go io.Copy(client, server)
+	}
+}
+
+func (p *dropProxy) drop() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, nc := range p.conns {
+		nc.Close()
+	}
+	n := len(p.conns) / 2
+	p.conns = nil
+	return n
+}
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+	proxy := startProxy(t, startServer(t, openKV(t)))
+	addr := proxy.ln.Addr().String()
+	c := dial(t, addr, &Options{PoolSize: 2})
+	if err := c.Set(ctx, []byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	// the pooled connection is broken, the request is retried on a new one
+	if proxy.drop() == 0 {
+		t.Fatal("no connections")
+	}
+	if val, ok, err := c.Get(ctx, []byte("a")); err != nil || !ok || string(val) != "1" {
+		t.Fatalf("get after the drop: %q %v %v", val, ok, err)
+	}
+	iter := c.Scan(ctx, nil, nil)
+	proxy.drop()
+	for ; iter.Valid(); iter.Next() {
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("scan after the drop: %v", err)
+	}
+
+	// the writes reached the connection, they aren't retried
+	drop := func() {
+		if err := c.Ping(ctx); err != nil || proxy.drop() == 0 {
+			t.Fatalf("no pooled connection: %v", err)
+		}
+	}
+	drop()
+	if err := c.Set(ctx, []byte("a"), []byte("2")); err == nil {
+		t.Fatal("set retried after the drop")
+	}
+	drop()
+	if _, err := c.Txn(ctx, func(tx *Txn) error {
+		tx.Set([]byte("a"), []byte("3"))
+		return nil
+	}); err == nil {
+		t.Fatal("txn retried after the drop")
+	}
+	drop()
+	if _, err := c.Pipeline().Get([]byte("a")).Del([]byte("a")).Exec(ctx); err == nil {
+		t.Fatal("pipeline with a del retried after the drop")
+	}
+	if val, _, err := c.Get(ctx, []byte("a")); err != nil || string(val) != "1" {
+		t.Fatalf("get after the writes: %q %v", val, err)
+	}
+
+	// without retries
+	c = dial(t, addr, &Options{MaxRetries: -1})
+	proxy.drop()
+	if _, _, err := c.Get(ctx, []byte("a")); err == nil {
+		t.Fatal("no error without retries")
+	}
+	if _, _, err := c.Get(ctx, []byte("a")); err != nil {
+		t.Fatalf("get on a new connection: %v", err)
+	}
+
+	c.Close()
+	if _, _, err := c.Get(ctx, []byte("a")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("closed: %v", err)
+	}
+	proxy.ln.Close()
+	if _, err := Dial(ctx, addr, &Options{MaxRetries: -1}); err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Fatalf("dial: %v", err)
+	}
+}
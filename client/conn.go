@@ -0,0 +1,207 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// a connection to the server
+type conn struct {
+	nc net.Conn
+	r  *bufio.Reader
+}
+
+// the pool of connections
+type pool struct {
+	mu     sync.Mutex
+	closed bool
+	tokens chan struct{} // one for each open connection
+	idle   chan *conn
+}
+
+func (p *pool) init(size int) {
+	p.tokens = make(chan struct{}, size)
+	p.idle = make(chan *conn, size)
+}
+
+func (p *pool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for {
+		select {
+		case cn := <-p.idle:
+			_ = cn.nc.Close()
+			<-p.tokens
+		default:
+			return
+		}
+	}
+}
+
+// an idle connection or a new one, waits if the pool is full.
+// a retry asks for a fresh one, the idle ones are likely broken too.
+func (c *Client) acquire(ctx context.Context, fresh bool) (*conn, error) {
+	p := &c.pool
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+	if !fresh {
+		select {
+		case cn := <-p.idle:
+			return cn, nil
+		default:
+		}
+	}
+	for {
+		select {
+		case cn := <-p.idle:
+			if !fresh {
+				return cn, nil
+			}
+			c.release(cn, true) // make room for a new one
+		case p.tokens <- struct{}{}:
+			cn, err := c.dial(ctx)
+			if err != nil {
+				<-p.tokens
+			}
+			return cn, err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// return a connection to the pool, a broken one is closed
+func (c *Client) release(cn *conn, broken bool) {
+	p := &c.pool
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if broken || p.closed {
+		_ = cn.nc.Close()
+		<-p.tokens
+		return
+	}
+	p.idle <- cn // never blocks, there are as many slots as tokens
+}
+
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	network, addr := "tcp", c.addr
+	if path, ok := strings.CutPrefix(c.addr, "unix:"); ok {
+		network, addr = "unix", path
+	}
+	d := net.Dialer{Timeout: c.opts.DialTimeout}
+	nc, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{nc: nc, r: bufio.NewReader(nc)}, nil
+}
+
+// send the commands in one round trip, retrying on network errors.
+// a request that reached the connection may have been executed, so it's
+// retried only if all the commands are reads; a retried SET could
+// overwrite the write of another client and a MULTI/EXEC could be
+// applied twice.
+func (c *Client) do(ctx context.Context, cmds [][][]byte) ([]value, error) {
+	backoff := c.opts.RetryBackoff
+	reads := readOnly(cmds)
+	for attempt := 0; ; attempt++ {
+		replies, sent, err := c.try(ctx, cmds, attempt > 0)
+		if err == nil || !transient(err) || (sent && !reads) ||
+			attempt >= c.opts.MaxRetries || ctx.Err() != nil {
+			return replies, err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// the commands that can be repeated without changing the data
+func readOnly(cmds [][][]byte) bool {
+	for _, args := range cmds {
+		switch strings.ToUpper(string(args[0])) {
+		case "GET", "RANGE", "PING":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// sent is false if nothing was written to the connection
+func (c *Client) try(ctx context.Context, cmds [][][]byte, fresh bool) (replies []value, sent bool, err error) {
+	cn, err := c.acquire(ctx, fresh)
+	if err != nil {
+		return nil, false, err
+	}
+	deadline, _ := ctx.Deadline() // the zero time is no deadline
+	if err := cn.nc.SetDeadline(deadline); err != nil {
+		c.release(cn, true)
+		return nil, false, err
+	}
+	// interrupt the IO if the context is canceled
+	stop := context.AfterFunc(ctx, func() {
+		_ = cn.nc.SetDeadline(time.Unix(1, 0))
+	})
+
+	replies, sent, err = cn.roundTrip(cmds)
+	if !stop() {
+		// the deadline was changed, the connection can't be reused
+		c.release(cn, true)
+		return nil, sent, ctx.Err()
+	}
+	c.release(cn, err != nil)
+	if err != nil && ctx.Err() != nil {
+		return nil, sent, ctx.Err() // the deadline of the context
+	}
+	return replies, sent, err
+}
+
+func (cn *conn) roundTrip(cmds [][][]byte) ([]value, bool, error) {
+	buf := []byte{}
+	for _, args := range cmds {
+		buf = appendCommand(buf, args...)
+	}
+	// one write, a partial one may have sent some of the commands
+	if n, err := cn.nc.Write(buf); err != nil {
+		return nil, n > 0, err
+	}
+	replies := make([]value, len(cmds))
+	for i := range replies {
+		v, err := readReply(cn.r)
+		if err != nil {
+			return nil, true, err
+		}
+		replies[i] = v
+	}
+	return replies, true, nil
+}
+
+// a broken connection, likely closed by the server or by an idle timeout
+func transient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && !ne.Timeout()
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// the client side of the RESP protocol, see the resp package.
+
+const CLIENT_MAX_BULK = 64<<20 + 1024 // the largest value with some slack
+const CLIENT_MAX_ARRAY = 1 << 20
+
+// a decoded reply
+type value struct {
+	kind  byte // one of + - : $ *
+	str   []byte
+	int   int64
+	null  bool // the null bulk string or array
+	array []value
+}
+
+var errProtocol = errors.New("killerdb: protocol error")
+
+// a command is an array of bulk strings
+func appendCommand(buf []byte, args ...[]byte) []byte {
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, "\r\n"...)
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, "\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	return buf
+}
+
+func readReply(r *bufio.Reader) (value, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return value{}, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return value{}, errProtocol
+	}
+	v := value{kind: line[0]}
+	body := line[1 : len(line)-2]
+	switch v.kind {
+	case '+', '-':
+		v.str = append([]byte{}, body...)
+		return v, nil
+	case ':':
+		v.int, err = strconv.ParseInt(string(body), 10, 64)
+		if err != nil {
+			return value{}, errProtocol
+		}
+		return v, nil
+	}
+
+	n, err := strconv.Atoi(string(body))
+	switch {
+	case err != nil || n < -1:
+		return value{}, errProtocol
+	case n == -1:
+		v.null = true
+		return v, nil
+	case v.kind == '$' && n <= CLIENT_MAX_BULK:
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return value{}, err
+		}
+		v.str = buf[:n]
+		return v, nil
+	case v.kind == '*' && n <= CLIENT_MAX_ARRAY:
+		v.array = make([]value, n)
+		for i := range v.array {
+			if v.array[i], err = readReply(r); err != nil {
+				return value{}, err
+			}
+		}
+		return v, nil
+	default:
+		return value{}, errProtocol
+	}
+}
+
+// the error reply as an error
+func (v value) err() error {
+	if v.kind == '-' {
+		return &ServerError{Msg: string(v.str)}
+	}
+	return nil
+}
+
+// the reply of a command that is expected to succeed with the kind
+func (v value) expect(kind byte) error {
+	if err := v.err(); err != nil {
+		return err
+	}
+	if v.kind != kind {
+		return fmt.Errorf("%w: unexpected reply type %q", errProtocol, v.kind)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"killerDB/btree"
+)
+
+const CLIENT_SCAN_BATCH = 100 // key-value pairs per round trip
+
+// Iter walks the keys in a range like btree.KVIter.
+// In the remote mode the keys are fetched in batches, each one from a
+// different snapshot. In the in-process mode the iterator holds a snapshot
+// of the KV. The iterator must be closed.
+type Iter struct {
+	ctx   context.Context
+	c     *Client
+	local *btree.KVIter
+	// the remote mode
+	end  []byte
+	kvs  [][]byte // the keys and the values of the current batch
+	pos  int
+	next []byte // the start of the next batch, nil at the end
+	err  error
+}
+
+// Scan returns an iterator over the keys in [start, end),
+// a nil end scans to the last key.
+func (c *Client) Scan(ctx context.Context, start []byte, end []byte) *Iter {
+	if c.local != nil {
+		return &Iter{ctx: ctx, c: c, local: c.local.Scan(start, end)}
+	}
+	it := &Iter{ctx: ctx, c: c, end: end, next: start}
+	if it.next == nil {
+		it.next = []byte{}
+	}
+	it.fetch()
+	return it
+}
+
+// get the next batch
+func (it *Iter) fetch() {
+	it.kvs, it.pos = nil, 0
+	if it.next == nil {
+		return
+	}
+	cmd := [][]byte{[]byte("RANGE"), it.next, it.end, []byte(strconv.Itoa(CLIENT_SCAN_BATCH))}
+	replies, err := it.c.do(it.ctx, [][][]byte{cmd})
+	if err == nil {
+		err = replies[0].expect('*')
+	}
+	if err != nil {
+		it.err = err
+		return
+	}
+	for _, v := range replies[0].array {
+		if v.kind != '$' || v.null {
+			it.err = fmt.Errorf("%w: bad RANGE reply", errProtocol)
+			return
+		}
+		it.kvs = append(it.kvs, v.str)
+	}
+	it.next = nil
+	if len(it.kvs) == 2*CLIENT_SCAN_BATCH {
+		// the smallest key after the last one
+		last := it.kvs[len(it.kvs)-2]
+		it.next = append(append([]byte{}, last...), 0)
+	}
+}
+
+func (it *Iter) Valid() bool {
+	if it.local != nil {
+		return it.local.Valid()
+	}
+	return it.err == nil && it.pos < len(it.kvs)
+}
+
+func (it *Iter) Key() []byte {
+	if it.local != nil {
+		return it.local.Key()
+	}
+	return it.kvs[it.pos]
+}
+
+func (it *Iter) Val() []byte {
+	if it.local != nil {
+		return it.local.Val()
+	}
+	return it.kvs[it.pos+1]
+}
+
+func (it *Iter) Next() {
+	if it.local != nil {
+		it.local.Next()
+		return
+	}
+	it.pos += 2
+	if it.pos >= len(it.kvs) {
+		it.fetch()
+	}
+}
+
+// the error that ended the iteration early
+func (it *Iter) Err() error {
+	if it.local != nil {
+		return it.local.Err()
+	}
+	return it.err
+}
+
+func (it *Iter) Close() {
+	if it.local != nil {
+		it.local.Close()
+	}
+}
@@ -0,0 +1,56 @@
+// httpserver serves a database file over HTTP with JSON, see httpapi.
+//
+//	usage: httpserver [-addr ADDR] [-wal] FILE
+//
+// SIGINT or SIGTERM stops the server and closes the file.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"killerDB/btree"
+	"killerDB/httpapi"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8080", "host:port")
+	wal := flag.Bool("wal", false, "commit with the write-ahead log")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: httpserver [-addr ADDR] [-wal] FILE")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db := &btree.KV{Path: flag.Arg(0), WAL: *wal}
+	if err := db.Open(); err != nil {
+		log.Fatalf("open %s: %v", db.Path, err)
+	}
+	defer db.Close()
+	srv := &http.Server{Addr: *addr, Handler: &httpapi.Handler{KV: db}}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		<-stop
+		_ = srv.Shutdown(context.Background())
+		close(done)
+	}()
+	log.Printf("serving %s on %s", db.Path, *addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Printf("serve: %v", err)
+		return
+	}
+	<-done
+}
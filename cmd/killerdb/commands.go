@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"killerDB/btree"
+)
+
+const LOAD_BATCH = 1000 // keys per transaction
+
+func cmdGet(opts *options, db *btree.KV, path string, args []string) error {
+	key, err := opts.enc.decode(args[0])
+	if err != nil {
+		return fmt.Errorf("key: %w", err)
+	}
+	val, ok, err := db.Get(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errNotFound
+	}
+	fmt.Println(opts.enc.encode(val))
+	return nil
+}
+
+func cmdSet(opts *options, db *btree.KV, path string, args []string) error {
+	key, err := opts.enc.decode(args[0])
+	if err != nil {
+		return fmt.Errorf("key: %w", err)
+	}
+	val, err := opts.enc.decode(args[1])
+	if err != nil {
+		return fmt.Errorf("value: %w", err)
+	}
+	return db.Set(key, val)
+}
+
+// all the keys in a transaction, not found if none is deleted
+func cmdDel(opts *options, db *btree.KV, path string, args []string) error {
+	keys := [][]byte{}
+	for _, arg := range args {
+		key, err := opts.enc.decode(arg)
+		if err != nil {
+			return fmt.Errorf("key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	tx := db.Begin()
+	n := 0
+	for _, key := range keys {
+		deleted, err := tx.Del(key)
+		if err != nil {
+			tx.Abort()
+			return err
+		}
+		if deleted {
+			n++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if n == 0 {
+		return errNotFound
+	}
+	return nil
+}
+
+func cmdScan(opts *options, db *btree.KV, path string, args []string) error {
+	var start, end []byte
+	var err error
+	if len(args) > 0 {
+		if start, err = opts.enc.decode(args[0]); err != nil {
+			return fmt.Errorf("start: %w", err)
+		}
+	}
+	if len(args) > 1 {
+		if end, err = opts.enc.decode(args[1]); err != nil {
+			return fmt.Errorf("end: %w", err)
+		}
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	_, err = printRange(w, opts.enc, db.Scan(start, end), opts.limit)
+	return err
+}
+
+// print the pairs with quoting, returns the number of pairs printed.
+// a limit of 0 prints all of them.
+func printRange(w io.Writer, enc encoding, iter *btree.KVIter, limit int) (int, error) {
+	defer iter.Close()
+	n := 0
+	for ; iter.Valid() && (limit <= 0 || n < limit); iter.Next() {
+		if _, err := fmt.Fprintf(w, "%s %s\n", enc.quote(iter.Key()), enc.quote(iter.Val())); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, iter.Err()
+}
+
+// all the pairs, in the format read by load
+func cmdDump(opts *options, db *btree.KV, path string, args []string) error {
+	w := bufio.NewWriter(os.Stdout)
+	if _, err := printRange(w, opts.enc, db.Scan(nil, nil), 0); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// the lines of dump, committed in batches
+func cmdLoad(opts *options, db *btree.KV, path string, args []string) error {
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(nil, 4*btree.BTREE_MAX_BLOB_SIZE+1024) // a quoted value
+	if opts.sorted {
+		return bulkLoad(opts, db, scanner)
+	}
+	tx := db.Begin()
+	n, lineno := 0, 0
+	for scanner.Scan() {
+		lineno++
+		key, val, err := parsePair(opts.enc, scanner.Text())
+		if err == nil {
+			err = tx.Set(key, val)
+		}
+		if err != nil {
+			tx.Abort()
+			return fmt.Errorf("line %d: %w", lineno, err)
+		}
+		if n++; n%LOAD_BATCH == 0 {
+			if err := tx.Commit(); err != nil {
+				return err
+			}
+			tx = db.Begin()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		tx.Abort()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "loaded %d keys\n", n)
+	return nil
+}
+
+// the sorted lines in a single commit
+func bulkLoad(opts *options, db *btree.KV, scanner *bufio.Scanner) error {
+	tx := db.Begin()
+	bl, err := tx.BulkLoad(opts.fill)
+	if err != nil {
+		tx.Abort()
+		return err
+	}
+	n := 0
+	for scanner.Scan() {
+		key, val, err := parsePair(opts.enc, scanner.Text())
+		if err == nil {
+			err = bl.Add(key, val)
+		}
+		if err != nil {
+			tx.Abort()
+			return fmt.Errorf("line %d: %w", n+1, err)
+		}
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		tx.Abort()
+		return err
+	}
+	if err := bl.Finish(); err != nil {
+		tx.Abort()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "loaded %d keys\n", n)
+	return nil
+}
+
+func parsePair(enc encoding, line string) ([]byte, []byte, error) {
+	words, err := splitWords(line)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(words) != 2 {
+		return nil, nil, fmt.Errorf("expected a key and a value, got %d words", len(words))
+	}
+	key, err := enc.decode(words[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("key: %w", err)
+	}
+	val, err := enc.decode(words[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("value: %w", err)
+	}
+	return key, val, nil
+}
+
+// the shape of the file, from a verification without the problems
+func cmdStats(opts *options, db *btree.KV, path string, args []string) error {
+	r, err := btree.Verify(path)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("file:      %s, %d bytes\n", path, fi.Size())
+	fmt.Printf("pages:     %d in use, %d bytes each\n", r.Pages, btree.BTREE_PAGE_SIZE)
+	fmt.Printf("keys:      %d\n", r.Keys)
+	fmt.Printf("tree:      depth %d, %d internal nodes, %d leaves\n", r.Depth, r.Nodes, r.Leaves)
+	if r.OldNodes > 0 {
+		fmt.Printf("format:    %d nodes in an older format, run migrate\n", r.OldNodes)
+	}
+	fmt.Printf("overflow:  %d pages\n", r.Overflows)
+	fmt.Printf("free list: %d nodes, %d free pages\n", r.FreeNodes, r.FreeItems)
+	if !r.OK() {
+		fmt.Println("the file has problems, run verify for details")
+	}
+	return nil
+}
+
+func cmdVerify(opts *options, db *btree.KV, path string, args []string) error {
+	r, err := btree.Verify(path)
+	if err != nil {
+		return err
+	}
+	r.Print(os.Stdout)
+	if !r.OK() {
+		return errProblems
+	}
+	return nil
+}
+
+// the file is usable during the migration, the nodes are rewritten in
+// batches of transactions
+func cmdMigrate(opts *options, db *btree.KV, path string, args []string) error {
+	n, err := db.Migrate()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "migrated %d nodes\n", n)
+	return nil
+}
+
+// the file is rewritten in the current format, it must not be open
+func cmdUpgrade(opts *options, db *btree.KV, path string, args []string) error {
+	n, err := btree.Upgrade(path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "upgraded %s: %d keys\n", path, n)
+	return nil
+}
+
+// the file is rewritten without the free pages
+func cmdCompact(opts *options, db *btree.KV, path string, args []string) error {
+	before, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := db.Compact(); err != nil {
+		return err
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "compacted %s: %d -> %d bytes\n", path, before.Size(), after.Size())
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// how the keys and values are written on the command line and printed
+type encoding int
+
+const (
+	ENC_TEXT   = 0 // as is, quoted when printed if needed
+	ENC_HEX    = 1
+	ENC_BASE64 = 2
+)
+
+func parseEncoding(s string) (encoding, error) {
+	switch s {
+	case "text":
+		return ENC_TEXT, nil
+	case "hex":
+		return ENC_HEX, nil
+	case "base64":
+		return ENC_BASE64, nil
+	default:
+		return 0, fmt.Errorf("unknown encoding %q, must be text, hex or base64", s)
+	}
+}
+
+func (enc encoding) decode(s string) ([]byte, error) {
+	switch enc {
+	case ENC_HEX:
+		return hex.DecodeString(s)
+	case ENC_BASE64:
+		return base64.StdEncoding.DecodeString(s)
+	default:
+		return []byte(s), nil
+	}
+}
+
+// the raw text, used where the output is a single value
+func (enc encoding) encode(b []byte) string {
+	switch enc {
+	case ENC_HEX:
+		return hex.EncodeToString(b)
+	case ENC_BASE64:
+		return base64.StdEncoding.EncodeToString(b)
+	default:
+		return string(b)
+	}
+}
+
+// a single word that can be split and parsed back by splitWords
+func (enc encoding) quote(b []byte) string {
+	if enc != ENC_TEXT {
+		return enc.encode(b)
+	}
+	if len(b) > 0 && utf8.Valid(b) && strings.IndexFunc(string(b), needQuote) < 0 {
+		return string(b)
+	}
+	return strconv.Quote(string(b))
+}
+
+func needQuote(r rune) bool {
+	return !unicode.IsPrint(r) || unicode.IsSpace(r) || r == '"' || r == '\'' || r == '\\'
+}
+
+// split a line into words. a word is bare, or "double quoted" with the
+// escapes of Go strings, or 'single quoted' without escapes.
+func splitWords(line string) ([]string, error) {
+	words := []string{}
+	for {
+		line = strings.TrimLeftFunc(line, unicode.IsSpace)
+		if line == "" {
+			return words, nil
+		}
+		var word string
+		switch line[0] {
+		case '"':
+			end := 1
+			for ; end < len(line) && line[end] != '"'; end++ {
+				if line[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(line) {
+				return nil, errors.New("unterminated double quote")
+			}
+			s, err := strconv.Unquote(line[:end+1])
+			if err != nil {
+				return nil, fmt.Errorf("bad quoted string %s", line[:end+1])
+			}
+			word, line = s, line[end+1:]
+		case '\'':
+			end := strings.IndexByte(line[1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote")
+			}
+			word, line = line[1:end+1], line[end+2:]
+		default:
+			end := strings.IndexFunc(line, unicode.IsSpace)
+			if end < 0 {
+				end = len(line)
+			}
+			word, line = line[:end], line[end:]
+		}
+		if line != "" && !unicode.IsSpace(rune(line[0])) {
+			return nil, errors.New("missing space after a quoted string")
+		}
+		words = append(words, word)
+	}
+}
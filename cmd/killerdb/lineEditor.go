@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	HISTORY_MAX  = 1000 // lines kept in memory and loaded from the file
+	HISTORY_FILE = ".killerdb_history"
+)
+
+// Ctrl-C, the line is dropped
+var errInterrupt = errors.New("interrupted")
+
+// a minimal line editor for a terminal. it assumes each rune takes one
+// column and the line fits in the terminal width.
+//
+//	Left/Right, Ctrl-B/F  move       Home/End, Ctrl-A/E  start/end
+//	Up/Down, Ctrl-P/N     history    Tab                 complete
+//	Backspace, Delete     delete     Ctrl-U/K/W          delete before/after/word
+//	Ctrl-C                cancel     Ctrl-D              exit on an empty line
+type lineEditor struct {
+	in      *os.File
+	r       *bufio.Reader
+	out     io.Writer
+	history []string
+	hfile   *os.File // new lines are appended, nil if there is no file
+	// candidates to replace word, the text after head
+	complete func(head string, word string) []string
+}
+
+func newLineEditor(in *os.File, out io.Writer) *lineEditor {
+	return &lineEditor{in: in, r: bufio.NewReader(in), out: out}
+}
+
+// the path from $KILLERDB_HISTORY or in the home directory
+func historyPath() string {
+	if path := os.Getenv("KILLERDB_HISTORY"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return home + "/" + HISTORY_FILE
+}
+
+// load the last lines of the file and append to it from now on.
+// a file with more lines is rewritten with the lines kept.
+func (e *lineEditor) openHistory(path string) error {
+	fp, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fp.Close()
+		return err
+	}
+	if len(e.history) > HISTORY_MAX {
+		e.history = append([]string(nil), e.history[len(e.history)-HISTORY_MAX:]...)
+		fp.Close()
+		if fp, err = rewriteHistory(path, e.history); err != nil {
+			return err
+		}
+	}
+	e.hfile = fp
+	return nil
+}
+
+// replace the file with the lines via a rename, returns it opened for appending
+func rewriteHistory(path string, lines []string) (*os.File, error) {
+	tmp := path + ".tmp"
+	data := strings.Join(lines, "\n") + "\n"
+	if err := os.WriteFile(tmp, []byte(data), 0o600); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0o600)
+}
+
+func (e *lineEditor) close() {
+	if e.hfile != nil {
+		e.hfile.Close()
+	}
+}
+
+func (e *lineEditor) addHistory(line string) {
+	line = strings.TrimRightFunc(line, unicode.IsSpace)
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > HISTORY_MAX {
+		e.history = e.history[1:]
+	}
+	if e.hfile != nil {
+		_, _ = e.hfile.WriteString(line + "\n")
+	}
+}
+
+// read a line in the raw mode. returns io.EOF on Ctrl-D and
+// errInterrupt on Ctrl-C.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	restore, err := makeRaw(e.in.Fd())
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+
+	buf, pos := []rune{}, 0
+	hist, saved := len(e.history), ""
+	for {
+		e.redraw(prompt, buf, pos)
+		r, _, err := e.r.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\n")
+			line := string(buf)
+			e.addHistory(line)
+			return line, nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\n")
+			return "", errInterrupt
+		case 4: // Ctrl-D
+			if len(buf) == 0 {
+				fmt.Fprint(e.out, "\n")
+				return "", io.EOF
+			}
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+		case 127, 8: // Backspace
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case 1: // Ctrl-A
+			pos = 0
+		case 5: // Ctrl-E
+			pos = len(buf)
+		case 2: // Ctrl-B
+			pos = max(pos-1, 0)
+		case 6: // Ctrl-F
+			pos = min(pos+1, len(buf))
+		case 11: // Ctrl-K
+			buf = buf[:pos]
+		case 21: // Ctrl-U
+			buf, pos = append([]rune{}, buf[pos:]...), 0
+		case 23: // Ctrl-W
+			start := wordStart(buf, pos)
+			buf, pos = append(buf[:start], buf[pos:]...), start
+		case 16, 14: // Ctrl-P, Ctrl-N
+			buf, pos, hist, saved = e.browse(r == 16, buf, hist, saved)
+		case '\t':
+			buf, pos = e.tab(buf, pos)
+		case 27: // escape sequences
+			switch e.escape() {
+			case 'A':
+				buf, pos, hist, saved = e.browse(true, buf, hist, saved)
+			case 'B':
+				buf, pos, hist, saved = e.browse(false, buf, hist, saved)
+			case 'C':
+				pos = min(pos+1, len(buf))
+			case 'D':
+				pos = max(pos-1, 0)
+			case 'H':
+				pos = 0
+			case 'F':
+				pos = len(buf)
+			case 'X': // Delete
+				if pos < len(buf) {
+					buf = append(buf[:pos], buf[pos+1:]...)
+				}
+			}
+		default:
+			if unicode.IsPrint(r) {
+				buf = append(buf[:pos], append([]rune{r}, buf[pos:]...)...)
+				pos++
+			}
+		}
+	}
+}
+
+// the key of an escape sequence after ESC: the arrows A, B, C and D,
+// H for Home, F for End and X for Delete. 0 for the others.
+func (e *lineEditor) escape() byte {
+	b, err := e.r.ReadByte()
+	if err != nil || (b != '[' && b != 'O') {
+		return 0
+	}
+	// parameters, then the final byte
+	param := []byte{}
+	for {
+		c, err := e.r.ReadByte()
+		if err != nil {
+			return 0
+		}
+		if c >= 0x40 && c <= 0x7e {
+			if c != '~' {
+				return c
+			}
+			switch string(param) {
+			case "1", "7":
+				return 'H'
+			case "4", "8":
+				return 'F'
+			case "3":
+				return 'X'
+			}
+			return 0
+		}
+		param = append(param, c)
+	}
+}
+
+// move in the history, the edited line is saved when leaving the bottom
+func (e *lineEditor) browse(up bool, buf []rune, hist int, saved string) ([]rune, int, int, string) {
+	switch {
+	case up && hist > 0:
+		if hist == len(e.history) {
+			saved = string(buf)
+		}
+		hist--
+		buf = []rune(e.history[hist])
+	case !up && hist < len(e.history):
+		hist++
+		if hist == len(e.history) {
+			buf = []rune(saved)
+		} else {
+			buf = []rune(e.history[hist])
+		}
+	}
+	return buf, len(buf), hist, saved
+}
+
+// complete the word before the cursor, a unique candidate is followed by a
+// space. several candidates are completed to their common prefix, or
+// listed if there is nothing to add.
+func (e *lineEditor) tab(buf []rune, pos int) ([]rune, int) {
+	if e.complete == nil {
+		return buf, pos
+	}
+	start := pos
+	if pos > 0 && !unicode.IsSpace(buf[pos-1]) {
+		start = wordStart(buf, pos)
+	}
+	word := string(buf[start:pos])
+	cands := e.complete(string(buf[:start]), word)
+	if len(cands) == 0 {
+		return buf, pos
+	}
+	repl := commonPrefix(cands)
+	if len(cands) == 1 {
+		repl += " "
+	}
+	if repl == word && len(cands) > 1 {
+		fmt.Fprintf(e.out, "\n%s\n", strings.Join(cands, "  "))
+		return buf, pos
+	}
+	if !strings.HasPrefix(repl, word) {
+		return buf, pos
+	}
+	ins := []rune(repl)
+	buf = append(buf[:start], append(ins, buf[pos:]...)...)
+	return buf, start + len(ins)
+}
+
+func (e *lineEditor) redraw(prompt string, buf []rune, pos int) {
+	s := "\r" + prompt + string(buf) + "\x1b[K"
+	if back := len(buf) - pos; back > 0 {
+		s += fmt.Sprintf("\x1b[%dD", back)
+	}
+	fmt.Fprint(e.out, s)
+}
+
+// the start of the word ending at pos, skipping the spaces before pos
+func wordStart(buf []rune, pos int) int {
+	i := pos
+	for i > 0 && unicode.IsSpace(buf[i-1]) {
+		i--
+	}
+	for i > 0 && !unicode.IsSpace(buf[i-1]) {
+		i--
+	}
+	return i
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, w := range words[1:] {
+		n := 0
+		for n < len(prefix) && n < len(w) && prefix[n] == w[n] {
+			n++
+		}
+		for n > 0 && n < len(prefix) && !utf8.RuneStart(prefix[n]) {
+			n--
+		}
+		prefix = prefix[:n]
+	}
+	return prefix
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestHistoryTrim(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	var lines []string
+	for i := 0; i < HISTORY_MAX+500; i++ {
+		lines = append(lines, fmt.Sprintf("get k%d", i))
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &lineEditor{}
+	if err := e.openHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	e.addHistory("scan")
+	e.close()
+	if len(e.history) != HISTORY_MAX || e.history[HISTORY_MAX-1] != "scan" {
+		t.Fatalf("%d lines in memory", len(e.history))
+	}
+	got := readLines(t, path)
+	if len(got) != HISTORY_MAX+1 || got[0] != lines[500] || got[HISTORY_MAX] != "scan" {
+		t.Fatalf("%d lines in the file, the first %q", len(got), got[0])
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Fatalf("mode: %v", err)
+	}
+
+	// the line added in the session is trimmed by the next open
+	e = &lineEditor{}
+	if err := e.openHistory(path); err != nil {
+		t.Fatal(err)
+	}
+	e.close()
+	if got := readLines(t, path); len(got) != HISTORY_MAX {
+		t.Fatalf("%d lines in the file", len(got))
+	}
+}
@@ -0,0 +1,167 @@
+// killerdb operates on a database file.
+//
+//	usage: killerdb COMMAND [-enc ENC] [-wal] FILE [ARGS]
+//
+// The commands are:
+//
+//	get FILE KEY                  print the value of a key
+//	set FILE KEY VALUE            set a key
+//	del FILE KEY...               delete keys
+//	scan [-limit N] FILE [START [END]]
+//	                              print the keys in [START, END) and their values
+//	dump FILE                     print all the keys and values, one pair per line
+//	load [-sorted [-fill F]] FILE add the pairs printed by dump from the stdin
+//	stats FILE                    print the size of the tree and the free list
+//	verify FILE                   check the file, see btree.Verify
+//	migrate FILE                  rewrite the nodes of the older formats
+//	compact FILE                  shrink the file to the pages in use
+//	upgrade FILE                  convert a file written before the page checksums
+//	shell FILE                    run the commands typed in the terminal
+//
+// The shell has a line editor with the history saved in ~/.killerdb_history
+// (or $KILLERDB_HISTORY), tab completion and transactions, type help for
+// the commands. When the stdin is not a terminal it runs the commands read
+// from it.
+//
+// With -sorted, load builds a new tree bottom-up in a single commit, see
+// btree.BulkLoader. The keys must be in ascending byte order like the
+// output of dump. Other input can be sorted first in the hex encoding:
+//
+//	LC_ALL=C sort -k1,1 pairs.hex | killerdb load -sorted -enc hex FILE
+//
+// ENC is the encoding of the keys and values in the arguments and the
+// output: text (the default), hex or base64. In the text encoding the keys
+// and values with spaces or special bytes are quoted like Go strings when
+// printed in pairs, and the shell accepts quoted words.
+//
+// Only set, load and shell create FILE if it doesn't exist, the other
+// commands fail with "no such database".
+//
+// The exit code is 0 on success, 1 if a key is not found or the file has
+// problems, and 2 on other errors.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"killerDB/btree"
+)
+
+// the options of all the commands
+type options struct {
+	enc    encoding
+	wal    bool
+	limit  int     // scan
+	sorted bool    // load
+	fill   float64 // load -sorted
+}
+
+type command struct {
+	args     string // the usage after FILE
+	min, max int    // the number of arguments after FILE, max -1 for no limit
+	open     bool   // open the file as a KV, otherwise it's only read
+	create   bool   // create the file if it doesn't exist
+	run      func(opts *options, db *btree.KV, path string, args []string) error
+}
+
+var commands = map[string]*command{
+	"get":     {args: "KEY", min: 1, max: 1, open: true, run: cmdGet},
+	"set":     {args: "KEY VALUE", min: 2, max: 2, open: true, create: true, run: cmdSet},
+	"del":     {args: "KEY...", min: 1, max: -1, open: true, run: cmdDel},
+	"scan":    {args: "[START [END]]", min: 0, max: 2, open: true, run: cmdScan},
+	"dump":    {open: true, run: cmdDump},
+	"load":    {open: true, create: true, run: cmdLoad},
+	"stats":   {run: cmdStats},
+	"verify":  {run: cmdVerify},
+	"migrate": {open: true, run: cmdMigrate},
+	"compact": {open: true, run: cmdCompact},
+	"upgrade": {run: cmdUpgrade},
+	"shell":   {open: true, create: true, run: cmdShell},
+}
+
+// exit with 1 instead of 2
+var errNotFound = errors.New("not found")
+var errProblems = errors.New("problems found")
+
+var errNoDatabase = errors.New("no such database")
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: killerdb COMMAND [-enc text|hex|base64] [-wal] FILE [ARGS]")
+	fmt.Fprintln(os.Stderr, "commands: get, set, del, scan, dump, load, stats, verify, migrate, compact, upgrade, shell")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	name := os.Args[1]
+	cmd := commands[name]
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "killerdb: unknown command %q\n", name)
+		usage()
+	}
+
+	opts := &options{}
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	enc := flags.String("enc", "text", "the encoding of the keys and values: text, hex or base64")
+	flags.BoolVar(&opts.wal, "wal", false, "commit with the write-ahead log")
+	if name == "scan" {
+		flags.IntVar(&opts.limit, "limit", 0, "print at most N keys, 0 for no limit")
+	}
+	if name == "load" {
+		flags.BoolVar(&opts.sorted, "sorted", false, "bulk load the keys in ascending order in one commit")
+		flags.Float64Var(&opts.fill, "fill", btree.BULK_FILL, "the fill factor of the pages with -sorted, in (0, 1]")
+	}
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: killerdb %s [flags] FILE %s\n", name, cmd.args)
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(os.Args[2:])
+	args := flags.Args()
+	if len(args) < 1+cmd.min || cmd.max >= 0 && len(args) > 1+cmd.max {
+		flags.Usage()
+		os.Exit(2)
+	}
+	var err error
+	if opts.enc, err = parseEncoding(*enc); err != nil {
+		fmt.Fprintln(os.Stderr, "killerdb:", err)
+		os.Exit(2)
+	}
+
+	path := args[0]
+	// btree.KV.Open creates the file, a typo would make a new database
+	if !cmd.create {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "killerdb: %s: %v\n", path, errNoDatabase)
+			os.Exit(2)
+		}
+	}
+	var db *btree.KV
+	if cmd.open {
+		db = &btree.KV{Path: path, WAL: opts.wal}
+		if err := db.Open(); err != nil {
+			fmt.Fprintf(os.Stderr, "killerdb: open %s: %v\n", path, err)
+			if errors.Is(err, btree.ErrOldFormat) {
+				fmt.Fprintf(os.Stderr, "killerdb: run killerdb upgrade %s first\n", path)
+			}
+			os.Exit(2)
+		}
+	}
+	err = cmd.run(opts, db, path, args[1:])
+	if db != nil {
+		db.Close()
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, errNotFound), errors.Is(err, errProblems):
+		fmt.Fprintln(os.Stderr, "killerdb:", err)
+		os.Exit(1)
+	default:
+		fmt.Fprintln(os.Stderr, "killerdb:", err)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// the test binary runs main with the arguments after -- when this is set
+const TEST_MAIN_ENV = "KILLERDB_TEST_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(TEST_MAIN_ENV) != "" {
+		for i, arg := range os.Args {
+			if arg == "--" {
+				os.Args = append([]string{"killerdb"}, os.Args[i+1:]...)
+				break
+			}
+		}
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// run killerdb in a new process, returns the stdout, the stderr and the exit code
+func killerdb(t *testing.T, stdin string, args ...string) (string, string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=XXX", "--"}, args...)...)
+	cmd.Env = append(os.Environ(), TEST_MAIN_ENV+"=1")
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return stdout.String(), stderr.String(), exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return stdout.String(), stderr.String(), 0
+}
+
+func TestNoSuchDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "typo.db")
+	cases := [][]string{
+		{"get", path, "k"},
+		{"del", path, "k"},
+		{"scan", path},
+		{"dump", path},
+		{"stats", path},
+		{"verify", path},
+		{"migrate", path},
+		{"compact", path},
+		{"upgrade", path},
+	}
+	for _, args := range cases {
+		_, stderr, code := killerdb(t, "", args...)
+		if code != 2 || !strings.Contains(stderr, "no such database") {
+			t.Fatalf("%s: exit %d, %q", args[0], code, stderr)
+		}
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("%s: the file is created", args[0])
+		}
+	}
+
+	// the commands that create the file
+	if _, stderr, code := killerdb(t, "", "set", path, "k", "v"); code != 0 {
+		t.Fatalf("set: exit %d, %q", code, stderr)
+	}
+	if stdout, stderr, code := killerdb(t, "", "get", path, "k"); code != 0 || stdout != "v\n" {
+		t.Fatalf("get: exit %d, %q %q", code, stdout, stderr)
+	}
+	for _, name := range []string{"load", "shell"} {
+		path := filepath.Join(t.TempDir(), name+".db")
+		if _, stderr, code := killerdb(t, "", name, path); code != 0 {
+			t.Fatalf("%s: exit %d, %q", name, code, stderr)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+}
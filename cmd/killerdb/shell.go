@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"killerDB/btree"
+)
+
+const SHELL_HELP = `commands:
+  get KEY               print the value
+  set KEY VALUE         set a key
+  del KEY...            delete keys
+  scan [START [END]]    print the keys in [START, END), a page at a time
+  next                  print the next page of the last scan
+  begin                 start a transaction
+  commit                commit the transaction
+  rollback              drop the updates of the transaction
+  help                  print this
+  exit                  or Ctrl-D, an open transaction is rolled back
+meta-commands:
+  .timing on|off        print the time taken by each command
+  .mode text|json       the output format
+  .enc text|hex|base64  the encoding of the keys and values
+  .page N               the number of keys printed by scan and next
+words with spaces are "double quoted" with Go escapes like \n and \xff,
+or 'single quoted' as is.
+`
+
+const (
+	SHELL_PAGE         = 20  // keys per page of scan
+	SHELL_COMPLETE_MAX = 100 // keys listed by the tab completion
+)
+
+var shellCommands = []string{
+	"get", "set", "del", "scan", "next", "begin", "commit", "rollback", "help", "exit", "quit",
+}
+
+// the meta-commands and the completion of their argument
+var metaCommands = map[string][]string{
+	".timing": {"on", "off"},
+	".mode":   {"text", "json"},
+	".enc":    {"text", "hex", "base64"},
+	".page":   nil,
+	".help":   nil,
+}
+
+// the operations shared by KV and KVTX
+type shellKV interface {
+	Get(key []byte) ([]byte, bool, error)
+	Set(key []byte, val []byte) error
+	Del(key []byte) (bool, error)
+	Scan(start []byte, end []byte) *btree.KVIter
+}
+
+type shell struct {
+	db     *btree.KV
+	tx     *btree.KVTX // between begin and commit or rollback
+	enc    encoding
+	json   bool
+	timing bool
+	page   int
+	more   *scanPage // the rest of the last scan
+	out    io.Writer
+	errOut io.Writer
+}
+
+type scanPage struct {
+	start []byte
+	end   []byte
+}
+
+// the line editor on a terminal, otherwise the commands are read from the
+// stdin without a prompt.
+func cmdShell(opts *options, db *btree.KV, path string, args []string) error {
+	sh := &shell{db: db, enc: opts.enc, page: SHELL_PAGE, out: os.Stdout, errOut: os.Stderr}
+	defer sh.exit()
+	if !isTerminal(os.Stdin.Fd()) {
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Buffer(nil, 4*btree.BTREE_MAX_BLOB_SIZE+1024)
+		for scanner.Scan() {
+			if sh.exec(scanner.Text()) {
+				break
+			}
+		}
+		return scanner.Err()
+	}
+
+	ed := newLineEditor(os.Stdin, os.Stdout)
+	ed.complete = sh.complete
+	if hpath := historyPath(); hpath != "" {
+		if err := ed.openHistory(hpath); err != nil {
+			fmt.Fprintln(sh.errOut, "killerdb: history:", err)
+		}
+	}
+	defer ed.close()
+	for {
+		line, err := ed.readLine(sh.prompt())
+		switch {
+		case err == errInterrupt:
+			continue
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return err
+		}
+		if sh.exec(line) {
+			return nil
+		}
+	}
+}
+
+func (sh *shell) prompt() string {
+	if sh.tx != nil {
+		return "killerdb tx> "
+	}
+	return "killerdb> "
+}
+
+func (sh *shell) kv() shellKV {
+	if sh.tx != nil {
+		return sh.tx
+	}
+	return sh.db
+}
+
+// an open transaction is not committed
+func (sh *shell) exit() {
+	if sh.tx != nil {
+		sh.tx.Abort()
+		sh.tx = nil
+		fmt.Fprintln(sh.errOut, "(the transaction is rolled back)")
+	}
+}
+
+// run a line, returns true to exit. the errors are printed.
+func (sh *shell) exec(line string) bool {
+	words, err := splitWords(line)
+	if err != nil {
+		fmt.Fprintln(sh.errOut, "error:", err)
+		return false
+	}
+	if len(words) == 0 {
+		return false
+	}
+	name, args := strings.ToLower(words[0]), words[1:]
+	if name == "exit" || name == "quit" {
+		return true
+	}
+	if strings.HasPrefix(name, ".") {
+		if err := sh.meta(name, args); err != nil {
+			fmt.Fprintln(sh.errOut, "error:", err)
+		}
+		return false
+	}
+	t0 := time.Now()
+	if err := sh.run(name, args); err != nil {
+		fmt.Fprintln(sh.errOut, "error:", err)
+	}
+	if sh.timing {
+		fmt.Fprintf(sh.out, "time: %v\n", time.Since(t0).Round(time.Microsecond))
+	}
+	return false
+}
+
+func (sh *shell) meta(name string, args []string) error {
+	if _, ok := metaCommands[name]; !ok {
+		return fmt.Errorf("unknown meta-command %q, see help", name)
+	}
+	arg := ""
+	if len(args) == 1 {
+		arg = args[0]
+	}
+	switch {
+	case name == ".help" && len(args) == 0:
+		fmt.Fprint(sh.out, SHELL_HELP)
+	case name == ".timing" && (arg == "on" || arg == "off"):
+		sh.timing = arg == "on"
+	case name == ".mode" && (arg == "text" || arg == "json"):
+		sh.json = arg == "json"
+	case name == ".enc" && len(args) == 1:
+		enc, err := parseEncoding(arg)
+		if err != nil {
+			return err
+		}
+		sh.enc = enc
+	case name == ".page" && len(args) == 1:
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 {
+			return errors.New("the page size must be a positive number")
+		}
+		sh.page = n
+	default:
+		if opts := metaCommands[name]; opts != nil {
+			return fmt.Errorf("usage: %s %s", name, strings.Join(opts, "|"))
+		}
+		return fmt.Errorf("wrong arguments for %s, see help", name)
+	}
+	return nil
+}
+
+func (sh *shell) decode(args []string) ([][]byte, error) {
+	out := make([][]byte, len(args))
+	for i, arg := range args {
+		b, err := sh.enc.decode(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", arg, err)
+		}
+		out[i] = b
+	}
+	return out, nil
+}
+
+func (sh *shell) run(name string, args []string) error {
+	bargs, err := sh.decode(args)
+	if err != nil {
+		return err
+	}
+	switch {
+	case name == "help" && len(args) == 0:
+		fmt.Fprint(sh.out, SHELL_HELP)
+	case name == "get" && len(args) == 1:
+		val, ok, err := sh.kv().Get(bargs[0])
+		if err != nil {
+			return err
+		}
+		sh.printValue(val, ok)
+	case name == "set" && len(args) == 2:
+		return sh.kv().Set(bargs[0], bargs[1])
+	case name == "del" && len(args) >= 1:
+		n := 0
+		for _, key := range bargs {
+			deleted, err := sh.kv().Del(key)
+			if err != nil {
+				return err
+			}
+			if deleted {
+				n++
+			}
+		}
+		sh.printDeleted(n)
+	case name == "scan" && len(args) <= 2:
+		var start, end []byte
+		if len(bargs) > 0 {
+			start = bargs[0]
+		}
+		if len(bargs) > 1 {
+			end = bargs[1]
+		}
+		return sh.scan(start, end)
+	case name == "next" && len(args) == 0:
+		if sh.more == nil {
+			return errors.New("no more keys")
+		}
+		return sh.scan(sh.more.start, sh.more.end)
+	case name == "begin" && len(args) == 0:
+		if sh.tx != nil {
+			return errors.New("already in a transaction")
+		}
+		sh.tx = sh.db.Begin()
+	case (name == "commit" || name == "rollback") && len(args) == 0:
+		if sh.tx == nil {
+			return errors.New("not in a transaction")
+		}
+		tx := sh.tx
+		sh.tx = nil
+		if name == "rollback" {
+			tx.Abort()
+			return nil
+		}
+		return tx.Commit()
+	case name == "get" || name == "set" || name == "del" || name == "scan" || name == "next" ||
+		name == "begin" || name == "commit" || name == "rollback" || name == "help":
+		return fmt.Errorf("wrong number of arguments for %s, see help", name)
+	default:
+		return fmt.Errorf("unknown command %q, see help", name)
+	}
+	return nil
+}
+
+// print a page, the key after it is kept for next
+func (sh *shell) scan(start []byte, end []byte) error {
+	iter := sh.kv().Scan(start, end)
+	defer iter.Close()
+	// copied, the iterator of a transaction is invalidated by updates
+	pairs := [][2][]byte{}
+	for ; iter.Valid() && len(pairs) < sh.page; iter.Next() {
+		key := append([]byte(nil), iter.Key()...)
+		val := append([]byte(nil), iter.Val()...)
+		pairs = append(pairs, [2][]byte{key, val})
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	sh.more = nil
+	if iter.Valid() {
+		sh.more = &scanPage{start: append([]byte(nil), iter.Key()...), end: end}
+	}
+	sh.printPairs(pairs, sh.more != nil)
+	return nil
+}
+
+// the output of the text and json modes
+
+type jsonPair struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func (sh *shell) printJSON(v interface{}) {
+	enc := json.NewEncoder(sh.out)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(v)
+}
+
+func (sh *shell) printValue(val []byte, ok bool) {
+	switch {
+	case sh.json && ok:
+		sh.printJSON(sh.enc.encode(val))
+	case sh.json:
+		sh.printJSON(nil)
+	case ok:
+		fmt.Fprintln(sh.out, sh.enc.quote(val))
+	default:
+		fmt.Fprintln(sh.out, "(not found)")
+	}
+}
+
+func (sh *shell) printDeleted(n int) {
+	if sh.json {
+		sh.printJSON(map[string]int{"deleted": n})
+	} else {
+		fmt.Fprintf(sh.out, "(%d deleted)\n", n)
+	}
+}
+
+func (sh *shell) printPairs(pairs [][2][]byte, more bool) {
+	if sh.json {
+		out := struct {
+			Pairs []jsonPair `json:"pairs"`
+			More  bool       `json:"more"`
+		}{Pairs: []jsonPair{}, More: more}
+		for _, p := range pairs {
+			out.Pairs = append(out.Pairs, jsonPair{sh.enc.encode(p[0]), sh.enc.encode(p[1])})
+		}
+		sh.printJSON(out)
+		return
+	}
+	for _, p := range pairs {
+		fmt.Fprintf(sh.out, "%s %s\n", sh.enc.quote(p[0]), sh.enc.quote(p[1]))
+	}
+	if more {
+		fmt.Fprintf(sh.out, "(%d keys, type next for more)\n", len(pairs))
+	} else {
+		fmt.Fprintf(sh.out, "(%d keys)\n", len(pairs))
+	}
+}
+
+// the tab completion of the commands, the arguments of the
+// meta-commands, and the keys of the text encoding
+func (sh *shell) complete(head string, word string) []string {
+	fields := strings.Fields(head)
+	if len(fields) == 0 {
+		names := append([]string{}, shellCommands...)
+		for name := range metaCommands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return withPrefix(names, word)
+	}
+	name := strings.ToLower(fields[0])
+	if opts, ok := metaCommands[name]; ok && len(fields) == 1 {
+		return withPrefix(opts, word)
+	}
+	switch {
+	case name == "get" && len(fields) == 1,
+		name == "set" && len(fields) == 1,
+		name == "scan" && len(fields) <= 2,
+		name == "del":
+		return sh.completeKey(word)
+	}
+	return nil
+}
+
+func withPrefix(words []string, prefix string) []string {
+	out := []string{}
+	for _, w := range words {
+		if strings.HasPrefix(w, prefix) {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+// the keys that can be typed as bare words
+func (sh *shell) completeKey(word string) []string {
+	if sh.enc != ENC_TEXT || strings.HasPrefix(word, `"`) || strings.HasPrefix(word, "'") {
+		return nil
+	}
+	iter := sh.kv().Scan([]byte(word), nil)
+	defer iter.Close()
+	out := []string{}
+	for ; iter.Valid() && len(out) < SHELL_COMPLETE_MAX; iter.Next() {
+		key := iter.Key()
+		if !strings.HasPrefix(string(key), word) {
+			break
+		}
+		if sh.enc.quote(key) == string(key) {
+			out = append(out, string(key))
+		}
+	}
+	return out
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// the line editor is only on linux, elsewhere the shell reads plain lines
+func isTerminal(fd uintptr) bool {
+	return false
+}
+
+func makeRaw(fd uintptr) (restore func(), err error) {
+	return nil, errors.New("not supported")
+}
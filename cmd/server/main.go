@@ -0,0 +1,59 @@
+// server serves a database file to redis clients.
+//
+//	usage: server [-addr ADDR] [-wal] FILE
+//
+// ADDR is host:port, or unix:PATH for a Unix socket. The commands are
+// GET, SET, DEL, EXISTS, SCAN, MULTI/EXEC/DISCARD, PING, ECHO, INFO, QUIT,
+// and RANGE for the client package.
+// SIGINT or SIGTERM stops the server and closes the file.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"killerDB/btree"
+	"killerDB/resp"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:6379", "host:port or unix:PATH")
+	wal := flag.Bool("wal", false, "commit with the write-ahead log")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: server [-addr ADDR] [-wal] FILE")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db := &btree.KV{Path: flag.Arg(0), WAL: *wal}
+	if err := db.Open(); err != nil {
+		log.Fatalf("open %s: %v", db.Path, err)
+	}
+	defer db.Close()
+	ln, err := resp.Listen(*addr)
+	if err != nil {
+		db.Close()
+		log.Fatalf("listen: %v", err)
+	}
+	srv := &resp.Server{KV: db}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-stop
+		srv.Close()
+	}()
+	log.Printf("serving %s on %s", db.Path, ln.Addr())
+	if err := srv.Serve(ln); err != resp.ErrServerClosed {
+		log.Printf("serve: %v", err)
+	}
+	srv.Close()
+}
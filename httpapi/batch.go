@@ -0,0 +1,102 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"killerDB/btree"
+)
+
+// POST /batch applies the writes in a single transaction.
+//
+//	{"ops": [{"op": "put", "key": "a", "value": "1"}, {"op": "delete", "key": "b"}]}
+//
+// The response has a result for each op in the same order, found is true
+// if the key existed, i.e. it was replaced or deleted.
+//
+//	{"results": [{"found": false}, {"found": true}]}
+//
+// Any error fails the whole batch and nothing is written.
+type batchBody struct {
+	Ops []batchOp `json:"ops"`
+}
+
+type batchOp struct {
+	Op    string `json:"op"` // put or delete
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type batchResults struct {
+	Results []batchResult `json:"results"`
+}
+
+type batchResult struct {
+	Found bool `json:"found"`
+}
+
+func (h *Handler) batch(w http.ResponseWriter, r *http.Request) {
+	c, ok := requestCodec(w, r)
+	if !ok {
+		return
+	}
+	var body batchBody
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, HTTP_MAX_BATCH))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, err)
+		} else {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("bad JSON: %w", err))
+		}
+		return
+	}
+
+	tx := h.KV.Begin()
+	results := batchResults{Results: make([]batchResult, 0, len(body.Ops))}
+	for i, op := range body.Ops {
+		found, status, err := batchApply(tx, c, op)
+		if err != nil {
+			tx.Abort()
+			err = fmt.Errorf("op %d: %w", i, err)
+			if status != 0 {
+				writeError(w, status, err)
+			} else {
+				kvError(w, err)
+			}
+			return
+		}
+		results.Results = append(results.Results, batchResult{Found: found})
+	}
+	if err := tx.Commit(); err != nil {
+		kvError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// an error with a status code is a bad op, otherwise it's from the KV
+func batchApply(tx *btree.KVTX, c codec, op batchOp) (bool, int, error) {
+	key, err := c.decode(op.Key)
+	if err != nil {
+		return false, http.StatusBadRequest, fmt.Errorf("key: %w", err)
+	}
+	switch op.Op {
+	case "put":
+		val, err := c.decode(op.Value)
+		if err != nil {
+			return false, http.StatusBadRequest, fmt.Errorf("value: %w", err)
+		}
+		req := &btree.UpdateReq{Key: key, Val: val}
+		_, err = tx.Update(req)
+		return req.Found, 0, err
+	case "delete":
+		deleted, err := tx.Del(key)
+		return deleted, 0, err
+	default:
+		return false, http.StatusBadRequest, fmt.Errorf("unknown op %q", op.Op)
+	}
+}
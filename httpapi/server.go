@@ -0,0 +1,279 @@
+package httpapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"killerDB/btree"
+)
+
+// Handler serves a KV over HTTP with JSON.
+//
+//	GET    /kv/{key}                        the value as the body, 404 if missing
+//	PUT    /kv/{key}                        set the value to the body, 201 if added
+//	DELETE /kv/{key}                        204, or 404 if missing
+//	GET    /kv?start=&end=&limit=           list the keys in [start, end)
+//	POST   /batch                           several writes in one transaction
+//
+// Keys in the path and the query, and keys and values in JSON, are UTF-8
+// text unless the query has enc=base64, in which case they are in the
+// URL-safe base64 encoding without padding (RFC 4648 section 5), so that
+// they can be used in the path and the query without escaping. Padded
+// input is accepted. Binary data in JSON requires enc=base64.
+// The UTF-8 keys are percent-encoded in the URL like any text, a "+" in the
+// query is a space. The paths are cleaned by the router, so the UTF-8 keys
+// containing "//", "/./" or "/../", or ending in "/." or "/..", require
+// enc=base64.
+// PUT with "If-None-Match: *" only adds a key, with "If-Match: *" only
+// replaces one, and fails with 412 otherwise.
+type Handler struct {
+	KV *btree.KV
+	// internals
+	once sync.Once
+	mux  *http.ServeMux
+}
+
+const HTTP_LIST_LIMIT = 100      // the default number of items
+const HTTP_LIST_MAX = 1000       // the largest limit
+const HTTP_MAX_BATCH = 128 << 20 // bytes of the batch request
+
+// the error response
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+// an item of the list response
+type item struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type listBody struct {
+	Items []item `json:"items"`
+	// the start of the next page if there are more keys
+	Next *string `json:"next,omitempty"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.once.Do(func() {
+		h.mux = http.NewServeMux()
+		h.mux.HandleFunc("GET /kv/{key...}", h.get)
+		h.mux.HandleFunc("PUT /kv/{key...}", h.put)
+		h.mux.HandleFunc("DELETE /kv/{key...}", h.del)
+		h.mux.HandleFunc("GET /kv", h.list)
+		h.mux.HandleFunc("POST /batch", h.batch)
+	})
+	h.mux.ServeHTTP(w, r)
+}
+
+// the encoding of the keys and values in the request, false if it's invalid
+type codec struct {
+	base64 bool
+}
+
+func requestCodec(w http.ResponseWriter, r *http.Request) (codec, bool) {
+	switch enc := r.URL.Query().Get("enc"); enc {
+	case "", "utf8":
+		return codec{}, true
+	case "base64":
+		return codec{base64: true}, true
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown enc %q", enc))
+		return codec{}, false
+	}
+}
+
+func (c codec) decode(s string) ([]byte, error) {
+	if c.base64 {
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	}
+	return []byte(s), nil
+}
+
+func (c codec) encode(b []byte) (string, error) {
+	if c.base64 {
+		return base64.RawURLEncoding.EncodeToString(b), nil
+	}
+	if !utf8.Valid(b) {
+		return "", errors.New("binary data, use enc=base64")
+	}
+	return string(b), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorBody{Error: err.Error()})
+}
+
+// the status code of an error from the KV
+func kvError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, btree.ErrEmptyKey), errors.Is(err, btree.ErrKeyTooLarge):
+		writeError(w, http.StatusBadRequest, err)
+	case errors.Is(err, btree.ErrValueTooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// the key in the path
+func pathKey(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	c, ok := requestCodec(w, r)
+	if !ok {
+		return nil, false
+	}
+	key, err := c.decode(r.PathValue("key"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("key: %w", err))
+		return nil, false
+	}
+	return key, true
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request) {
+	key, ok := pathKey(w, r)
+	if !ok {
+		return
+	}
+	val, ok, err := h.KV.Get(key)
+	if err != nil {
+		kvError(w, err)
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("key not found"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(val)))
+	_, _ = w.Write(val)
+}
+
+func (h *Handler) put(w http.ResponseWriter, r *http.Request) {
+	key, ok := pathKey(w, r)
+	if !ok {
+		return
+	}
+	req := &btree.UpdateReq{Key: key, Mode: btree.MODE_UPSERT}
+	switch {
+	case r.Header.Get("If-None-Match") == "*":
+		req.Mode = btree.MODE_INSERT_ONLY
+	case r.Header.Get("If-Match") == "*":
+		req.Mode = btree.MODE_UPDATE_ONLY
+	}
+	val, err := io.ReadAll(http.MaxBytesReader(w, r.Body, btree.BTREE_MAX_BLOB_SIZE))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, btree.ErrValueTooLarge)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	req.Val = val
+	if _, err := h.KV.Update(req); err != nil {
+		kvError(w, err)
+		return
+	}
+	switch {
+	case req.Mode == btree.MODE_INSERT_ONLY && req.Found:
+		writeError(w, http.StatusPreconditionFailed, errors.New("key exists"))
+	case req.Mode == btree.MODE_UPDATE_ONLY && !req.Found:
+		writeError(w, http.StatusPreconditionFailed, errors.New("key not found"))
+	case req.Found:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func (h *Handler) del(w http.ResponseWriter, r *http.Request) {
+	key, ok := pathKey(w, r)
+	if !ok {
+		return
+	}
+	deleted, err := h.KV.Del(key)
+	if err != nil {
+		kvError(w, err)
+		return
+	}
+	if !deleted {
+		writeError(w, http.StatusNotFound, errors.New("key not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /kv?start=&end=&limit=, an empty end is the last key
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
+	c, ok := requestCodec(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	start, err := c.decode(query.Get("start"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("start: %w", err))
+		return
+	}
+	var end []byte
+	if query.Has("end") {
+		if end, err = c.decode(query.Get("end")); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("end: %w", err))
+			return
+		}
+	}
+	limit := HTTP_LIST_LIMIT
+	if s := query.Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > HTTP_LIST_MAX {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("limit must be in [1, %d]", HTTP_LIST_MAX))
+			return
+		}
+	}
+
+	iter := h.KV.Scan(start, end)
+	defer iter.Close()
+	body := listBody{Items: []item{}}
+	for ; iter.Valid() && len(body.Items) < limit; iter.Next() {
+		key, err := c.encode(iter.Key())
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		val, err := c.encode(iter.Val())
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		body.Items = append(body.Items, item{Key: key, Value: val})
+	}
+	if err := iter.Err(); err != nil {
+		kvError(w, err)
+		return
+	}
+	if iter.Valid() {
+		next, err := c.encode(iter.Key())
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		body.Next = &next
+	}
+	writeJSON(w, http.StatusOK, body)
+}
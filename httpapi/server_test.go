@@ -0,0 +1,190 @@
+package httpapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"killerDB/btree"
+)
+
+type testServer struct {
+	t  *testing.T
+	ts *httptest.Server
+}
+
+func newTestServer(t *testing.T) *testServer {
+	db := &btree.KV{Path: filepath.Join(t.TempDir(), "db")}
+	if err := db.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	ts := httptest.NewServer(&Handler{KV: db})
+	t.Cleanup(ts.Close)
+	return &testServer{t: t, ts: ts}
+}
+
+// the status code and the body, the redirects are not followed
+func (s *testServer) do(method string, path string, body string, header ...string) (int, string) {
+	s.t.Helper()
+	req, err := http.NewRequest(method, s.ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return resp.StatusCode, string(data)
+}
+
+// check the status code and that the body contains a string
+func (s *testServer) expect(status int, want string, method string, path string, body string, header ...string) {
+	s.t.Helper()
+	code, got := s.do(method, path, body, header...)
+	if code != status || !strings.Contains(got, want) {
+		s.t.Fatalf("%s %s: got %d %q, want %d %q", method, path, code, got, status, want)
+	}
+}
+
+func (s *testServer) list(path string) listBody {
+	s.t.Helper()
+	code, data := s.do("GET", path, "")
+	if code != http.StatusOK {
+		s.t.Fatalf("GET %s: %d %q", path, code, data)
+	}
+	var body listBody
+	if err := json.Unmarshal([]byte(data), &body); err != nil {
+		s.t.Fatal(err)
+	}
+	return body
+}
+
+func TestStatusCodes(t *testing.T) {
+	s := newTestServer(t)
+	s.expect(404, "key not found", "GET", "/kv/a", "")
+	s.expect(201, "", "PUT", "/kv/a", "1")
+	s.expect(204, "", "PUT", "/kv/a", "2")
+	s.expect(200, "2", "GET", "/kv/a", "")
+	s.expect(412, "key exists", "PUT", "/kv/a", "3", "If-None-Match", "*")
+	s.expect(412, "key not found", "PUT", "/kv/b", "3", "If-Match", "*")
+	s.expect(204, "", "PUT", "/kv/a", "4", "If-Match", "*")
+	s.expect(201, "", "PUT", "/kv/b", "5", "If-None-Match", "*")
+	s.expect(413, "too large", "PUT", "/kv/big", strings.Repeat("x", btree.BTREE_MAX_BLOB_SIZE+1))
+	s.expect(400, "empty key", "PUT", "/kv/", "x")
+	s.expect(400, "too large", "PUT", "/kv/"+strings.Repeat("k", btree.BTREE_MAX_KEY_SIZE+1), "x")
+	s.expect(400, "unknown enc", "GET", "/kv/a?enc=hex", "")
+	s.expect(405, "", "POST", "/kv/a", "")
+	s.expect(204, "", "DELETE", "/kv/a", "")
+	s.expect(404, "key not found", "DELETE", "/kv/a", "")
+	s.expect(404, "key not found", "GET", "/kv/a", "")
+	// the binary value can't be listed as text
+	s.expect(201, "", "PUT", "/kv/bin", "\xff")
+	s.expect(422, "enc=base64", "GET", "/kv", "")
+}
+
+func TestBase64(t *testing.T) {
+	s := newTestServer(t)
+	// "/" and "+" in the standard encoding
+	keys := [][]byte{{0xff, 0xff, 0xff}, {0xfb, 0xef}, {0, 1}, []byte("a//b/../c")}
+	for i, key := range keys {
+		enc := base64.RawURLEncoding.EncodeToString(key)
+		s.expect(201, "", "PUT", "/kv/"+enc+"?enc=base64", fmt.Sprint(i))
+		s.expect(200, fmt.Sprint(i), "GET", "/kv/"+enc+"?enc=base64", "")
+	}
+	// padded
+	s.expect(200, "2", "GET", "/kv/AAE=?enc=base64", "")
+	s.expect(400, "key", "GET", "/kv/!!?enc=base64", "")
+
+	body := s.list("/kv?enc=base64")
+	if len(body.Items) != len(keys) || body.Next != nil {
+		t.Fatalf("list: %+v", body)
+	}
+	if body.Items[0].Key != "AAE" || body.Items[0].Value != "Mg" {
+		t.Fatalf("list: %+v", body.Items[0])
+	}
+	// the range between the keys with "+" and "/" in the standard encoding
+	body = s.list("/kv?enc=base64&start=--8&end=____")
+	if len(body.Items) != 1 || body.Items[0].Key != "--8" {
+		t.Fatalf("list: %+v", body)
+	}
+	// the UTF-8 key with the path cleaned by the router
+	code, _ := s.do("GET", "/kv/a//b/../c", "")
+	if code == http.StatusOK {
+		t.Fatalf("the unclean path is routed")
+	}
+}
+
+func TestListPaging(t *testing.T) {
+	s := newTestServer(t)
+	for i := 0; i < 25; i++ {
+		s.expect(201, "", "PUT", fmt.Sprintf("/kv/k%02d", i), fmt.Sprint(i))
+	}
+	var keys []string
+	start := ""
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("too many pages")
+		}
+		body := s.list("/kv?limit=10&start=" + start)
+		for _, it := range body.Items {
+			keys = append(keys, it.Key)
+		}
+		if body.Next == nil {
+			break
+		}
+		if len(body.Items) != 10 {
+			t.Fatalf("page %d: %d items", pages, len(body.Items))
+		}
+		start = *body.Next
+	}
+	if len(keys) != 25 || keys[0] != "k00" || keys[24] != "k24" {
+		t.Fatalf("keys: %v", keys)
+	}
+	body := s.list("/kv?start=k10&end=k13")
+	if len(body.Items) != 3 || body.Items[2].Key != "k12" || body.Next != nil {
+		t.Fatalf("range: %+v", body)
+	}
+	s.expect(400, "limit", "GET", "/kv?limit=0", "")
+	s.expect(400, "limit", "GET", fmt.Sprintf("/kv?limit=%d", HTTP_LIST_MAX+1), "")
+}
+
+func TestBatch(t *testing.T) {
+	s := newTestServer(t)
+	s.expect(201, "", "PUT", "/kv/a", "1")
+	s.expect(200, `{"results":[{"found":true},{"found":false},{"found":true}]}`, "POST", "/batch",
+		`{"ops":[{"op":"put","key":"a","value":"2"},{"op":"put","key":"b","value":"3"},{"op":"delete","key":"a"}]}`)
+	s.expect(404, "", "GET", "/kv/a", "")
+	s.expect(200, "3", "GET", "/kv/b", "")
+
+	// a bad op rolls back the ones before it
+	s.expect(400, "op 2: empty key", "POST", "/batch",
+		`{"ops":[{"op":"put","key":"c","value":"4"},{"op":"delete","key":"b"},{"op":"put","key":""}]}`)
+	s.expect(400, "unknown op", "POST", "/batch", `{"ops":[{"op":"put","key":"c","value":"4"},{"op":"get","key":"c"}]}`)
+	s.expect(404, "", "GET", "/kv/c", "")
+	s.expect(200, "3", "GET", "/kv/b", "")
+
+	s.expect(400, "bad JSON", "POST", "/batch", `{"ops":`)
+	s.expect(400, "bad JSON", "POST", "/batch", `{"ops":[],"x":1}`)
+	key := base64.RawURLEncoding.EncodeToString([]byte{0xff, 0xff, 0xff})
+	s.expect(200, `{"results":[{"found":false}]}`, "POST", "/batch?enc=base64",
+		`{"ops":[{"op":"put","key":"`+key+`","value":"AA"}]}`)
+	s.expect(200, "\x00", "GET", "/kv/"+key+"?enc=base64", "")
+}
@@ -0,0 +1,143 @@
+// Package keyenc serializes values into byte strings whose lexicographic
+// order, as compared by bytes.Compare in the B-tree, matches the logical
+// order of the values.
+//
+// The Append functions encode a single value without a type tag, for keys
+// whose layout is known by the reader. Tuples of mixed types carry a type
+// tag per element, see AppendTuple.
+package keyenc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var ErrShort = errors.New("keyenc: input too short")
+var ErrBadTerminator = errors.New("keyenc: unterminated string")
+
+// int64: big-endian with the sign bit flipped, so negatives sort first.
+func AppendInt64(dst []byte, v int64) []byte {
+	return binary.BigEndian.AppendUint64(dst, uint64(v)^(1<<63))
+}
+
+func DecodeInt64(src []byte) (int64, []byte, error) {
+	if len(src) < 8 {
+		return 0, src, ErrShort
+	}
+	u := binary.BigEndian.Uint64(src)
+	return int64(u ^ (1 << 63)), src[8:], nil
+}
+
+// uint64: big-endian.
+func AppendUint64(dst []byte, v uint64) []byte {
+	return binary.BigEndian.AppendUint64(dst, v)
+}
+
+func DecodeUint64(src []byte) (uint64, []byte, error) {
+	if len(src) < 8 {
+		return 0, src, ErrShort
+	}
+	return binary.BigEndian.Uint64(src), src[8:], nil
+}
+
+// float64: the IEEE 754 bits with the sign bit flipped for positives and
+// all bits flipped for negatives. -0 is stored as +0 and every NaN as
+// math.NaN(), which sorts after +Inf. A NaN with the sign bit set would
+// sort before -Inf.
+func AppendFloat64(dst []byte, v float64) []byte {
+	if v == 0 {
+		v = 0 // -0
+	} else if math.IsNaN(v) {
+		v = math.NaN()
+	}
+	bits := math.Float64bits(v)
+	if bits>>63 == 1 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	return binary.BigEndian.AppendUint64(dst, bits)
+}
+
+func DecodeFloat64(src []byte) (float64, []byte, error) {
+	if len(src) < 8 {
+		return 0, src, ErrShort
+	}
+	bits := binary.BigEndian.Uint64(src)
+	if bits>>63 == 1 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits), src[8:], nil
+}
+
+// bool: a single byte, false sorts first.
+func AppendBool(dst []byte, v bool) []byte {
+	if v {
+		return append(dst, 1)
+	}
+	return append(dst, 0)
+}
+
+func DecodeBool(src []byte) (bool, []byte, error) {
+	if len(src) < 1 {
+		return false, src, ErrShort
+	}
+	return src[0] != 0, src[1:], nil
+}
+
+// bytes: 0x00 is escaped as 0x01 0x01 and 0x01 as 0x01 0x02, then the
+// string is terminated by 0x00, so a prefix sorts before the longer string.
+func AppendBytes(dst []byte, v []byte) []byte {
+	for _, ch := range v {
+		if ch <= 1 {
+			dst = append(dst, 0x01, ch+1)
+		} else {
+			dst = append(dst, ch)
+		}
+	}
+	return append(dst, 0x00)
+}
+
+// the decoded value is a copy
+func DecodeBytes(src []byte) ([]byte, []byte, error) {
+	idx := bytes.IndexByte(src, 0)
+	if idx < 0 {
+		return nil, src, ErrBadTerminator
+	}
+	out := make([]byte, 0, idx)
+	for i := 0; i < idx; i++ {
+		if src[i] == 0x01 && i+1 < idx {
+			i++
+			out = append(out, src[i]-1)
+		} else {
+			out = append(out, src[i])
+		}
+	}
+	return out, src[idx+1:], nil
+}
+
+func AppendString(dst []byte, v string) []byte {
+	return AppendBytes(dst, []byte(v))
+}
+
+func DecodeString(src []byte) (string, []byte, error) {
+	out, rest, err := DecodeBytes(src)
+	return string(out), rest, err
+}
+
+// PrefixEnd returns the smallest key greater than every key beginning with
+// the prefix, nil if there is no such key.
+func PrefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] != 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package keyenc
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"testing"
+)
+
+// the encodings of the values in ascending order must be strictly ascending
+func checkOrder[T any](t *testing.T, vals []T, enc func([]byte, T) []byte) {
+	t.Helper()
+	for i := 1; i < len(vals); i++ {
+		a, b := enc(nil, vals[i-1]), enc(nil, vals[i])
+		if bytes.Compare(a, b) >= 0 {
+			t.Fatalf("%v (%x) >= %v (%x)", vals[i-1], a, vals[i], b)
+		}
+	}
+}
+
+func TestInt64(t *testing.T) {
+	vals := []int64{math.MinInt64, math.MinInt64 + 1, -256, -1, 0, 1, 255, 256, math.MaxInt64 - 1, math.MaxInt64}
+	checkOrder(t, vals, AppendInt64)
+	for _, v := range vals {
+		got, rest, err := DecodeInt64(append(AppendInt64(nil, v), 'x'))
+		if err != nil || got != v || string(rest) != "x" {
+			t.Fatalf("%d: %d %q %v", v, got, rest, err)
+		}
+	}
+	if _, _, err := DecodeInt64(make([]byte, 7)); !errors.Is(err, ErrShort) {
+		t.Fatalf("short: %v", err)
+	}
+}
+
+func TestUint64(t *testing.T) {
+	vals := []uint64{0, 1, 255, 256, 1 << 63, math.MaxUint64 - 1, math.MaxUint64}
+	checkOrder(t, vals, AppendUint64)
+	for _, v := range vals {
+		got, rest, err := DecodeUint64(AppendUint64(nil, v))
+		if err != nil || got != v || len(rest) != 0 {
+			t.Fatalf("%d: %d %q %v", v, got, rest, err)
+		}
+	}
+	if _, _, err := DecodeUint64(nil); !errors.Is(err, ErrShort) {
+		t.Fatalf("short: %v", err)
+	}
+}
+
+func TestFloat64(t *testing.T) {
+	vals := []float64{
+		math.Inf(-1), -math.MaxFloat64, -1e300, -1, -math.SmallestNonzeroFloat64,
+		0, math.SmallestNonzeroFloat64, 1, 1e300, math.MaxFloat64, math.Inf(1),
+	}
+	checkOrder(t, vals, AppendFloat64)
+	for _, v := range vals {
+		got, rest, err := DecodeFloat64(AppendFloat64(nil, v))
+		if err != nil || got != v || len(rest) != 0 {
+			t.Fatalf("%g: %g %q %v", v, got, rest, err)
+		}
+	}
+
+	// -0 is +0
+	negZero := math.Copysign(0, -1)
+	if !bytes.Equal(AppendFloat64(nil, negZero), AppendFloat64(nil, 0)) {
+		t.Fatal("-0 != +0")
+	}
+	if got, _, _ := DecodeFloat64(AppendFloat64(nil, negZero)); math.Signbit(got) {
+		t.Fatal("-0 decoded")
+	}
+
+	// every NaN is the same and sorts after +Inf, including the negative ones
+	inf := AppendFloat64(nil, math.Inf(1))
+	for _, bits := range []uint64{0x7ff8000000000001, 0x7ff0000000000001, 0xfff8000000000000, 0xffffffffffffffff} {
+		nan := math.Float64frombits(bits)
+		enc := AppendFloat64(nil, nan)
+		if !bytes.Equal(enc, AppendFloat64(nil, math.NaN())) || bytes.Compare(enc, inf) <= 0 {
+			t.Fatalf("NaN %x: %x", bits, enc)
+		}
+		if got, _, _ := DecodeFloat64(enc); !math.IsNaN(got) {
+			t.Fatalf("NaN %x decoded as %g", bits, got)
+		}
+	}
+}
+
+func TestBool(t *testing.T) {
+	checkOrder(t, []bool{false, true}, AppendBool)
+	for _, v := range []bool{false, true} {
+		if got, rest, err := DecodeBool(AppendBool(nil, v)); err != nil || got != v || len(rest) != 0 {
+			t.Fatalf("%v: %v %v", v, got, err)
+		}
+	}
+	if _, _, err := DecodeBool(nil); !errors.Is(err, ErrShort) {
+		t.Fatalf("short: %v", err)
+	}
+}
+
+func TestBytes(t *testing.T) {
+	vals := []string{
+		"", "\x00", "\x00\x00", "\x00\x01", "\x00\xff", "\x01", "\x01\x00", "\x01\x01",
+		"\x02", "a", "a\x00", "a\x00\x00", "a\x01", "ab", "b", "\xff", "\xff\x00",
+	}
+	checkOrder(t, vals, AppendString)
+	for _, v := range vals {
+		enc := AppendBytes(nil, []byte(v))
+		if bytes.IndexByte(enc, 0) != len(enc)-1 {
+			t.Fatalf("%q: 0x00 before the end in %x", v, enc)
+		}
+		got, rest, err := DecodeBytes(append(enc, "rest"...))
+		if err != nil || string(got) != v || string(rest) != "rest" {
+			t.Fatalf("%q: %q %q %v", v, got, rest, err)
+		}
+	}
+
+	// the order of the composite keys is that of their first element
+	for i := 1; i < len(vals); i++ {
+		a := AppendString(AppendString(nil, vals[i-1]), "\xff\xff")
+		b := AppendString(AppendString(nil, vals[i]), "")
+		if bytes.Compare(a, b) >= 0 {
+			t.Fatalf("composite %q %q", vals[i-1], vals[i])
+		}
+	}
+
+	if _, _, err := DecodeBytes([]byte("abc")); !errors.Is(err, ErrBadTerminator) {
+		t.Fatalf("unterminated: %v", err)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	cases := []struct{ prefix, end []byte }{
+		{[]byte("ab"), []byte("ac")},
+		{[]byte("a\xff"), []byte("b")},
+		{[]byte{0x00}, []byte{0x01}},
+		{[]byte{0xff, 0xff}, nil},
+		{nil, nil},
+	}
+	for _, tc := range cases {
+		if got := PrefixEnd(tc.prefix); !bytes.Equal(got, tc.end) {
+			t.Fatalf("%q: %q, want %q", tc.prefix, got, tc.end)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package keyenc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Tuple is a list of values of mixed types. The elements are one of:
+// nil, bool, int, int64, uint64, float64, string, []byte or a nested Tuple.
+// Decoding gives back int64 for int and []byte for string.
+type Tuple []any
+
+// type tags, the values of different types are ordered by the tag.
+const (
+	tagEnd     = 0x00 // terminates a nested tuple
+	tagNull    = 0x01
+	tagFalse   = 0x02
+	tagTrue    = 0x03
+	tagInt64   = 0x10
+	tagUint64  = 0x11
+	tagFloat64 = 0x12
+	tagBytes   = 0x20
+	tagTuple   = 0x30
+)
+
+var ErrBadTag = errors.New("keyenc: bad type tag")
+
+// AppendTuple encodes each element with its type tag.
+// The top-level tuple is not terminated, so the encoding of a tuple is a
+// prefix of the encoding of any longer tuple beginning with the same
+// elements, which makes it usable for prefix scans.
+func AppendTuple(dst []byte, t Tuple) []byte {
+	for _, v := range t {
+		dst = appendElem(dst, v)
+	}
+	return dst
+}
+
+func appendElem(dst []byte, v any) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(dst, tagNull)
+	case bool:
+		if v {
+			return append(dst, tagTrue)
+		}
+		return append(dst, tagFalse)
+	case int:
+		return AppendInt64(append(dst, tagInt64), int64(v))
+	case int64:
+		return AppendInt64(append(dst, tagInt64), v)
+	case uint64:
+		return AppendUint64(append(dst, tagUint64), v)
+	case float64:
+		return AppendFloat64(append(dst, tagFloat64), v)
+	case string:
+		return AppendString(append(dst, tagBytes), v)
+	case []byte:
+		return AppendBytes(append(dst, tagBytes), v)
+	case Tuple:
+		return append(AppendTuple(append(dst, tagTuple), v), tagEnd)
+	default:
+		panic(fmt.Sprintf("keyenc: unsupported type %T", v))
+	}
+}
+
+// DecodeTuple decodes the whole input as a tuple.
+func DecodeTuple(src []byte) (Tuple, error) {
+	t, rest, err := decodeElems(src, false)
+	if err == nil && len(rest) != 0 {
+		err = errors.New("keyenc: trailing bytes after the tuple")
+	}
+	return t, err
+}
+
+// decode until the end of the input, or the end tag of a nested tuple
+func decodeElems(src []byte, nested bool) (Tuple, []byte, error) {
+	t := Tuple{}
+	for {
+		if len(src) == 0 {
+			if nested {
+				return nil, src, ErrShort
+			}
+			return t, src, nil
+		}
+		tag := src[0]
+		src = src[1:]
+
+		var v any
+		var err error
+		switch tag {
+		case tagEnd:
+			if !nested {
+				return nil, src, ErrBadTag
+			}
+			return t, src, nil
+		case tagNull:
+			v = nil
+		case tagFalse:
+			v = false
+		case tagTrue:
+			v = true
+		case tagInt64:
+			v, src, err = DecodeInt64(src)
+		case tagUint64:
+			v, src, err = DecodeUint64(src)
+		case tagFloat64:
+			v, src, err = DecodeFloat64(src)
+		case tagBytes:
+			v, src, err = DecodeBytes(src)
+		case tagTuple:
+			v, src, err = decodeElems(src, true)
+		default:
+			err = ErrBadTag
+		}
+		if err != nil {
+			return nil, src, err
+		}
+		t = append(t, v)
+	}
+}
@@ -0,0 +1,165 @@
+package keyenc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// the tuples in ascending order: by the type tag, then the value, a prefix
+// first
+var sortedTuples = []Tuple{
+	{},
+	{nil},
+	{nil, nil},
+	{false},
+	{true},
+	{true, int64(0)},
+	{int64(math.MinInt64)},
+	{int64(-1)},
+	{int64(0)},
+	{int64(0), nil},
+	{int64(0), "a"},
+	{int64(math.MaxInt64)},
+	{uint64(0)},
+	{uint64(math.MaxUint64)},
+	{math.Inf(-1)},
+	{-1.5},
+	{0.0},
+	{math.Inf(1)},
+	{math.NaN()},
+	{[]byte{}},
+	{[]byte{}, int64(1)},
+	{[]byte{0x00}},
+	{[]byte{0x00}, int64(1)},
+	{[]byte{0x00, 0x00}},
+	{[]byte{0x01}},
+	{[]byte{0x01, 0x00}},
+	{"a"},
+	{"a", nil},
+	{"a\x00"},
+	{"b"},
+	{Tuple{}},
+	{Tuple{}, int64(1)},
+	{Tuple{nil}},
+	{Tuple{int64(1)}},
+	{Tuple{int64(1)}, "z"},
+	{Tuple{int64(1), int64(2)}},
+	{Tuple{int64(1), Tuple{}}},
+	{Tuple{int64(1), Tuple{"a"}}},
+	{Tuple{int64(1), Tuple{"a"}, nil}},
+	{Tuple{int64(1), Tuple{"a", "b"}}},
+	{Tuple{int64(2)}},
+}
+
+// the decoded form: int64 for int and []byte for string
+func normalize(v any) any {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case string:
+		return []byte(v)
+	case Tuple:
+		out := Tuple{}
+		for _, elem := range v {
+			out = append(out, normalize(elem))
+		}
+		return out
+	}
+	return v
+}
+
+func equal(a, b any) bool {
+	fa, ok1 := a.(float64)
+	fb, ok2 := b.(float64)
+	if ok1 && ok2 && math.IsNaN(fa) && math.IsNaN(fb) {
+		return true
+	}
+	ta, ok1 := a.(Tuple)
+	tb, ok2 := b.(Tuple)
+	if ok1 && ok2 {
+		if len(ta) != len(tb) {
+			return false
+		}
+		for i := range ta {
+			if !equal(ta[i], tb[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func TestTupleOrder(t *testing.T) {
+	for i := 1; i < len(sortedTuples); i++ {
+		a, b := AppendTuple(nil, sortedTuples[i-1]), AppendTuple(nil, sortedTuples[i])
+		if bytes.Compare(a, b) >= 0 {
+			t.Fatalf("%v (%x) >= %v (%x)", sortedTuples[i-1], a, sortedTuples[i], b)
+		}
+	}
+}
+
+func TestTupleRoundTrip(t *testing.T) {
+	tuples := append(sortedTuples, Tuple{1, "x", []byte{0, 1, 2}, Tuple{Tuple{Tuple{}}, false}, -0.0, uint64(7)})
+	for _, tup := range tuples {
+		got, err := DecodeTuple(AppendTuple(nil, tup))
+		if err != nil {
+			t.Fatalf("%v: %v", tup, err)
+		}
+		if want := normalize(tup); !equal(got, want) {
+			t.Fatalf("%v: decoded %v", want, got)
+		}
+	}
+}
+
+// the encoding of a tuple is a prefix of the longer ones
+func TestTuplePrefix(t *testing.T) {
+	short := AppendTuple(nil, Tuple{"users", int64(5)})
+	long := AppendTuple(nil, Tuple{"users", int64(5), "name"})
+	if !bytes.HasPrefix(long, short) {
+		t.Fatalf("%x is not a prefix of %x", short, long)
+	}
+	other := AppendTuple(nil, Tuple{"users", int64(6)})
+	if end := PrefixEnd(short); bytes.Compare(long, end) >= 0 || bytes.Compare(other, end) < 0 {
+		t.Fatal("prefix end")
+	}
+	// not for the nested tuples, they are terminated
+	nested := AppendTuple(nil, Tuple{Tuple{"users"}})
+	if bytes.HasPrefix(AppendTuple(nil, Tuple{Tuple{"users", int64(5)}}), nested) {
+		t.Fatal("nested tuple is a prefix")
+	}
+}
+
+func TestTupleErrors(t *testing.T) {
+	good := AppendTuple(nil, Tuple{int64(1), "ab", Tuple{true}})
+	cases := []struct {
+		data []byte
+		err  error
+	}{
+		{[]byte{0x7f}, ErrBadTag},
+		{[]byte{tagEnd}, ErrBadTag},
+		{[]byte{tagInt64, 1, 2}, ErrShort},
+		{[]byte{tagBytes, 'a'}, ErrBadTerminator},
+		{[]byte{tagTuple, tagNull}, ErrShort},
+		{good[:len(good)-1], ErrShort},
+		{append(good, tagEnd), ErrBadTag},
+	}
+	for _, tc := range cases {
+		if _, err := DecodeTuple(tc.data); !errors.Is(err, tc.err) {
+			t.Fatalf("%x: %v, want %v", tc.data, err, tc.err)
+		}
+	}
+}
+
+func TestTupleBadType(t *testing.T) {
+	defer func() {
+		if r := recover(); fmt.Sprint(r) != "keyenc: unsupported type float32" {
+			t.Fatalf("panic: %v", r)
+		}
+	}()
+	AppendTuple(nil, Tuple{float32(1)})
+}
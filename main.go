@@ -1,9 +0,0 @@
-package main
-
-import (
-	"killerDB/btree"
-)
-
-func main() {
-	btree.Main()
-}
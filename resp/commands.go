@@ -0,0 +1,415 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"killerDB/btree"
+	"killerDB/keyenc"
+)
+
+// the KV operations used by the commands,
+// implemented by transactions and by read-only snapshots.
+type kvStore interface {
+	Get(key []byte) ([]byte, bool, error)
+	Scan(start []byte, end []byte) *btree.KVIter
+	Update(req *btree.UpdateReq) (bool, error)
+	Del(key []byte) (bool, error)
+}
+
+// a snapshot for the commands that don't write
+type snapshot struct {
+	*btree.KVReader
+}
+
+var errReadOnly = errors.New("write in a read-only command")
+
+func (snapshot) Update(req *btree.UpdateReq) (bool, error) {
+	return false, errReadOnly
+}
+
+func (snapshot) Del(key []byte) (bool, error) {
+	return false, errReadOnly
+}
+
+type command struct {
+	arity int  // the number of arguments with the name, -n means at least n
+	write bool // run in a transaction instead of a snapshot
+	run   func(c *conn, kv kvStore, out *reply, args [][]byte) error
+}
+
+// the commands that can be queued by MULTI,
+// the connection state commands are handled by conn.dispatch.
+var commands map[string]*command
+
+func init() {
+	commands = map[string]*command{
+		"PING":   {arity: -1, run: cmdPing},
+		"ECHO":   {arity: 2, run: cmdEcho},
+		"INFO":   {arity: -1, run: cmdInfo},
+		"GET":    {arity: 2, run: cmdGet},
+		"EXISTS": {arity: -2, run: cmdExists},
+		"SCAN":   {arity: -2, run: cmdScan},
+		"RANGE":  {arity: 4, run: cmdRange},
+		"SET":    {arity: -3, write: true, run: cmdSet},
+		"DEL":    {arity: -2, write: true, run: cmdDel},
+	}
+}
+
+// the state of a client connection
+type conn struct {
+	srv *Server
+	// MULTI
+	multi  bool
+	queue  [][][]byte
+	failed bool // a command was rejected while queuing
+	// SCAN cursors, the id -> the next key
+	cursors    map[uint64][]byte
+	nextCursor uint64
+}
+
+const RESP_MAX_CURSORS = 1024 // per connection, the older ones are dropped
+
+// run a command, returns true if the connection should be closed
+func (c *conn) dispatch(out *reply, args [][]byte) bool {
+	name := strings.ToUpper(string(args[0]))
+	switch name {
+	case "QUIT":
+		out.simple("OK")
+		return true
+	case "MULTI":
+		if c.multi {
+			out.errorf("ERR MULTI calls can not be nested")
+			return false
+		}
+		c.multi, c.queue, c.failed = true, nil, false
+		out.simple("OK")
+		return false
+	case "DISCARD":
+		if !c.multi {
+			out.errorf("ERR DISCARD without MULTI")
+			return false
+		}
+		c.multi, c.queue = false, nil
+		out.simple("OK")
+		return false
+	case "EXEC":
+		if !c.multi {
+			out.errorf("ERR EXEC without MULTI")
+			return false
+		}
+		c.exec(out)
+		return false
+	}
+
+	cmd := commands[name]
+	switch {
+	case cmd == nil:
+		out.errorf(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	case cmd.arity >= 0 && len(args) != cmd.arity,
+		cmd.arity < 0 && len(args) < -cmd.arity:
+		out.errorf(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+	case c.multi:
+		c.queue = append(c.queue, args)
+		out.simple("QUEUED")
+		return false
+	default:
+		c.run(out, cmd, args)
+		return false
+	}
+	c.failed = c.failed || c.multi
+	return false
+}
+
+// a single command outside MULTI
+func (c *conn) run(out *reply, cmd *command, args [][]byte) {
+	db := c.srv.KV
+	mark := len(out.buf)
+	if !cmd.write {
+		reader := db.BeginRead()
+		defer db.EndRead(reader)
+		if err := cmd.run(c, snapshot{reader}, out, args); err != nil {
+			out.buf = out.buf[:mark]
+			out.errorf("ERR " + err.Error())
+		}
+		return
+	}
+
+	tx := db.Begin()
+	err := cmd.run(c, tx, out, args)
+	if err != nil {
+		tx.Abort()
+	} else {
+		err = tx.Commit()
+	}
+	if err != nil {
+		out.buf = out.buf[:mark]
+		out.errorf("ERR " + err.Error())
+	}
+}
+
+// run the queued commands in a transaction. like redis, a failed command
+// doesn't stop the others, but nothing is written if the commit fails.
+func (c *conn) exec(out *reply) {
+	queue, failed := c.queue, c.failed
+	c.multi, c.queue, c.failed = false, nil, false
+	if failed {
+		out.errorf("EXECABORT Transaction discarded because of previous errors.")
+		return
+	}
+
+	tx := c.srv.KV.Begin()
+	results := reply{}
+	for _, args := range queue {
+		mark := len(results.buf)
+		cmd := commands[strings.ToUpper(string(args[0]))]
+		if err := cmd.run(c, tx, &results, args); err != nil {
+			results.buf = results.buf[:mark]
+			results.errorf("ERR " + err.Error())
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		out.errorf("ERR " + err.Error())
+		return
+	}
+	out.array(len(queue))
+	out.buf = append(out.buf, results.buf...)
+}
+
+func cmdPing(c *conn, kv kvStore, out *reply, args [][]byte) error {
+	switch len(args) {
+	case 1:
+		out.simple("PONG")
+	case 2:
+		out.bulk(args[1])
+	default:
+		out.errorf("ERR wrong number of arguments for 'ping' command")
+	}
+	return nil
+}
+
+func cmdEcho(c *conn, kv kvStore, out *reply, args [][]byte) error {
+	out.bulk(args[1])
+	return nil
+}
+
+func cmdInfo(c *conn, kv kvStore, out *reply, args [][]byte) error {
+	srv := c.srv
+	srv.mu.Lock()
+	clients := len(srv.conns)
+	srv.mu.Unlock()
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Server\r\n")
+	fmt.Fprintf(&b, "process_id:%d\r\n", os.Getpid())
+	fmt.Fprintf(&b, "uptime_in_seconds:%d\r\n", int64(time.Since(srv.started).Seconds()))
+	fmt.Fprintf(&b, "\r\n# Clients\r\n")
+	fmt.Fprintf(&b, "connected_clients:%d\r\n", clients)
+	fmt.Fprintf(&b, "\r\n# Stats\r\n")
+	fmt.Fprintf(&b, "total_connections_received:%d\r\n", srv.stats.connections.Load())
+	fmt.Fprintf(&b, "total_commands_processed:%d\r\n", srv.stats.commands.Load())
+	fmt.Fprintf(&b, "\r\n# Persistence\r\n")
+	fmt.Fprintf(&b, "path:%s\r\n", srv.KV.Path)
+	fmt.Fprintf(&b, "wal_enabled:%d\r\n", boolInt(srv.KV.WAL))
+	out.bulk([]byte(b.String()))
+	return nil
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func cmdGet(c *conn, kv kvStore, out *reply, args [][]byte) error {
+	val, ok, err := kv.Get(args[1])
+	if err != nil {
+		return err
+	}
+	if !ok {
+		out.null()
+	} else {
+		out.bulk(val)
+	}
+	return nil
+}
+
+func cmdExists(c *conn, kv kvStore, out *reply, args [][]byte) error {
+	n := int64(0)
+	for _, key := range args[1:] {
+		_, ok, err := kv.Get(key)
+		if err != nil {
+			return err
+		}
+		if ok {
+			n++
+		}
+	}
+	out.int(n)
+	return nil
+}
+
+// SET key value [NX | XX] [GET]
+func cmdSet(c *conn, kv kvStore, out *reply, args [][]byte) error {
+	req := &btree.UpdateReq{Key: args[1], Val: args[2], Mode: btree.MODE_UPSERT}
+	get := false
+	for _, opt := range args[3:] {
+		switch {
+		case strings.EqualFold(string(opt), "NX") && req.Mode == btree.MODE_UPSERT:
+			req.Mode = btree.MODE_INSERT_ONLY
+		case strings.EqualFold(string(opt), "XX") && req.Mode == btree.MODE_UPSERT:
+			req.Mode = btree.MODE_UPDATE_ONLY
+		case strings.EqualFold(string(opt), "GET") && !get:
+			get = true
+		default:
+			out.errorf("ERR syntax error")
+			return nil
+		}
+	}
+	if _, err := kv.Update(req); err != nil {
+		return err
+	}
+	applied := !(req.Mode == btree.MODE_INSERT_ONLY && req.Found ||
+		req.Mode == btree.MODE_UPDATE_ONLY && !req.Found)
+	switch {
+	case get && req.Found:
+		out.bulk(req.Old)
+	case get || !applied:
+		out.null()
+	default:
+		out.simple("OK")
+	}
+	return nil
+}
+
+func cmdDel(c *conn, kv kvStore, out *reply, args [][]byte) error {
+	n := int64(0)
+	for _, key := range args[1:] {
+		deleted, err := kv.Del(key)
+		if err != nil {
+			return err
+		}
+		if deleted {
+			n++
+		}
+	}
+	out.int(n)
+	return nil
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count]
+// the cursor is a number for the clients, it refers to the next key saved
+// in the connection. the keys added or removed during the iteration may
+// or may not be returned, the others are returned exactly once.
+func cmdScan(c *conn, kv kvStore, out *reply, args [][]byte) error {
+	var start []byte
+	if cursor, err := strconv.ParseUint(string(args[1]), 10, 64); err != nil {
+		out.errorf("ERR invalid cursor")
+		return nil
+	} else if cursor != 0 {
+		key, ok := c.cursors[cursor]
+		if !ok {
+			out.errorf("ERR invalid cursor")
+			return nil
+		}
+		delete(c.cursors, cursor)
+		start = key
+	}
+	var pattern []byte
+	count := 10
+	for opts := args[2:]; len(opts) > 0; opts = opts[2:] {
+		if len(opts) < 2 {
+			out.errorf("ERR syntax error")
+			return nil
+		}
+		switch {
+		case strings.EqualFold(string(opts[0]), "MATCH"):
+			pattern = opts[1]
+		case strings.EqualFold(string(opts[0]), "COUNT"):
+			n, err := strconv.Atoi(string(opts[1]))
+			if err != nil || n < 1 {
+				out.errorf("ERR value is not an integer or out of range")
+				return nil
+			}
+			count = n
+		default:
+			out.errorf("ERR syntax error")
+			return nil
+		}
+	}
+
+	// only the keys with the literal prefix of the pattern are visited
+	var end []byte
+	if prefix := globPrefix(pattern); len(prefix) > 0 {
+		if bytes.Compare(start, prefix) < 0 {
+			start = prefix
+		}
+		end = keyenc.PrefixEnd(prefix)
+	}
+	iter := kv.Scan(start, end)
+	defer iter.Close()
+	keys := [][]byte{}
+	for i := 0; i < count && iter.Valid(); i++ {
+		if pattern == nil || globMatch(pattern, iter.Key()) {
+			keys = append(keys, bytes.Clone(iter.Key()))
+		}
+		iter.Next()
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	next := uint64(0)
+	if iter.Valid() {
+		if len(c.cursors) >= RESP_MAX_CURSORS {
+			clear(c.cursors)
+		}
+		c.nextCursor++
+		next = c.nextCursor
+		c.cursors[next] = bytes.Clone(iter.Key())
+	}
+	out.array(2)
+	out.bulk(strconv.AppendUint(nil, next, 10))
+	out.array(len(keys))
+	for _, key := range keys {
+		out.bulk(key)
+	}
+	return nil
+}
+
+const RESP_MAX_RANGE = 10000
+
+// RANGE start end count, not a redis command.
+// the keys in [start, end) with their values, an empty end means no upper
+// bound. the reply is a flat array of at most count key-value pairs.
+func cmdRange(c *conn, kv kvStore, out *reply, args [][]byte) error {
+	count, err := strconv.Atoi(string(args[3]))
+	if err != nil || count < 1 || count > RESP_MAX_RANGE {
+		out.errorf("ERR value is not an integer or out of range")
+		return nil
+	}
+	end := args[2]
+	if len(end) == 0 {
+		end = nil
+	}
+	iter := kv.Scan(args[1], end)
+	defer iter.Close()
+	results := reply{}
+	n := 0
+	for ; n < count && iter.Valid(); iter.Next() {
+		results.bulk(iter.Key())
+		results.bulk(iter.Val())
+		n++
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	out.array(2 * n)
+	out.buf = append(out.buf, results.buf...)
+	return nil
+}
@@ -0,0 +1,94 @@
+package resp
+
+// glob patterns of SCAN MATCH, in the redis style:
+// * any string, ? any byte, [abc] [a-z] [^a] a set, \ escapes.
+// A star only backtracks to the last star, so the time is bounded by
+// len(pattern) * len(key) whatever the pattern.
+func globMatch(pat []byte, s []byte) bool {
+	star := false
+	var starPat, starS []byte // where to retry after the last star
+	for {
+		if len(pat) > 0 && pat[0] == '*' {
+			pat = pat[1:]
+			star, starPat, starS = true, pat, s
+			continue
+		}
+		if len(pat) > 0 && len(s) > 0 {
+			if ok, rest := globOne(pat, s[0]); ok {
+				pat, s = rest, s[1:]
+				continue
+			}
+		}
+		if len(pat) == 0 && len(s) == 0 {
+			return true
+		}
+		// let the last star consume one more byte
+		if !star || len(starS) == 0 {
+			return false
+		}
+		starS = starS[1:]
+		pat, s = starPat, starS
+	}
+}
+
+// match a byte with the pattern token at the start, returns the rest
+func globOne(pat []byte, c byte) (bool, []byte) {
+	switch pat[0] {
+	case '?':
+		return true, pat[1:]
+	case '[':
+		return globSet(pat[1:], c)
+	case '\\':
+		if len(pat) > 1 {
+			pat = pat[1:]
+		}
+	}
+	return pat[0] == c, pat[1:]
+}
+
+// the pattern after the '['
+func globSet(pat []byte, c byte) (bool, []byte) {
+	not := len(pat) > 0 && pat[0] == '^'
+	if not {
+		pat = pat[1:]
+	}
+	match := false
+	for len(pat) > 0 && pat[0] != ']' {
+		if pat[0] == '\\' && len(pat) > 1 {
+			pat = pat[1:]
+		}
+		lo, hi := pat[0], pat[0]
+		if len(pat) > 2 && pat[1] == '-' && pat[2] != ']' {
+			hi = pat[2]
+			pat = pat[2:]
+		}
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		if lo <= c && c <= hi {
+			match = true
+		}
+		pat = pat[1:]
+	}
+	if len(pat) > 0 {
+		pat = pat[1:] // the ']'
+	}
+	return match != not, pat
+}
+
+// the literal prefix of a pattern, all the matching keys begin with it
+func globPrefix(pat []byte) []byte {
+	prefix := []byte{}
+	for i := 0; i < len(pat); i++ {
+		switch pat[i] {
+		case '*', '?', '[':
+			return prefix
+		case '\\':
+			if i+1 < len(pat) {
+				i++
+			}
+		}
+		prefix = append(prefix, pat[i])
+	}
+	return prefix
+}
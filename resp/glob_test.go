@@ -0,0 +1,95 @@
+package resp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGlobMatch(t *testing.T) {
+	cases := []struct {
+		pat, s string
+		match  bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"abc", "abc", true},
+		{"abc", "abcd", false},
+		{"abc", "ab", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"**", "x", true},
+		{"a*", "a", true},
+		{"a*", "abc", true},
+		{"a*", "ba", false},
+		{"*c", "abc", true},
+		{"*c", "abcd", false},
+		{"a*c", "ac", true},
+		{"a*c", "abcbc", true},
+		{"a*c", "abcb", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxcyyb", false},
+		{"*a*a*", "banana", true},
+		{"?", "", false},
+		{"?", "a", true},
+		{"?", "\x00", true},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"h?llo*", "hello world", true},
+		{"[abc]", "b", true},
+		{"[abc]", "d", false},
+		{"[a-c]x", "bx", true},
+		{"[a-c]x", "dx", false},
+		{"[c-a]", "b", true}, // a reversed range
+		{"[^a-c]", "b", false},
+		{"[^a-c]", "d", true},
+		{"[a-]", "-", true},
+		{"[]", "a", false},
+		{"[^]", "a", true},
+		{"[\\]]", "]", true},
+		{"[a", "a", true}, // not closed
+		{"user:[0-9][0-9]", "user:42", true},
+		{"user:[0-9][0-9]", "user:4x", false},
+		{"\\*", "*", true},
+		{"\\*", "a", false},
+		{"a\\?", "a?", true},
+		{"a\\?", "ab", false},
+		{"\\[a]", "[a]", true},
+		{"a\\", "a\\", true},
+		{"\xff*", "\xff\x00", true},
+	}
+	for _, tc := range cases {
+		if got := globMatch([]byte(tc.pat), []byte(tc.s)); got != tc.match {
+			t.Fatalf("%q %q: %v", tc.pat, tc.s, got)
+		}
+	}
+}
+
+// the stars don't backtrack beyond the last one
+func TestGlobMatchLong(t *testing.T) {
+	s := []byte(strings.Repeat("a", 10000))
+	pat := []byte(strings.Repeat("*a", 50) + "b")
+	if globMatch(pat, s) {
+		t.Fatal("matched")
+	}
+	if !globMatch([]byte(strings.Repeat("*a", 50)), s) {
+		t.Fatal("not matched")
+	}
+}
+
+func TestGlobPrefix(t *testing.T) {
+	cases := []struct{ pat, prefix string }{
+		{"", ""},
+		{"*", ""},
+		{"abc", "abc"},
+		{"user:*", "user:"},
+		{"user:?1", "user:"},
+		{"user:[12]", "user:"},
+		{"a\\*b*", "a*b"},
+		{"a\\", "a\\"},
+	}
+	for _, tc := range cases {
+		if got := string(globPrefix([]byte(tc.pat))); got != tc.prefix {
+			t.Fatalf("%q: %q, want %q", tc.pat, got, tc.prefix)
+		}
+	}
+}
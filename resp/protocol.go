@@ -0,0 +1,147 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// The subset of RESP2 spoken by redis clients.
+// A command is an array of bulk strings:
+//
+//	*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n
+//
+// or an inline command, a line of words separated by spaces, as typed
+// in telnet. The replies are simple strings, errors, integers, bulk
+// strings and arrays.
+
+const RESP_MAX_ARGS = 1 << 20
+const RESP_MAX_BULK = 64<<20 + 1024 // a bit more than the largest value
+const RESP_MAX_INLINE = 64 << 10    // also limits the array and bulk headers
+
+// a malformed request, the connection is closed after the reply
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "Protocol error: " + string(e)
+}
+
+// a line without the \r\n
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, protocolError("too big inline request")
+	}
+	if err != nil {
+		return nil, err
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+// the number after the type byte of a header
+func readHeader(r *bufio.Reader, typ byte, max int) (int, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return 0, err
+	}
+	if len(line) == 0 || line[0] != typ {
+		return 0, protocolError("expected '" + string(typ) + "', got '" + string(line) + "'")
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > max {
+		return 0, protocolError("invalid length")
+	}
+	return n, nil
+}
+
+// the next command, nil for an empty inline command
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if b[0] != '*' {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.Fields(bytes.Clone(line)), nil
+	}
+
+	n, err := readHeader(r, '*', RESP_MAX_ARGS)
+	if err != nil {
+		return nil, err
+	}
+	args := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		size, err := readHeader(r, '$', RESP_MAX_BULK)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, protocolError("bulk string not terminated")
+		}
+		args = append(args, buf[:size])
+	}
+	return args, nil
+}
+
+// reply accumulates the encoded replies
+type reply struct {
+	buf []byte
+}
+
+func (out *reply) simple(s string) {
+	out.buf = append(out.buf, '+')
+	out.buf = append(out.buf, s...)
+	out.buf = append(out.buf, "\r\n"...)
+}
+
+// the message begins with an error code such as ERR
+func (out *reply) errorf(msg string) {
+	out.buf = append(out.buf, '-')
+	// no line breaks in the error messages
+	for _, c := range []byte(msg) {
+		if c == '\r' || c == '\n' {
+			c = ' '
+		}
+		out.buf = append(out.buf, c)
+	}
+	out.buf = append(out.buf, "\r\n"...)
+}
+
+func (out *reply) int(n int64) {
+	out.buf = append(out.buf, ':')
+	out.buf = strconv.AppendInt(out.buf, n, 10)
+	out.buf = append(out.buf, "\r\n"...)
+}
+
+func (out *reply) bulk(b []byte) {
+	out.buf = append(out.buf, '$')
+	out.buf = strconv.AppendInt(out.buf, int64(len(b)), 10)
+	out.buf = append(out.buf, "\r\n"...)
+	out.buf = append(out.buf, b...)
+	out.buf = append(out.buf, "\r\n"...)
+}
+
+// the null bulk string, a missing key
+func (out *reply) null() {
+	out.buf = append(out.buf, "$-1\r\n"...)
+}
+
+// followed by n replies
+func (out *reply) array(n int) {
+	out.buf = append(out.buf, '*')
+	out.buf = strconv.AppendInt(out.buf, int64(n), 10)
+	out.buf = append(out.buf, "\r\n"...)
+}
@@ -0,0 +1,162 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"killerDB/btree"
+)
+
+// Server serves a KV to redis clients.
+// Each connection has its own goroutine. The commands outside MULTI are
+// run in their own transaction or snapshot, a MULTI ... EXEC block is run
+// in a single transaction.
+type Server struct {
+	KV *btree.KV
+	// internals
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup // the connections
+	started   time.Time
+	stats     struct {
+		connections atomic.Int64 // accepted
+		commands    atomic.Int64 // processed
+	}
+}
+
+var ErrServerClosed = errors.New("resp: server closed")
+
+// Listen opens a TCP address, or a Unix socket with the "unix:" prefix.
+// A stale Unix socket left by a killed server is removed.
+func Listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if nc, err := net.Dial("unix", path); err != nil {
+				_ = os.Remove(path)
+			} else {
+				_ = nc.Close()
+			}
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// Serve accepts the connections until the server is closed,
+// it always returns an error and closes the listener.
+func (srv *Server) Serve(ln net.Listener) error {
+	srv.mu.Lock()
+	if srv.closed {
+		srv.mu.Unlock()
+		_ = ln.Close()
+		return ErrServerClosed
+	}
+	if srv.listeners == nil {
+		srv.listeners = map[net.Listener]struct{}{}
+		srv.conns = map[net.Conn]struct{}{}
+		srv.started = time.Now()
+	}
+	srv.listeners[ln] = struct{}{}
+	srv.mu.Unlock()
+
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.listeners, ln)
+		srv.mu.Unlock()
+		_ = ln.Close()
+	}()
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			srv.mu.Lock()
+			closed := srv.closed
+			srv.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(10 * time.Millisecond) // too many open files, etc.
+				continue
+			}
+			return err
+		}
+		srv.mu.Lock()
+		if srv.closed {
+			srv.mu.Unlock()
+			_ = nc.Close()
+			return ErrServerClosed
+		}
+		srv.conns[nc] = struct{}{}
+		srv.wg.Add(1)
+		srv.mu.Unlock()
+		srv.stats.connections.Add(1)
+		go srv.serveConn(nc)
+	}
+}
+
+// Close stops the listeners and the connections, and waits for them.
+// The commands in progress are finished, the KV is not closed.
+func (srv *Server) Close() error {
+	srv.mu.Lock()
+	srv.closed = true
+	for ln := range srv.listeners {
+		_ = ln.Close()
+	}
+	for nc := range srv.conns {
+		_ = nc.Close()
+	}
+	srv.mu.Unlock()
+	srv.wg.Wait()
+	return nil
+}
+
+func (srv *Server) serveConn(nc net.Conn) {
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.conns, nc)
+		srv.mu.Unlock()
+		_ = nc.Close()
+		srv.wg.Done()
+	}()
+	c := &conn{srv: srv, cursors: map[uint64][]byte{}}
+	r := bufio.NewReaderSize(nc, RESP_MAX_INLINE)
+	w := bufio.NewWriter(nc)
+	for {
+		args, err := readCommand(r)
+		var perr protocolError
+		if errors.As(err, &perr) {
+			out := reply{}
+			out.errorf("ERR " + perr.Error())
+			_, _ = w.Write(out.buf)
+			_ = w.Flush()
+			return
+		}
+		if err != nil {
+			return // closed by the client
+		}
+		if len(args) == 0 {
+			continue
+		}
+		out := reply{}
+		quit := c.dispatch(&out, args)
+		srv.stats.commands.Add(1)
+		if _, err := w.Write(out.buf); err != nil {
+			return
+		}
+		// pipelined commands are answered together
+		if quit || r.Buffered() == 0 {
+			if err := w.Flush(); err != nil || quit {
+				return
+			}
+		}
+	}
+}
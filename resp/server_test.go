@@ -0,0 +1,509 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"killerDB/btree"
+)
+
+// a Server on a loopback listener
+func startServer(t *testing.T) string {
+	t.Helper()
+	db := &btree.KV{Path: filepath.Join(t.TempDir(), "db")}
+	if err := db.Open(); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{KV: db}
+	go srv.Serve(ln)
+	t.Cleanup(func() {
+		srv.Close()
+		db.Close()
+	})
+	return ln.Addr().String()
+}
+
+type testConn struct {
+	t  *testing.T
+	nc net.Conn
+	r  *bufio.Reader
+}
+
+func dial(t *testing.T, addr string) *testConn {
+	t.Helper()
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { nc.Close() })
+	_ = nc.SetDeadline(time.Now().Add(10 * time.Second))
+	return &testConn{t: t, nc: nc, r: bufio.NewReader(nc)}
+}
+
+// a command as an array of bulk strings
+func encodeCommand(args ...string) string {
+	s := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		s += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return s
+}
+
+func (c *testConn) write(data string) {
+	c.t.Helper()
+	if _, err := io.WriteString(c.nc, data); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// the reply as +simple, -error, :int, "bulk", nil or [a b]
+func (c *testConn) read() string {
+	c.t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("read: %v", err)
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		c.t.Fatalf("not terminated: %q", line)
+	}
+	line = line[:len(line)-2]
+	switch line[0] {
+	case '+', '-', ':':
+		return line
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			c.t.Fatalf("bad bulk: %q", line)
+		}
+		if n < 0 {
+			return "nil"
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil || string(buf[n:]) != "\r\n" {
+			c.t.Fatalf("bulk: %q %v", buf, err)
+		}
+		return strconv.Quote(string(buf[:n]))
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			c.t.Fatalf("bad array: %q", line)
+		}
+		items := []string{}
+		for i := 0; i < n; i++ {
+			items = append(items, c.read())
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	}
+	c.t.Fatalf("bad reply: %q", line)
+	return ""
+}
+
+func (c *testConn) do(args ...string) string {
+	c.t.Helper()
+	c.write(encodeCommand(args...))
+	return c.read()
+}
+
+// run the commands and check the replies
+func (c *testConn) check(cases [][2]string) {
+	c.t.Helper()
+	for _, tc := range cases {
+		if got := c.do(strings.Fields(tc[0])...); got != tc[1] {
+			c.t.Fatalf("%s: %s, want %s", tc[0], got, tc[1])
+		}
+	}
+}
+
+// the connection is closed by the server
+func (c *testConn) checkClosed() {
+	c.t.Helper()
+	if data, err := io.ReadAll(c.r); err != nil || len(data) != 0 {
+		c.t.Fatalf("not closed: %q %v", data, err)
+	}
+}
+
+func TestGetSet(t *testing.T) {
+	c := dial(t, startServer(t))
+	c.check([][2]string{
+		{"PING", "+PONG"},
+		{"PING hi", `"hi"`},
+		{"ECHO hello", `"hello"`},
+		{"GET k", "nil"},
+		{"SET k v1", "+OK"},
+		{"GET k", `"v1"`},
+		{"set k v2", "+OK"},
+		{"get k", `"v2"`},
+		// NX: only if missing
+		{"SET k v3 NX", "nil"},
+		{"GET k", `"v2"`},
+		{"SET n v1 nx", "+OK"},
+		{"GET n", `"v1"`},
+		// XX: only if present
+		{"SET m v1 XX", "nil"},
+		{"GET m", "nil"},
+		{"SET k v3 XX", "+OK"},
+		{"GET k", `"v3"`},
+		// GET: the old value
+		{"SET k v4 GET", `"v3"`},
+		{"SET g v1 GET", "nil"},
+		{"GET g", `"v1"`},
+		{"SET k v5 NX GET", `"v4"`},
+		{"GET k", `"v4"`},
+		{"SET k v5 XX GET", `"v4"`},
+		{"SET x v1 XX GET", "nil"},
+		{"GET x", "nil"},
+		{"SET k v6 GET NX", `"v5"`},
+		{"GET k", `"v5"`},
+		// errors
+		{"SET k v NX XX", "-ERR syntax error"},
+		{"SET k v GET GET", "-ERR syntax error"},
+		{"SET k v EX 10", "-ERR syntax error"},
+		{"GET k", `"v5"`},
+		{"SET k", "-ERR wrong number of arguments for 'set' command"},
+		{"GET", "-ERR wrong number of arguments for 'get' command"},
+		{"GET a b", "-ERR wrong number of arguments for 'get' command"},
+		{"HGET k f", "-ERR unknown command 'HGET'"},
+	})
+	// the keys and values are binary
+	if got := c.do("SET", "a\x00\r\nb", "\r\n\x00"); got != "+OK" {
+		t.Fatal(got)
+	}
+	if got := c.do("GET", "a\x00\r\nb"); got != strconv.Quote("\r\n\x00") {
+		t.Fatal(got)
+	}
+	if got := c.do("SET", "", "v"); got != "-ERR "+btree.ErrEmptyKey.Error() {
+		t.Fatal(got)
+	}
+	if got := c.do("QUIT"); got != "+OK" {
+		t.Fatal(got)
+	}
+	c.checkClosed()
+}
+
+func TestDelExists(t *testing.T) {
+	c := dial(t, startServer(t))
+	c.check([][2]string{
+		{"SET a 1", "+OK"},
+		{"SET b 2", "+OK"},
+		{"EXISTS a", ":1"},
+		{"EXISTS a b c", ":2"},
+		{"EXISTS a a", ":2"},
+		{"EXISTS c", ":0"},
+		{"DEL a c", ":1"},
+		{"EXISTS a b", ":1"},
+		{"DEL a", ":0"},
+		{"DEL b b", ":1"},
+		{"EXISTS a b", ":0"},
+		{"DEL", "-ERR wrong number of arguments for 'del' command"},
+		{"EXISTS", "-ERR wrong number of arguments for 'exists' command"},
+	})
+}
+
+// the keys of all the pages of a SCAN, and the number of pages
+func scanAll(c *testConn, opts ...string) ([]string, int) {
+	c.t.Helper()
+	keys, pages := []string{}, 0
+	cursor := "0"
+	for {
+		var page string
+		cursor, page = parseScan(c, c.do(append([]string{"SCAN", cursor}, opts...)...))
+		pages++
+		if page != "" {
+			keys = append(keys, strings.Fields(page)...)
+		}
+		if cursor == "0" {
+			return keys, pages
+		}
+	}
+}
+
+// the cursor and the keys of a SCAN reply: ["cursor" [keys]]
+func parseScan(c *testConn, reply string) (string, string) {
+	c.t.Helper()
+	head, page, ok := strings.Cut(reply, " [")
+	if !ok || !strings.HasPrefix(head, "[") || !strings.HasSuffix(page, "]]") {
+		c.t.Fatalf("SCAN: %s", reply)
+	}
+	cursor, err := strconv.Unquote(head[1:])
+	if err != nil {
+		c.t.Fatalf("SCAN: %s", reply)
+	}
+	return cursor, page[:len(page)-2]
+}
+
+func TestScan(t *testing.T) {
+	addr := startServer(t)
+	c := dial(t, addr)
+	for i := 0; i < 30; i++ {
+		c.do("SET", fmt.Sprintf("user:%03d", i), "v")
+	}
+	for i := 0; i < 10; i++ {
+		c.do("SET", fmt.Sprintf("other:%d", i), "v")
+	}
+	quoted := func(format string, ids ...int) string {
+		keys := []string{}
+		for _, id := range ids {
+			keys = append(keys, strconv.Quote(fmt.Sprintf(format, id)))
+		}
+		return strings.Join(keys, " ")
+	}
+
+	// all the keys, 10 by default
+	keys, pages := scanAll(c)
+	if len(keys) != 40 || pages != 4 || keys[0] != `"other:0"` || keys[39] != `"user:029"` {
+		t.Fatalf("%d keys in %d pages: %v", len(keys), pages, keys)
+	}
+	// only the range of the prefix is visited
+	keys, pages = scanAll(c, "MATCH", "user:01*", "COUNT", "4")
+	if strings.Join(keys, " ") != quoted("user:%03d", 10, 11, 12, 13, 14, 15, 16, 17, 18, 19) || pages != 3 {
+		t.Fatalf("%d pages: %v", pages, keys)
+	}
+	// the pages can be empty without a prefix
+	keys, pages = scanAll(c, "COUNT", "7", "MATCH", "*5")
+	if strings.Join(keys, " ") != `"other:5" `+quoted("user:%03d", 5, 15, 25) || pages != 6 {
+		t.Fatalf("%d pages: %v", pages, keys)
+	}
+	keys, _ = scanAll(c, "MATCH", "user:00[^0-7]")
+	if strings.Join(keys, " ") != quoted("user:%03d", 8, 9) {
+		t.Fatalf("%v", keys)
+	}
+	keys, _ = scanAll(c, "MATCH", "nothing*")
+	if len(keys) != 0 {
+		t.Fatalf("%v", keys)
+	}
+
+	// the keys added behind the cursor are not returned, the others are
+	cursor, page := parseScan(c, c.do("SCAN", "0", "MATCH", "user:*", "COUNT", "15"))
+	if len(strings.Fields(page)) != 15 {
+		t.Fatal(page)
+	}
+	c.do("SET", "user:000a", "v")
+	c.do("SET", "user:020a", "v")
+	c.do("DEL", "user:021")
+	_, page = parseScan(c, c.do("SCAN", cursor, "MATCH", "user:*", "COUNT", "100"))
+	if fields := strings.Fields(page); len(fields) != 15 || fields[0] != `"user:015"` || fields[6] != `"user:020a"` {
+		t.Fatal(page)
+	}
+	// a cursor is used once
+	c.check([][2]string{
+		{"SCAN " + cursor, "-ERR invalid cursor"},
+		{"SCAN 12345", "-ERR invalid cursor"},
+		{"SCAN -1", "-ERR invalid cursor"},
+		{"SCAN abc", "-ERR invalid cursor"},
+		{"SCAN 0 COUNT 0", "-ERR value is not an integer or out of range"},
+		{"SCAN 0 COUNT x", "-ERR value is not an integer or out of range"},
+		{"SCAN 0 MATCH", "-ERR syntax error"},
+		{"SCAN 0 TYPE string", "-ERR syntax error"},
+		{"SCAN", "-ERR wrong number of arguments for 'scan' command"},
+	})
+
+	// the cursors belong to the connection
+	cursor, _ = parseScan(c, c.do("SCAN", "0", "COUNT", "1"))
+	if got := dial(t, addr).do("SCAN", cursor); got != "-ERR invalid cursor" {
+		t.Fatal(got)
+	}
+}
+
+func TestMulti(t *testing.T) {
+	addr := startServer(t)
+	c := dial(t, addr)
+	c.check([][2]string{
+		{"SET a 1", "+OK"},
+		{"MULTI", "+OK"},
+		{"MULTI", "-ERR MULTI calls can not be nested"},
+		{"GET a", "+QUEUED"},
+		{"SET a 2", "+QUEUED"},
+		{"SET b 3 NX GET", "+QUEUED"},
+		{"DEL a c", "+QUEUED"},
+		{"EXISTS a b", "+QUEUED"},
+		{"SCAN 0", "+QUEUED"},
+	})
+	// not visible before EXEC
+	if got := dial(t, addr).do("GET", "b"); got != "nil" {
+		t.Fatal(got)
+	}
+	c.check([][2]string{
+		{"EXEC", `["1" +OK nil :1 :1 ["0" ["b"]]]`},
+		{"GET b", `"3"`},
+		{"EXEC", "-ERR EXEC without MULTI"},
+		{"DISCARD", "-ERR DISCARD without MULTI"},
+		// an empty transaction
+		{"MULTI", "+OK"},
+		{"EXEC", "[]"},
+	})
+
+	// DISCARD
+	c.check([][2]string{
+		{"MULTI", "+OK"},
+		{"SET d 1", "+QUEUED"},
+		{"DISCARD", "+OK"},
+		{"GET d", "nil"},
+	})
+
+	// EXECABORT: the errors while queuing discard the transaction
+	for _, bad := range []string{"SET d", "NOPE d", "GET"} {
+		c.check([][2]string{
+			{"MULTI", "+OK"},
+			{"SET d 1", "+QUEUED"},
+			{"DEL b", "+QUEUED"},
+		})
+		if got := c.do(strings.Fields(bad)...); !strings.HasPrefix(got, "-ERR") {
+			t.Fatalf("%s: %s", bad, got)
+		}
+		c.check([][2]string{
+			{"SET e 1", "+QUEUED"},
+			{"EXEC", "-EXECABORT Transaction discarded because of previous errors."},
+			{"GET d", "nil"},
+			{"GET b", `"3"`},
+			{"EXISTS e", ":0"},
+		})
+	}
+	// the next transaction is not aborted
+	c.check([][2]string{
+		{"MULTI", "+OK"},
+		{"SET d 1", "+QUEUED"},
+		{"EXEC", "[+OK]"},
+	})
+
+	// the errors of the KV don't stop the other commands
+	c.write("MULTI\r\n" + encodeCommand("SET", "", "v") + "SET f 1\r\nEXEC\r\n")
+	for _, want := range []string{"+OK", "+QUEUED", "+QUEUED"} {
+		if got := c.read(); got != want {
+			t.Fatalf("%s, want %s", got, want)
+		}
+	}
+	if got := c.read(); got != "[-ERR "+btree.ErrEmptyKey.Error()+" +OK]" {
+		t.Fatal(got)
+	}
+	c.check([][2]string{{"GET f", `"1"`}})
+}
+
+func TestInline(t *testing.T) {
+	c := dial(t, startServer(t))
+	// the empty lines have no reply
+	c.write("PING\r\n\r\n   \r\nSET k  inline\r\nGET k\nECHO\thello\r\n")
+	for _, want := range []string{"+PONG", "+OK", `"inline"`, `"hello"`} {
+		if got := c.read(); got != want {
+			t.Fatalf("%s, want %s", got, want)
+		}
+	}
+	c.check([][2]string{{"EXISTS k", ":1"}})
+	c.write("quit\r\n")
+	if got := c.read(); got != "+OK" {
+		t.Fatal(got)
+	}
+	c.checkClosed()
+}
+
+func TestPipeline(t *testing.T) {
+	addr := startServer(t)
+	c := dial(t, addr)
+	var b strings.Builder
+	for i := 0; i < 500; i++ {
+		b.WriteString(encodeCommand("SET", fmt.Sprintf("k%03d", i), fmt.Sprint(i)))
+		b.WriteString(fmt.Sprintf("GET k%03d\r\n", i))
+	}
+	b.WriteString(encodeCommand("EXISTS", "k000", "k499", "k500"))
+	c.write(b.String())
+	for i := 0; i < 500; i++ {
+		if got := c.read(); got != "+OK" {
+			t.Fatalf("SET %d: %s", i, got)
+		}
+		if got := c.read(); got != strconv.Quote(fmt.Sprint(i)) {
+			t.Fatalf("GET %d: %s", i, got)
+		}
+	}
+	if got := c.read(); got != ":2" {
+		t.Fatal(got)
+	}
+
+	// the commands before QUIT are answered, the ones after are not run
+	c.write(encodeCommand("DEL", "k000") + "QUIT\r\n" + encodeCommand("DEL", "k001"))
+	if got := c.read(); got != ":1" {
+		t.Fatal(got)
+	}
+	if got := c.read(); got != "+OK" {
+		t.Fatal(got)
+	}
+	c.checkClosed()
+	if got := dial(t, addr).do("EXISTS", "k000", "k001"); got != ":1" {
+		t.Fatal(got)
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	addr := startServer(t)
+	cases := []struct{ data, err string }{
+		{"*2\r\n$3\r\nGET\r\n:1\r\n", "expected '$', got ':1'"},
+		{"*1\r\n3\r\nGET\r\n", "expected '$', got '3'"},
+		{"*x\r\n", "invalid length"},
+		{"*-1\r\n", "invalid length"},
+		{"*\r\n", "invalid length"},
+		{"*1\r\n$-1\r\n", "invalid length"},
+		{"*1\r\n$99999999999\r\n", "invalid length"},
+		{"*1\r\n$3\r\nGETX\r\n", "bulk string not terminated"},
+		{"*1\r\n$3\r\nGET\n\r\n", "bulk string not terminated"},
+		// all the bytes are read, or the close would reset the connection
+		{strings.Repeat("a", RESP_MAX_INLINE), "too big inline request"},
+	}
+	for _, tc := range cases {
+		c := dial(t, addr)
+		// the command before is answered
+		c.write("PING\r\n")
+		go io.WriteString(c.nc, tc.data)
+		if got := c.read(); got != "+PONG" {
+			t.Fatal(got)
+		}
+		if got := c.read(); got != "-ERR Protocol error: "+tc.err {
+			t.Fatalf("%q: %s", tc.data, got)
+		}
+		c.checkClosed()
+	}
+
+	// a truncated command is not run
+	c := dial(t, addr)
+	c.write("*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\n")
+	c.nc.(*net.TCPConn).CloseWrite()
+	c.checkClosed()
+	if got := dial(t, addr).do("EXISTS", "k"); got != ":0" {
+		t.Fatal(got)
+	}
+}
+
+func TestClose(t *testing.T) {
+	db := &btree.KV{Path: filepath.Join(t.TempDir(), "db")}
+	if err := db.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ln, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{KV: db}
+	done := make(chan error)
+	go func() { done <- srv.Serve(ln) }()
+	c := dial(t, ln.Addr().String())
+	c.check([][2]string{{"PING", "+PONG"}})
+
+	srv.Close()
+	if err := <-done; !errors.Is(err, ErrServerClosed) {
+		t.Fatal(err)
+	}
+	c.checkClosed()
+	if err := srv.Serve(ln); !errors.Is(err, ErrServerClosed) {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,98 @@
+package sql
+
+import "killerDB/table"
+
+// expression operators
+const (
+	OP_COL = 1 // column reference
+	OP_LIT = 2 // literal value
+	// unary
+	OP_NOT = 10
+	OP_NEG = 11
+	// binary
+	OP_AND = 20
+	OP_OR  = 21
+	OP_EQ  = 22
+	OP_NE  = 23
+	OP_LT  = 24
+	OP_LE  = 25
+	OP_GT  = 26
+	OP_GE  = 27
+	OP_ADD = 28
+	OP_SUB = 29
+	OP_MUL = 30
+	OP_DIV = 31
+	OP_MOD = 32
+)
+
+type Expr struct {
+	Op    int
+	Col   string      // OP_COL
+	Val   table.Value // OP_LIT
+	Left  *Expr       // unary and binary operators
+	Right *Expr       // binary operators
+	Text  string      // the source text, used as the output column name
+}
+
+// statements
+type Stmt interface {
+	stmt()
+}
+
+type CreateTable struct {
+	Def table.TableDef
+}
+
+type CreateIndex struct {
+	Table string
+	Cols  []string
+}
+
+type Insert struct {
+	Table string
+	Cols  []string
+	Rows  [][]*Expr
+}
+
+type OrderBy struct {
+	Col  string
+	Desc bool
+}
+
+type Select struct {
+	Table   string
+	Exprs   []*Expr // nil for *
+	Where   *Expr   // nil if absent
+	OrderBy []OrderBy
+	Limit   int64 // -1 if absent
+	Offset  int64
+}
+
+type Assign struct {
+	Col   string
+	Value *Expr
+}
+
+type Update struct {
+	Table string
+	Set   []Assign
+	Where *Expr
+}
+
+type Delete struct {
+	Table string
+	Where *Expr
+}
+
+// show the chosen plan of a statement instead of running it
+type Explain struct {
+	Stmt Stmt
+}
+
+func (*CreateTable) stmt() {}
+func (*CreateIndex) stmt() {}
+func (*Insert) stmt()      {}
+func (*Select) stmt()      {}
+func (*Update) stmt()      {}
+func (*Delete) stmt()      {}
+func (*Explain) stmt()     {}
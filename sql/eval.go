@@ -0,0 +1,166 @@
+package sql
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"killerDB/table"
+)
+
+// booleans are int64 0 and 1
+func boolValue(b bool) table.Value {
+	if b {
+		return table.Value{Type: table.TYPE_INT64, I64: 1}
+	}
+	return table.Value{Type: table.TYPE_INT64, I64: 0}
+}
+
+func isString(v table.Value) bool {
+	return v.Type == table.TYPE_STRING || v.Type == table.TYPE_BYTES
+}
+
+func truth(v table.Value) (bool, error) {
+	if v.Type != table.TYPE_INT64 {
+		return false, errors.New("expect a boolean (int64) value")
+	}
+	return v.I64 != 0, nil
+}
+
+// compare 2 values of compatible types, strings and bytes are compatible
+func compareValues(a table.Value, b table.Value) (int, error) {
+	switch {
+	case a.Type == table.TYPE_INT64 && b.Type == table.TYPE_INT64:
+		switch {
+		case a.I64 < b.I64:
+			return -1, nil
+		case a.I64 > b.I64:
+			return +1, nil
+		}
+		return 0, nil
+	case isString(a) && isString(b):
+		return bytes.Compare(a.Str, b.Str), nil
+	}
+	return 0, errors.New("comparing values of different types")
+}
+
+// evaluate an expression on a row, the row is nil for constants
+func eval(expr *Expr, row *table.Record) (table.Value, error) {
+	switch expr.Op {
+	case OP_LIT:
+		return expr.Val, nil
+	case OP_COL:
+		var v *table.Value
+		if row != nil {
+			v = row.Get(expr.Col)
+		}
+		if v == nil {
+			return table.Value{}, fmt.Errorf("unknown column: %s", expr.Col)
+		}
+		return *v, nil
+	}
+
+	left, err := eval(expr.Left, row)
+	if err != nil {
+		return table.Value{}, err
+	}
+	switch expr.Op {
+	case OP_NOT:
+		b, err := truth(left)
+		return boolValue(!b), err
+	case OP_NEG:
+		if left.Type != table.TYPE_INT64 {
+			return table.Value{}, fmt.Errorf("%s: expect int64", expr.Text)
+		}
+		return table.Value{Type: table.TYPE_INT64, I64: -left.I64}, nil
+	case OP_AND, OP_OR:
+		// short-circuit
+		b, err := truth(left)
+		if err != nil || b == (expr.Op == OP_OR) {
+			return boolValue(b), err
+		}
+		right, err := eval(expr.Right, row)
+		if err != nil {
+			return table.Value{}, err
+		}
+		b, err = truth(right)
+		return boolValue(b), err
+	}
+
+	right, err := eval(expr.Right, row)
+	if err != nil {
+		return table.Value{}, err
+	}
+	switch expr.Op {
+	case OP_EQ, OP_NE, OP_LT, OP_LE, OP_GT, OP_GE:
+		r, err := compareValues(left, right)
+		if err != nil {
+			return table.Value{}, fmt.Errorf("%s: %w", expr.Text, err)
+		}
+		return boolValue(cmpResult(expr.Op, r)), nil
+	}
+
+	// arithmetic
+	if left.Type != table.TYPE_INT64 || right.Type != table.TYPE_INT64 {
+		return table.Value{}, fmt.Errorf("%s: expect int64", expr.Text)
+	}
+	out := table.Value{Type: table.TYPE_INT64}
+	switch expr.Op {
+	case OP_ADD:
+		out.I64 = left.I64 + right.I64
+	case OP_SUB:
+		out.I64 = left.I64 - right.I64
+	case OP_MUL:
+		out.I64 = left.I64 * right.I64
+	case OP_DIV, OP_MOD:
+		if right.I64 == 0 {
+			return table.Value{}, fmt.Errorf("%s: division by zero", expr.Text)
+		}
+		if expr.Op == OP_DIV {
+			out.I64 = left.I64 / right.I64
+		} else {
+			out.I64 = left.I64 % right.I64
+		}
+	default:
+		panic("unreachable")
+	}
+	return out, nil
+}
+
+func cmpResult(op int, r int) bool {
+	switch op {
+	case OP_EQ:
+		return r == 0
+	case OP_NE:
+		return r != 0
+	case OP_LT:
+		return r < 0
+	case OP_LE:
+		return r <= 0
+	case OP_GT:
+		return r > 0
+	case OP_GE:
+		return r >= 0
+	}
+	panic("unreachable")
+}
+
+// expressions without column references
+func isConst(expr *Expr) bool {
+	if expr == nil {
+		return true
+	}
+	return expr.Op != OP_COL && isConst(expr.Left) && isConst(expr.Right)
+}
+
+// convert a value to the column type, strings and bytes are interchangeable
+func coerce(v table.Value, typ uint32) (table.Value, bool) {
+	if v.Type == typ {
+		return v, true
+	}
+	if isString(v) && (typ == table.TYPE_STRING || typ == table.TYPE_BYTES) {
+		v.Type = typ
+		return v, true
+	}
+	return v, false
+}
@@ -0,0 +1,425 @@
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"killerDB/table"
+)
+
+// Result of a statement. SELECT and EXPLAIN return rows,
+// the other statements return the number of affected rows.
+type Result struct {
+	Cols     []string
+	Rows     [][]table.Value
+	Affected int
+}
+
+// Exec runs the statements in the input, each in its own transaction.
+// The results of the completed statements are returned along with the
+// first error.
+func Exec(db *table.DB, src string) ([]Result, error) {
+	stmts, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	results := []Result{}
+	for _, stmt := range stmts {
+		res, err := ExecStmt(db, stmt)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// run a single statement, reads run on a snapshot
+func ExecStmt(db *table.DB, stmt Stmt) (Result, error) {
+	if sel, ok := stmt.(*Select); ok {
+		reader := db.BeginRead()
+		defer db.EndRead(reader)
+		return execSelect(reader, sel)
+	}
+	if exp, ok := stmt.(*Explain); ok {
+		reader := db.BeginRead()
+		defer db.EndRead(reader)
+		return execExplain(reader, exp)
+	}
+	tx := db.Begin()
+	res, err := ExecTx(tx, stmt)
+	if err != nil {
+		tx.Abort()
+		return Result{}, err
+	}
+	return res, tx.Commit()
+}
+
+// run a statement inside a transaction, the caller commits or aborts it
+func ExecTx(tx *table.DBTX, stmt Stmt) (Result, error) {
+	switch stmt := stmt.(type) {
+	case *CreateTable:
+		def := stmt.Def
+		return Result{}, tx.CreateTable(&def)
+	case *CreateIndex:
+		return Result{}, tx.CreateIndex(stmt.Table, stmt.Cols)
+	case *Insert:
+		return execInsert(tx, stmt)
+	case *Select:
+		return execSelect(tx, stmt)
+	case *Update:
+		return execUpdate(tx, stmt)
+	case *Delete:
+		return execDelete(tx, stmt)
+	case *Explain:
+		return execExplain(tx, stmt)
+	}
+	panic("unreachable")
+}
+
+// the table operations used by queries,
+// implemented by both table.DBReader and table.DBTX.
+type tableReader interface {
+	TableDef(name string) (*table.TableDef, error)
+	Scan(name string, req *table.Scanner) error
+}
+
+func tableDef(db tableReader, name string) (*table.TableDef, error) {
+	tdef, err := db.TableDef(name)
+	if err == nil && tdef == nil {
+		err = fmt.Errorf("table not found: %s", name)
+	}
+	return tdef, err
+}
+
+func execInsert(tx *table.DBTX, stmt *Insert) (Result, error) {
+	tdef, err := tableDef(tx, stmt.Table)
+	if err != nil {
+		return Result{}, err
+	}
+	cols := stmt.Cols
+	if cols == nil {
+		cols = tdef.Cols
+	}
+	if len(cols) != len(tdef.Cols) {
+		return Result{}, fmt.Errorf("%s: expect values for all %d columns", tdef.Name, len(tdef.Cols))
+	}
+	for i, col := range cols {
+		if colPos(tdef, col) < 0 {
+			return Result{}, fmt.Errorf("%s: unknown column: %s", tdef.Name, col)
+		}
+		if containsStr(cols[:i], col) {
+			return Result{}, fmt.Errorf("%s: duplicated column: %s", tdef.Name, col)
+		}
+	}
+
+	res := Result{}
+	for _, row := range stmt.Rows {
+		if len(row) != len(cols) {
+			return Result{}, fmt.Errorf("%s: expect %d values, got %d", tdef.Name, len(cols), len(row))
+		}
+		rec := table.Record{}
+		for i, expr := range row {
+			val, err := eval(expr, nil)
+			if err != nil {
+				return Result{}, err
+			}
+			if val, err = columnValue(tdef, cols[i], val); err != nil {
+				return Result{}, err
+			}
+			rec.Cols = append(rec.Cols, cols[i])
+			rec.Vals = append(rec.Vals, val)
+		}
+		added, err := tx.Insert(tdef.Name, rec)
+		if err != nil {
+			return Result{}, err
+		}
+		if !added {
+			return Result{}, fmt.Errorf("%s: duplicated primary key", tdef.Name)
+		}
+		res.Affected++
+	}
+	return res, nil
+}
+
+// convert a value to the type of the column
+func columnValue(tdef *table.TableDef, col string, val table.Value) (table.Value, error) {
+	out, ok := coerce(val, tdef.Types[colPos(tdef, col)])
+	if !ok {
+		return val, fmt.Errorf("%s: bad type of column: %s", tdef.Name, col)
+	}
+	return out, nil
+}
+
+// fetch the rows matching the WHERE clause
+func queryRows(db tableReader, tdef *table.TableDef, where *Expr, fn func(table.Record) (bool, error)) error {
+	p := makePlan(tdef, where)
+	sc := p.scan
+	if err := db.Scan(tdef.Name, &sc); err != nil {
+		return err
+	}
+	defer sc.Close()
+	for ; sc.Valid(); sc.Next() {
+		rec := table.Record{}
+		if err := sc.Deref(&rec); err != nil {
+			return err
+		}
+		if where != nil {
+			val, err := eval(where, &rec)
+			if err != nil {
+				return err
+			}
+			ok, err := truth(val)
+			if err != nil {
+				return fmt.Errorf("WHERE: %w", err)
+			}
+			if !ok {
+				continue
+			}
+		}
+		more, err := fn(rec)
+		if err != nil || !more {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+func execSelect(db tableReader, stmt *Select) (Result, error) {
+	tdef, err := tableDef(db, stmt.Table)
+	if err != nil {
+		return Result{}, err
+	}
+	exprs := stmt.Exprs
+	if exprs == nil {
+		for _, col := range tdef.Cols {
+			exprs = append(exprs, &Expr{Op: OP_COL, Col: col, Text: col})
+		}
+	}
+	for _, item := range stmt.OrderBy {
+		if colPos(tdef, item.Col) < 0 {
+			return Result{}, fmt.Errorf("%s: unknown column: %s", tdef.Name, item.Col)
+		}
+	}
+
+	// without ORDER BY the scan stops at the limit
+	rows := []table.Record{}
+	skip, limit := stmt.Offset, stmt.Limit
+	err = queryRows(db, tdef, stmt.Where, func(rec table.Record) (bool, error) {
+		if len(stmt.OrderBy) > 0 {
+			rows = append(rows, rec)
+			return true, nil
+		}
+		if skip > 0 {
+			skip--
+			return true, nil
+		}
+		if limit == 0 {
+			return false, nil
+		}
+		rows = append(rows, rec)
+		limit--
+		return limit != 0, nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	if len(stmt.OrderBy) > 0 {
+		if rows, err = sortRows(rows, stmt.OrderBy); err != nil {
+			return Result{}, err
+		}
+		rows = limitRows(rows, stmt.Offset, stmt.Limit)
+	}
+
+	res := Result{}
+	for _, expr := range exprs {
+		res.Cols = append(res.Cols, expr.Text)
+	}
+	for i := range rows {
+		out := make([]table.Value, len(exprs))
+		for j, expr := range exprs {
+			if out[j], err = eval(expr, &rows[i]); err != nil {
+				return Result{}, err
+			}
+		}
+		res.Rows = append(res.Rows, out)
+	}
+	return res, nil
+}
+
+func sortRows(rows []table.Record, order []OrderBy) ([]table.Record, error) {
+	var err error
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, item := range order {
+			r, e := compareValues(*rows[i].Get(item.Col), *rows[j].Get(item.Col))
+			if e != nil {
+				err = e
+				return false
+			}
+			if item.Desc {
+				r = -r
+			}
+			if r != 0 {
+				return r < 0
+			}
+		}
+		return false
+	})
+	return rows, err
+}
+
+func limitRows(rows []table.Record, offset int64, limit int64) []table.Record {
+	if offset >= int64(len(rows)) {
+		return nil
+	}
+	rows = rows[offset:]
+	if limit >= 0 && limit < int64(len(rows)) {
+		rows = rows[:limit]
+	}
+	return rows
+}
+
+func execUpdate(tx *table.DBTX, stmt *Update) (Result, error) {
+	tdef, err := tableDef(tx, stmt.Table)
+	if err != nil {
+		return Result{}, err
+	}
+	for i, assign := range stmt.Set {
+		if colPos(tdef, assign.Col) < 0 {
+			return Result{}, fmt.Errorf("%s: unknown column: %s", tdef.Name, assign.Col)
+		}
+		for _, prev := range stmt.Set[:i] {
+			if prev.Col == assign.Col {
+				return Result{}, fmt.Errorf("%s: duplicated column: %s", tdef.Name, assign.Col)
+			}
+		}
+	}
+
+	// the scanner is invalidated by updates, collect the new rows first
+	type change struct {
+		old table.Record
+		new table.Record
+	}
+	changes := []change{}
+	err = queryRows(tx, tdef, stmt.Where, func(rec table.Record) (bool, error) {
+		updated := table.Record{
+			Cols: rec.Cols,
+			Vals: append([]table.Value{}, rec.Vals...),
+		}
+		for _, assign := range stmt.Set {
+			val, err := eval(assign.Value, &rec)
+			if err != nil {
+				return false, err
+			}
+			if val, err = columnValue(tdef, assign.Col, val); err != nil {
+				return false, err
+			}
+			*updated.Get(assign.Col) = val
+		}
+		changes = append(changes, change{old: rec, new: updated})
+		return true, nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	// a row whose primary key is changed is moved
+	for _, c := range changes {
+		if !samePKey(tdef, c.old, c.new) {
+			if _, err := tx.Delete(tdef.Name, pkeyOf(tdef, c.old)); err != nil {
+				return Result{}, err
+			}
+		}
+	}
+	for _, c := range changes {
+		if samePKey(tdef, c.old, c.new) {
+			_, err = tx.Update(tdef.Name, c.new)
+		} else {
+			var added bool
+			added, err = tx.Insert(tdef.Name, c.new)
+			if err == nil && !added {
+				err = fmt.Errorf("%s: duplicated primary key", tdef.Name)
+			}
+		}
+		if err != nil {
+			return Result{}, err
+		}
+	}
+	return Result{Affected: len(changes)}, nil
+}
+
+func pkeyOf(tdef *table.TableDef, rec table.Record) table.Record {
+	return table.Record{Cols: rec.Cols[:tdef.PKeys], Vals: rec.Vals[:tdef.PKeys]}
+}
+
+// the rows from the scanner are in the column order of the table
+func samePKey(tdef *table.TableDef, a table.Record, b table.Record) bool {
+	for i := 0; i < tdef.PKeys; i++ {
+		if r, _ := compareValues(a.Vals[i], b.Vals[i]); r != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func execDelete(tx *table.DBTX, stmt *Delete) (Result, error) {
+	tdef, err := tableDef(tx, stmt.Table)
+	if err != nil {
+		return Result{}, err
+	}
+	// the scanner is invalidated by updates, collect the keys first
+	keys := []table.Record{}
+	err = queryRows(tx, tdef, stmt.Where, func(rec table.Record) (bool, error) {
+		keys = append(keys, pkeyOf(tdef, rec))
+		return true, nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	for _, key := range keys {
+		if _, err := tx.Delete(tdef.Name, key); err != nil {
+			return Result{}, err
+		}
+	}
+	return Result{Affected: len(keys)}, nil
+}
+
+func execExplain(db tableReader, stmt *Explain) (Result, error) {
+	var name string
+	var where *Expr
+	switch s := stmt.Stmt.(type) {
+	case *Select:
+		name, where = s.Table, s.Where
+	case *Update:
+		name, where = s.Table, s.Where
+	case *Delete:
+		name, where = s.Table, s.Where
+	default:
+		return Result{}, errors.New("EXPLAIN: only SELECT, UPDATE and DELETE have a plan")
+	}
+	tdef, err := tableDef(db, name)
+	if err != nil {
+		return Result{}, err
+	}
+	plan := makePlan(tdef, where).String(tdef)
+	return Result{
+		Cols: []string{"plan"},
+		Rows: [][]table.Value{{{Type: table.TYPE_STRING, Str: []byte(plan)}}},
+	}, nil
+}
+
+// format a value as a SQL literal
+func formatValue(v table.Value) string {
+	switch v.Type {
+	case table.TYPE_INT64:
+		return strconv.FormatInt(v.I64, 10)
+	case table.TYPE_STRING:
+		return "'" + strings.ReplaceAll(string(v.Str), "'", "''") + "'"
+	case table.TYPE_BYTES:
+		return fmt.Sprintf("x'%x'", v.Str)
+	}
+	return "?"
+}
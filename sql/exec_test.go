@@ -0,0 +1,255 @@
+package sql
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"killerDB/table"
+)
+
+func openDB(t *testing.T) *table.DB {
+	t.Helper()
+	db := &table.DB{Path: filepath.Join(t.TempDir(), "db")}
+	if err := db.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+// the table t of 50 rows: id i, name nNN with NN = i%10, age i%7
+func testDB(t *testing.T) *table.DB {
+	t.Helper()
+	db := openDB(t)
+	exec(t, db, "CREATE TABLE t (id int64 PRIMARY KEY, name string, age int, INDEX (age)); CREATE INDEX ON t (name)")
+	for i := 0; i < 50; i++ {
+		exec(t, db, fmt.Sprintf("INSERT INTO t VALUES (%d, 'n%02d', %d)", i, i%10, i%7))
+	}
+	return db
+}
+
+func exec(t *testing.T, db *table.DB, src string) []Result {
+	t.Helper()
+	res, err := Exec(db, src)
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	return res
+}
+
+// the rows of the last result as "a,b;c,d"
+func query(t *testing.T, db *table.DB, src string) string {
+	t.Helper()
+	res := exec(t, db, src)
+	rows := []string{}
+	for _, row := range res[len(res)-1].Rows {
+		cells := []string{}
+		for _, v := range row {
+			cells = append(cells, formatValue(v))
+		}
+		rows = append(rows, strings.Join(cells, ","))
+	}
+	return strings.Join(rows, ";")
+}
+
+func checkQuery(t *testing.T, db *table.DB, src string, want string) {
+	t.Helper()
+	if got := query(t, db, src); got != want {
+		t.Fatalf("%s:\n got %s\nwant %s", src, got, want)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	db := testDB(t)
+	res := exec(t, db, "SELECT id * 2 AS x, name, age + 1 FROM t WHERE id >= 10 AND id < 13")
+	if got := strings.Join(res[0].Cols, ","); got != "x,name,age + 1" {
+		t.Fatalf("columns: %s", got)
+	}
+	checkQuery(t, db, "SELECT id * 2 AS x, name, age + 1 FROM t WHERE id >= 10 AND id < 13", "20,'n00',4;22,'n01',5;24,'n02',6")
+	checkQuery(t, db, "SELECT id FROM t WHERE id = 7", "7")
+	checkQuery(t, db, "SELECT id FROM t WHERE id < 0", "")
+	checkQuery(t, db, "SELECT id FROM t WHERE id > 45 OR id = 3", "3;46;47;48;49")
+	checkQuery(t, db, "SELECT id, age FROM t WHERE age = 3 AND id > 20", "24,3;31,3;38,3;45,3")
+	checkQuery(t, db, "SELECT id FROM t WHERE name = x'6e3035'", "5;15;25;35;45")
+	checkQuery(t, db, "SELECT * FROM t WHERE id = 8", "8,'n08',1")
+
+	errs := []struct{ src, err string }{
+		{"SELECT * FROM u", "table not found: u"},
+		{"SELECT x FROM t", "unknown column: x"},
+		{"SELECT id FROM t WHERE name", "WHERE: expect a boolean (int64) value"},
+		{"SELECT id FROM t WHERE name = 1", "name = 1: comparing values of different types"},
+		{"SELECT id / 0 FROM t", "id / 0: division by zero"},
+		{"SELECT id FROM t ORDER BY x", "t: unknown column: x"},
+		{"EXPLAIN INSERT INTO t VALUES (1, 'a', 1)", "EXPLAIN: only SELECT, UPDATE and DELETE have a plan"},
+	}
+	for _, tc := range errs {
+		if _, err := Exec(db, tc.src); err == nil || err.Error() != tc.err {
+			t.Fatalf("%s: %v, want %s", tc.src, err, tc.err)
+		}
+	}
+}
+
+func TestOrderLimit(t *testing.T) {
+	db := testDB(t)
+	cases := []struct{ src, want string }{
+		{"SELECT id FROM t WHERE name = 'n03' ORDER BY id DESC LIMIT 2 OFFSET 1", "33;23"},
+		{"SELECT id, age FROM t WHERE id < 10 ORDER BY age DESC, id", "6,6;5,5;4,4;3,3;2,2;9,2;1,1;8,1;0,0;7,0"},
+		{"SELECT id, age FROM t WHERE id < 10 ORDER BY age DESC, id LIMIT 3 OFFSET 6", "1,1;8,1;0,0"},
+		// the sort is stable
+		{"SELECT id FROM t WHERE id < 10 ORDER BY age LIMIT 4 OFFSET 1", "7;1;8;2"},
+		{"SELECT id FROM t ORDER BY name, id DESC LIMIT 3", "40;30;20"},
+		{"SELECT id FROM t ORDER BY id LIMIT 5 OFFSET 48", "48;49"},
+		{"SELECT id FROM t ORDER BY id LIMIT 5 OFFSET 50", ""},
+		{"SELECT id FROM t ORDER BY id LIMIT 5 OFFSET 1000", ""},
+		// the scan stops at the limit without ORDER BY
+		{"SELECT id FROM t LIMIT 3", "0;1;2"},
+		{"SELECT id FROM t WHERE age = 2 LIMIT 2 OFFSET 3", "23;30"},
+		{"SELECT id FROM t LIMIT 3 OFFSET 49", "49"},
+		// LIMIT 0
+		{"SELECT id FROM t LIMIT 0", ""},
+		{"SELECT id FROM t LIMIT 0 OFFSET 3", ""},
+		{"SELECT id FROM t ORDER BY id DESC LIMIT 0", ""},
+	}
+	for _, tc := range cases {
+		checkQuery(t, db, tc.src, tc.want)
+	}
+	// the columns are there without rows
+	res := exec(t, db, "SELECT id, name FROM t LIMIT 0")
+	if len(res[0].Cols) != 2 || len(res[0].Rows) != 0 {
+		t.Fatalf("LIMIT 0: %+v", res[0])
+	}
+}
+
+func TestLimitRows(t *testing.T) {
+	rows := make([]table.Record, 5)
+	cases := []struct {
+		offset, limit int64
+		n             int
+	}{
+		{0, -1, 5}, {0, 0, 0}, {0, 3, 3}, {2, -1, 3}, {2, 2, 2}, {4, 10, 1}, {5, -1, 0}, {6, 1, 0},
+	}
+	for _, tc := range cases {
+		if got := limitRows(rows, tc.offset, tc.limit); len(got) != tc.n {
+			t.Fatalf("offset %d limit %d: %d rows, want %d", tc.offset, tc.limit, len(got), tc.n)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	db := testDB(t)
+	res := exec(t, db, "UPDATE t SET name = 'x', age = age + 10 WHERE id >= 5 AND id < 8")
+	if res[0].Affected != 3 {
+		t.Fatalf("%d rows updated", res[0].Affected)
+	}
+	checkQuery(t, db, "SELECT * FROM t WHERE id >= 4 AND id <= 8", "4,'n04',4;5,'x',15;6,'x',16;7,'x',10;8,'n08',1")
+	// the indexes are updated
+	checkQuery(t, db, "SELECT id FROM t WHERE name = 'x'", "5;6;7")
+	checkQuery(t, db, "SELECT id FROM t WHERE age >= 10", "7;5;6")
+	checkQuery(t, db, "SELECT id FROM t WHERE name = 'n05'", "15;25;35;45")
+
+	if res := exec(t, db, "UPDATE t SET age = 0 WHERE id > 100"); res[0].Affected != 0 {
+		t.Fatalf("%d rows updated", res[0].Affected)
+	}
+	errs := []struct{ src, err string }{
+		{"UPDATE t SET x = 1", "t: unknown column: x"},
+		{"UPDATE t SET age = 1, age = 2", "t: duplicated column: age"},
+		{"UPDATE t SET age = 'a' WHERE id = 1", "t: bad type of column: age"},
+		{"UPDATE u SET age = 1", "table not found: u"},
+	}
+	for _, tc := range errs {
+		if _, err := Exec(db, tc.src); err == nil || err.Error() != tc.err {
+			t.Fatalf("%s: %v, want %s", tc.src, err, tc.err)
+		}
+	}
+	checkQuery(t, db, "SELECT age FROM t WHERE id = 1", "1")
+}
+
+// the rows are moved to the new primary key
+func TestUpdatePrimaryKey(t *testing.T) {
+	db := testDB(t)
+	if res := exec(t, db, "UPDATE t SET id = id + 100 WHERE id < 3"); res[0].Affected != 3 {
+		t.Fatalf("%d rows updated", res[0].Affected)
+	}
+	checkQuery(t, db, "SELECT id, name FROM t WHERE id < 4 OR id > 99", "3,'n03';100,'n00';101,'n01';102,'n02'")
+	checkQuery(t, db, "SELECT id FROM t WHERE name = 'n01'", "11;21;31;41;101")
+	checkQuery(t, db, "SELECT id FROM t WHERE age = 2", "9;16;23;30;37;44;102")
+
+	// the new keys of some rows are the old keys of the others
+	exec(t, db, "UPDATE t SET id = id + 1 WHERE id >= 100")
+	checkQuery(t, db, "SELECT id, name FROM t WHERE id > 99", "101,'n00';102,'n01';103,'n02'")
+	exec(t, db, "UPDATE t SET id = 203 - id WHERE id > 100 AND id < 103")
+	checkQuery(t, db, "SELECT id, name FROM t WHERE id > 99", "101,'n01';102,'n00';103,'n02'")
+}
+
+func TestUpdatePrimaryKeyCollision(t *testing.T) {
+	db := testDB(t)
+	const before = "4,'n04',4;5,'n05',5"
+	checkQuery(t, db, "SELECT * FROM t WHERE id = 4 OR id = 5", before)
+	for _, src := range []string{
+		// an existing row
+		"UPDATE t SET id = 5 WHERE id = 4",
+		// another updated row
+		"UPDATE t SET id = 4 WHERE id = 4 OR id = 5",
+		// one of the rows is moved before the collision
+		"UPDATE t SET id = id + 1 WHERE id = 3 OR id = 4",
+	} {
+		_, err := Exec(db, src)
+		if err == nil || err.Error() != "t: duplicated primary key" {
+			t.Fatalf("%s: %v", src, err)
+		}
+		// nothing is changed, including the indexes
+		checkQuery(t, db, "SELECT * FROM t WHERE id >= 3 AND id <= 5", "3,'n03',3;"+before)
+		checkQuery(t, db, "SELECT id FROM t WHERE name = 'n04'", "4;14;24;34;44")
+		checkQuery(t, db, "SELECT id FROM t WHERE age = 4", "4;11;18;25;32;39;46")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	db := testDB(t)
+	if res := exec(t, db, "DELETE FROM t WHERE age = 3"); res[0].Affected != 7 {
+		t.Fatalf("%d rows deleted", res[0].Affected)
+	}
+	checkQuery(t, db, "SELECT id FROM t WHERE age = 3", "")
+	checkQuery(t, db, "SELECT id FROM t WHERE id < 12", "0;1;2;4;5;6;7;8;9;11")
+	checkQuery(t, db, "SELECT id FROM t WHERE name = 'n03'", "13;23;33;43")
+
+	// by a primary key range, the condition is checked on each row
+	if res := exec(t, db, "DELETE FROM t WHERE id > 40 AND name != 'n06'"); res[0].Affected != 7 {
+		t.Fatalf("%d rows deleted", res[0].Affected)
+	}
+	checkQuery(t, db, "SELECT id FROM t WHERE id > 39", "40;46")
+	checkQuery(t, db, "SELECT id FROM t WHERE name = 'n04'", "4;14;34")
+	if res := exec(t, db, "DELETE FROM t WHERE id = 1000"); res[0].Affected != 0 {
+		t.Fatalf("%d rows deleted", res[0].Affected)
+	}
+	if _, err := Exec(db, "DELETE FROM t WHERE name = 1"); err == nil {
+		t.Fatal("bad WHERE")
+	}
+
+	if res := exec(t, db, "DELETE FROM t"); res[0].Affected != 36 {
+		t.Fatalf("%d rows deleted", res[0].Affected)
+	}
+	checkQuery(t, db, "SELECT id FROM t", "")
+	checkQuery(t, db, "SELECT id FROM t WHERE age = 0", "")
+}
+
+// the completed statements are returned with the error
+func TestExecPartial(t *testing.T) {
+	db := testDB(t)
+	res, err := Exec(db, "INSERT INTO t VALUES (100, 'a', 1); INSERT INTO t VALUES (1, 'b', 1); INSERT INTO t VALUES (101, 'c', 1)")
+	if err == nil || err.Error() != "t: duplicated primary key" || len(res) != 1 {
+		t.Fatalf("%v %v", res, err)
+	}
+	checkQuery(t, db, "SELECT id FROM t WHERE id >= 100", "100")
+
+	// a statement inside a transaction
+	tx := db.Begin()
+	stmt := parseOne(t, "INSERT INTO t (age, name, id) VALUES (1, 'x', 200), (2, 'y', 201)")
+	if res, err := ExecTx(tx, stmt); err != nil || res.Affected != 2 {
+		t.Fatalf("%+v %v", res, err)
+	}
+	tx.Abort()
+	checkQuery(t, db, "SELECT id FROM t WHERE id >= 200", "")
+}
@@ -0,0 +1,124 @@
+package sql
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// token kinds
+const (
+	TOK_EOF    = 0
+	TOK_IDENT  = 1 // names and keywords
+	TOK_INT    = 2
+	TOK_STRING = 3 // 'quoted'
+	TOK_BYTES  = 4 // x'hex'
+	TOK_PUNCT  = 5 // operators and punctuation
+)
+
+type token struct {
+	kind int
+	text string // the keyword is upper-cased, the string is unquoted
+	pos  int    // byte offset in the input, for errors
+}
+
+var punctuations = []string{
+	"<=", ">=", "!=", "<>", "(", ")", ",", ";", "*", "=", "<", ">", "+", "-", "/", "%",
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
+}
+
+func isDigit(ch byte) bool {
+	return '0' <= ch && ch <= '9'
+}
+
+func isNameStart(ch byte) bool {
+	return ch == '_' || ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z')
+}
+
+func isName(ch byte) bool {
+	return isNameStart(ch) || isDigit(ch)
+}
+
+// split the input into tokens
+func lex(src string) ([]token, error) {
+	toks := []token{}
+	for i := 0; i < len(src); {
+		ch := src[i]
+		switch {
+		case isSpace(ch):
+			i++
+		case ch == '-' && strings.HasPrefix(src[i:], "--"):
+			// comment until the end of the line
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case (ch == 'x' || ch == 'X') && i+1 < len(src) && src[i+1] == '\'':
+			str, next, err := lexString(src, i+1)
+			if err != nil {
+				return nil, err
+			}
+			data, err := hex.DecodeString(str)
+			if err != nil {
+				return nil, fmt.Errorf("bad hex string at %d", i)
+			}
+			toks = append(toks, token{kind: TOK_BYTES, text: string(data), pos: i})
+			i = next
+		case isNameStart(ch):
+			j := i
+			for j < len(src) && isName(src[j]) {
+				j++
+			}
+			toks = append(toks, token{kind: TOK_IDENT, text: src[i:j], pos: i})
+			i = j
+		case isDigit(ch):
+			j := i
+			for j < len(src) && isDigit(src[j]) {
+				j++
+			}
+			toks = append(toks, token{kind: TOK_INT, text: src[i:j], pos: i})
+			i = j
+		case ch == '\'':
+			str, next, err := lexString(src, i)
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, token{kind: TOK_STRING, text: str, pos: i})
+			i = next
+		default:
+			found := false
+			for _, p := range punctuations {
+				if strings.HasPrefix(src[i:], p) {
+					toks = append(toks, token{kind: TOK_PUNCT, text: p, pos: i})
+					i += len(p)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unexpected character %q at %d", ch, i)
+			}
+		}
+	}
+	toks = append(toks, token{kind: TOK_EOF, pos: len(src)})
+	return toks, nil
+}
+
+// a single-quoted string, the quote is escaped by doubling it
+func lexString(src string, i int) (string, int, error) {
+	start := i
+	out := []byte{}
+	for i++; i < len(src); i++ {
+		if src[i] != '\'' {
+			out = append(out, src[i])
+		} else if i+1 < len(src) && src[i+1] == '\'' {
+			out = append(out, '\'')
+			i++
+		} else {
+			return string(out), i + 1, nil
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string at %d", start)
+}
@@ -0,0 +1,599 @@
+package sql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"killerDB/table"
+)
+
+type parser struct {
+	src  string
+	toks []token
+	idx  int
+}
+
+// Parse splits the input into statements separated by semicolons.
+func Parse(src string) ([]Stmt, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{src: src, toks: toks}
+	stmts := []Stmt{}
+	for {
+		for p.tryPunct(";") {
+		}
+		if p.peek().kind == TOK_EOF {
+			return stmts, nil
+		}
+		stmt, err := p.parseStmt()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+		if !p.tryPunct(";") && p.peek().kind != TOK_EOF {
+			return nil, p.errorf("expect ';'")
+		}
+	}
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.idx]
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	tok := p.peek()
+	near := tok.text
+	if tok.kind == TOK_EOF {
+		near = "end of input"
+	}
+	return fmt.Errorf("parse error at %d near %q: %s", tok.pos, near, fmt.Sprintf(format, args...))
+}
+
+// consume the keyword if it's next
+func (p *parser) tryKeyword(kw string) bool {
+	tok := p.peek()
+	if tok.kind == TOK_IDENT && strings.EqualFold(tok.text, kw) {
+		p.idx++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(kws ...string) error {
+	for _, kw := range kws {
+		if !p.tryKeyword(kw) {
+			return p.errorf("expect %s", kw)
+		}
+	}
+	return nil
+}
+
+func (p *parser) tryPunct(punct string) bool {
+	tok := p.peek()
+	if tok.kind == TOK_PUNCT && tok.text == punct {
+		p.idx++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectPunct(punct string) error {
+	if !p.tryPunct(punct) {
+		return p.errorf("expect '%s'", punct)
+	}
+	return nil
+}
+
+var keywords = map[string]bool{
+	"AND": true, "AS": true, "ASC": true, "BY": true, "CREATE": true,
+	"DELETE": true, "DESC": true, "EXPLAIN": true, "FROM": true,
+	"INDEX": true, "INSERT": true, "INTO": true, "KEY": true, "LIMIT": true,
+	"NOT": true, "OFFSET": true, "ON": true, "OR": true, "ORDER": true,
+	"PRIMARY": true, "SELECT": true, "SET": true, "TABLE": true,
+	"UPDATE": true, "VALUES": true, "WHERE": true,
+}
+
+// a table or column name
+func (p *parser) parseName() (string, error) {
+	tok := p.peek()
+	if tok.kind != TOK_IDENT || keywords[strings.ToUpper(tok.text)] {
+		return "", p.errorf("expect name")
+	}
+	p.idx++
+	return tok.text, nil
+}
+
+// (name, name, ...)
+func (p *parser) parseNameList() ([]string, error) {
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for {
+		name, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if p.tryPunct(")") {
+			return names, nil
+		}
+		if err := p.expectPunct(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseStmt() (Stmt, error) {
+	switch {
+	case p.tryKeyword("EXPLAIN"):
+		stmt, err := p.parseStmt()
+		if err != nil {
+			return nil, err
+		}
+		return &Explain{Stmt: stmt}, nil
+	case p.tryKeyword("CREATE"):
+		if p.tryKeyword("TABLE") {
+			return p.parseCreateTable()
+		}
+		if p.tryKeyword("INDEX") {
+			return p.parseCreateIndex()
+		}
+		return nil, p.errorf("expect TABLE or INDEX")
+	case p.tryKeyword("INSERT"):
+		return p.parseInsert()
+	case p.tryKeyword("SELECT"):
+		return p.parseSelect()
+	case p.tryKeyword("UPDATE"):
+		return p.parseUpdate()
+	case p.tryKeyword("DELETE"):
+		return p.parseDelete()
+	default:
+		return nil, p.errorf("unknown statement")
+	}
+}
+
+var typeNames = map[string]uint32{
+	"INT64": table.TYPE_INT64, "INT": table.TYPE_INT64,
+	"INTEGER": table.TYPE_INT64, "BIGINT": table.TYPE_INT64,
+	"BYTES": table.TYPE_BYTES, "BLOB": table.TYPE_BYTES,
+	"STRING": table.TYPE_STRING, "TEXT": table.TYPE_STRING,
+	"VARCHAR": table.TYPE_STRING,
+}
+
+// CREATE TABLE name (col type [PRIMARY KEY], ..., [PRIMARY KEY (cols)], [INDEX (cols)])
+// The primary key columns are moved to the front in the key order.
+func (p *parser) parseCreateTable() (Stmt, error) {
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	cols, types := []string{}, map[string]uint32{}
+	pkeys, indexes := []string(nil), [][]string{}
+	for {
+		switch {
+		case p.tryKeyword("PRIMARY"):
+			if err := p.expectKeyword("KEY"); err != nil {
+				return nil, err
+			}
+			if pkeys != nil {
+				return nil, p.errorf("duplicated primary key")
+			}
+			if pkeys, err = p.parseNameList(); err != nil {
+				return nil, err
+			}
+		case p.tryKeyword("INDEX"):
+			index, err := p.parseNameList()
+			if err != nil {
+				return nil, err
+			}
+			indexes = append(indexes, index)
+		default:
+			col, err := p.parseName()
+			if err != nil {
+				return nil, err
+			}
+			typ, ok := typeNames[strings.ToUpper(p.peek().text)]
+			if !ok || p.peek().kind != TOK_IDENT {
+				return nil, p.errorf("expect column type")
+			}
+			p.idx++
+			if _, dup := types[col]; dup {
+				return nil, p.errorf("duplicated column: %s", col)
+			}
+			cols = append(cols, col)
+			types[col] = typ
+			if p.tryKeyword("PRIMARY") {
+				if err := p.expectKeyword("KEY"); err != nil {
+					return nil, err
+				}
+				if pkeys != nil {
+					return nil, p.errorf("duplicated primary key")
+				}
+				pkeys = []string{col}
+			}
+		}
+		if p.tryPunct(")") {
+			break
+		}
+		if err := p.expectPunct(","); err != nil {
+			return nil, err
+		}
+	}
+	if len(pkeys) == 0 {
+		return nil, fmt.Errorf("table %s: no primary key", name)
+	}
+
+	def := table.TableDef{Name: name, PKeys: len(pkeys), Indexes: indexes}
+	for _, col := range pkeys {
+		typ, ok := types[col]
+		if !ok {
+			return nil, fmt.Errorf("table %s: unknown primary key column: %s", name, col)
+		}
+		def.Cols = append(def.Cols, col)
+		def.Types = append(def.Types, typ)
+	}
+	for _, col := range cols {
+		if !containsStr(pkeys, col) {
+			def.Cols = append(def.Cols, col)
+			def.Types = append(def.Types, types[col])
+		}
+	}
+	return &CreateTable{Def: def}, nil
+}
+
+func containsStr(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// CREATE INDEX [name] ON table (cols), the index name is ignored
+func (p *parser) parseCreateIndex() (Stmt, error) {
+	if !p.tryKeyword("ON") {
+		if _, err := p.parseName(); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("ON"); err != nil {
+			return nil, err
+		}
+	}
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+	cols, err := p.parseNameList()
+	if err != nil {
+		return nil, err
+	}
+	return &CreateIndex{Table: name, Cols: cols}, nil
+}
+
+// INSERT INTO table [(cols)] VALUES (exprs), ...
+func (p *parser) parseInsert() (Stmt, error) {
+	if err := p.expectKeyword("INTO"); err != nil {
+		return nil, err
+	}
+	stmt := &Insert{}
+	var err error
+	if stmt.Table, err = p.parseName(); err != nil {
+		return nil, err
+	}
+	if p.peek().text == "(" {
+		if stmt.Cols, err = p.parseNameList(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectKeyword("VALUES"); err != nil {
+		return nil, err
+	}
+	for {
+		if err := p.expectPunct("("); err != nil {
+			return nil, err
+		}
+		row := []*Expr{}
+		for {
+			expr, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			row = append(row, expr)
+			if p.tryPunct(")") {
+				break
+			}
+			if err := p.expectPunct(","); err != nil {
+				return nil, err
+			}
+		}
+		stmt.Rows = append(stmt.Rows, row)
+		if !p.tryPunct(",") {
+			return stmt, nil
+		}
+	}
+}
+
+// SELECT exprs FROM table [WHERE expr] [ORDER BY col [ASC|DESC], ...]
+// [LIMIT n [OFFSET m]]
+func (p *parser) parseSelect() (Stmt, error) {
+	stmt := &Select{Limit: -1}
+	if !p.tryPunct("*") {
+		for {
+			expr, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if p.tryKeyword("AS") {
+				if expr.Text, err = p.parseName(); err != nil {
+					return nil, err
+				}
+			}
+			stmt.Exprs = append(stmt.Exprs, expr)
+			if !p.tryPunct(",") {
+				break
+			}
+		}
+	}
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	var err error
+	if stmt.Table, err = p.parseName(); err != nil {
+		return nil, err
+	}
+	if stmt.Where, err = p.parseWhere(); err != nil {
+		return nil, err
+	}
+	if p.tryKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			col, err := p.parseName()
+			if err != nil {
+				return nil, err
+			}
+			item := OrderBy{Col: col}
+			if p.tryKeyword("DESC") {
+				item.Desc = true
+			} else {
+				p.tryKeyword("ASC")
+			}
+			stmt.OrderBy = append(stmt.OrderBy, item)
+			if !p.tryPunct(",") {
+				break
+			}
+		}
+	}
+	if p.tryKeyword("LIMIT") {
+		if stmt.Limit, err = p.parseCount(); err != nil {
+			return nil, err
+		}
+		if p.tryKeyword("OFFSET") {
+			if stmt.Offset, err = p.parseCount(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return stmt, nil
+}
+
+// a non-negative integer
+func (p *parser) parseCount() (int64, error) {
+	tok := p.peek()
+	if tok.kind != TOK_INT {
+		return 0, p.errorf("expect a number")
+	}
+	n, err := strconv.ParseInt(tok.text, 10, 64)
+	if err != nil {
+		return 0, p.errorf("bad number")
+	}
+	p.idx++
+	return n, nil
+}
+
+func (p *parser) parseWhere() (*Expr, error) {
+	if !p.tryKeyword("WHERE") {
+		return nil, nil
+	}
+	return p.parseExpr()
+}
+
+// UPDATE table SET col = expr, ... [WHERE expr]
+func (p *parser) parseUpdate() (Stmt, error) {
+	stmt := &Update{}
+	var err error
+	if stmt.Table, err = p.parseName(); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("SET"); err != nil {
+		return nil, err
+	}
+	for {
+		col, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct("="); err != nil {
+			return nil, err
+		}
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Set = append(stmt.Set, Assign{Col: col, Value: expr})
+		if !p.tryPunct(",") {
+			break
+		}
+	}
+	if stmt.Where, err = p.parseWhere(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// DELETE FROM table [WHERE expr]
+func (p *parser) parseDelete() (Stmt, error) {
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	stmt := &Delete{}
+	var err error
+	if stmt.Table, err = p.parseName(); err != nil {
+		return nil, err
+	}
+	if stmt.Where, err = p.parseWhere(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// expressions, from the lowest precedence:
+// OR, AND, NOT, comparisons, + -, * / %, unary -
+func (p *parser) parseExpr() (*Expr, error) {
+	return p.parseOr()
+}
+
+// fill in the source text from the starting token
+func (p *parser) withText(expr *Expr, start int) *Expr {
+	end := len(p.src)
+	if p.idx < len(p.toks) {
+		end = p.toks[p.idx].pos
+	}
+	expr.Text = strings.TrimSpace(p.src[p.toks[start].pos:end])
+	return expr
+}
+
+func (p *parser) parseBinary(
+	next func() (*Expr, error), ops map[string]int, keyword bool,
+) (*Expr, error) {
+	start := p.idx
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		kind := TOK_PUNCT
+		text := tok.text
+		if keyword {
+			kind = TOK_IDENT
+			text = strings.ToUpper(text)
+		}
+		op, ok := ops[text]
+		if !ok || tok.kind != kind {
+			return left, nil
+		}
+		p.idx++
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+		left = p.withText(&Expr{Op: op, Left: left, Right: right}, start)
+	}
+}
+
+func (p *parser) parseOr() (*Expr, error) {
+	return p.parseBinary(p.parseAnd, map[string]int{"OR": OP_OR}, true)
+}
+
+func (p *parser) parseAnd() (*Expr, error) {
+	return p.parseBinary(p.parseNot, map[string]int{"AND": OP_AND}, true)
+}
+
+func (p *parser) parseNot() (*Expr, error) {
+	start := p.idx
+	if p.tryKeyword("NOT") {
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return p.withText(&Expr{Op: OP_NOT, Left: expr}, start), nil
+	}
+	return p.parseCmp()
+}
+
+var cmpOps = map[string]int{
+	"=": OP_EQ, "!=": OP_NE, "<>": OP_NE,
+	"<": OP_LT, "<=": OP_LE, ">": OP_GT, ">=": OP_GE,
+}
+
+func (p *parser) parseCmp() (*Expr, error) {
+	return p.parseBinary(p.parseAdd, cmpOps, false)
+}
+
+func (p *parser) parseAdd() (*Expr, error) {
+	return p.parseBinary(p.parseMul, map[string]int{"+": OP_ADD, "-": OP_SUB}, false)
+}
+
+func (p *parser) parseMul() (*Expr, error) {
+	ops := map[string]int{"*": OP_MUL, "/": OP_DIV, "%": OP_MOD}
+	return p.parseBinary(p.parseUnary, ops, false)
+}
+
+func (p *parser) parseUnary() (*Expr, error) {
+	start := p.idx
+	if p.tryPunct("-") {
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		// fold negative literals so that they can be used by the planner
+		if expr.Op == OP_LIT && expr.Val.Type == table.TYPE_INT64 {
+			expr.Val.I64 = -expr.Val.I64
+			return p.withText(expr, start), nil
+		}
+		return p.withText(&Expr{Op: OP_NEG, Left: expr}, start), nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (*Expr, error) {
+	start := p.idx
+	tok := p.peek()
+	switch tok.kind {
+	case TOK_INT:
+		// parsed as unsigned so that the minimum int64 can be negated
+		u, err := strconv.ParseUint(tok.text, 10, 64)
+		negated := start > 0 && p.toks[start-1].text == "-"
+		if err != nil || u > 1<<63 || (u == 1<<63 && !negated) {
+			return nil, p.errorf("integer out of range")
+		}
+		p.idx++
+		val := table.Value{Type: table.TYPE_INT64, I64: int64(u)}
+		return p.withText(&Expr{Op: OP_LIT, Val: val}, start), nil
+	case TOK_STRING:
+		p.idx++
+		val := table.Value{Type: table.TYPE_STRING, Str: []byte(tok.text)}
+		return p.withText(&Expr{Op: OP_LIT, Val: val}, start), nil
+	case TOK_BYTES:
+		p.idx++
+		val := table.Value{Type: table.TYPE_BYTES, Str: []byte(tok.text)}
+		return p.withText(&Expr{Op: OP_LIT, Val: val}, start), nil
+	case TOK_IDENT:
+		name, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		return p.withText(&Expr{Op: OP_COL, Col: name}, start), nil
+	}
+	if p.tryPunct("(") {
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return p.withText(expr, start), nil
+	}
+	return nil, p.errorf("expect expression")
+}
@@ -0,0 +1,210 @@
+package sql
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"killerDB/table"
+)
+
+var opNames = map[int]string{
+	OP_NOT: "NOT", OP_NEG: "NEG", OP_AND: "AND", OP_OR: "OR",
+	OP_EQ: "=", OP_NE: "!=", OP_LT: "<", OP_LE: "<=", OP_GT: ">", OP_GE: ">=",
+	OP_ADD: "+", OP_SUB: "-", OP_MUL: "*", OP_DIV: "/", OP_MOD: "%",
+}
+
+// the expression tree in prefix form
+func exprString(expr *Expr) string {
+	switch {
+	case expr == nil:
+		return "<nil>"
+	case expr.Op == OP_COL:
+		return expr.Col
+	case expr.Op == OP_LIT:
+		return formatValue(expr.Val)
+	case expr.Right == nil:
+		return fmt.Sprintf("(%s %s)", opNames[expr.Op], exprString(expr.Left))
+	}
+	return fmt.Sprintf("(%s %s %s)", opNames[expr.Op], exprString(expr.Left), exprString(expr.Right))
+}
+
+func parseOne(t *testing.T, src string) Stmt {
+	t.Helper()
+	stmts, err := Parse(src)
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	if len(stmts) != 1 {
+		t.Fatalf("%s: %d statements", src, len(stmts))
+	}
+	return stmts[0]
+}
+
+func TestParseExpr(t *testing.T) {
+	cases := []struct{ src, tree, text string }{
+		{"a", "a", "a"},
+		{"-5", "-5", "-5"},
+		{"- ( 5 )", "-5", "- ( 5 )"},
+		{"-9223372036854775808", "-9223372036854775808", "-9223372036854775808"},
+		{"-a", "(NEG a)", "-a"},
+		{"'it''s'", "'it''s'", "'it''s'"},
+		{"X'00ff'", "x'00ff'", "X'00ff'"},
+		{"1 + 2 * 3 - -x", "(- (+ 1 (* 2 3)) (NEG x))", "1 + 2 * 3 - -x"},
+		{"(a + b) * c % 2", "(% (* (+ a b) c) 2)", "(a + b) * c % 2"},
+		{"a / b / c", "(/ (/ a b) c)", "a / b / c"},
+		{"a = 1 OR b <> 2 AND NOT c < 3", "(OR (= a 1) (AND (!= b 2) (NOT (< c 3))))", "a = 1 OR b <> 2 AND NOT c < 3"},
+		{"not not a and b", "(AND (NOT (NOT a)) b)", "not not a and b"},
+		{"a <= b + 1", "(<= a (+ b 1))", "a <= b + 1"},
+		{"a>=1--comment\n", "(>= a 1)", "a>=1--comment"},
+	}
+	for _, tc := range cases {
+		stmt := parseOne(t, "SELECT "+tc.src+" FROM t").(*Select)
+		expr := stmt.Exprs[0]
+		if got := exprString(expr); got != tc.tree {
+			t.Fatalf("%s: %s, want %s", tc.src, got, tc.tree)
+		}
+		if expr.Text != tc.text {
+			t.Fatalf("%s: text %q, want %q", tc.src, expr.Text, tc.text)
+		}
+	}
+}
+
+func TestParseStmts(t *testing.T) {
+	stmts, err := Parse(`
+		-- the schema
+		CREATE TABLE t (name string, id int64 PRIMARY KEY, age INT, data blob, INDEX (age));;
+		create index by_name on t (name, age);
+		CREATE INDEX ON t (data);
+		INSERT INTO t VALUES (1, 'a', 20, x'00'), (2, 'b', 30, x'');
+		INSERT INTO t (id, name, age, data) VALUES (3, 'c', 40, x'ff');
+		SELECT * FROM t;
+		SELECT id, age * 2 AS double FROM t WHERE age > 1 ORDER BY age DESC, id ASC, name LIMIT 10 OFFSET 5;
+		UPDATE t SET age = age + 1, name = 'x' WHERE id = 1;
+		DELETE FROM t;
+		EXPLAIN DELETE FROM t WHERE id < 2
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stmts) != 10 {
+		t.Fatalf("%d statements", len(stmts))
+	}
+
+	// the primary key is moved to the front
+	want := table.TableDef{
+		Name:    "t",
+		Cols:    []string{"id", "name", "age", "data"},
+		Types:   []uint32{table.TYPE_INT64, table.TYPE_STRING, table.TYPE_INT64, table.TYPE_BYTES},
+		PKeys:   1,
+		Indexes: [][]string{{"age"}},
+	}
+	if got := stmts[0].(*CreateTable).Def; !reflect.DeepEqual(got, want) {
+		t.Fatalf("create table: %+v", got)
+	}
+	if got := stmts[1].(*CreateIndex); got.Table != "t" || !reflect.DeepEqual(got.Cols, []string{"name", "age"}) {
+		t.Fatalf("create index: %+v", got)
+	}
+	if got := stmts[2].(*CreateIndex); got.Table != "t" || !reflect.DeepEqual(got.Cols, []string{"data"}) {
+		t.Fatalf("create index without a name: %+v", got)
+	}
+
+	ins := stmts[3].(*Insert)
+	if ins.Table != "t" || ins.Cols != nil || len(ins.Rows) != 2 || exprString(ins.Rows[1][3]) != "x''" {
+		t.Fatalf("insert: %+v", ins)
+	}
+	if ins := stmts[4].(*Insert); !reflect.DeepEqual(ins.Cols, []string{"id", "name", "age", "data"}) {
+		t.Fatalf("insert with columns: %+v", ins)
+	}
+
+	if sel := stmts[5].(*Select); sel.Exprs != nil || sel.Where != nil || sel.Limit != -1 || sel.Offset != 0 {
+		t.Fatalf("select *: %+v", sel)
+	}
+	sel := stmts[6].(*Select)
+	if len(sel.Exprs) != 2 || sel.Exprs[1].Text != "double" || exprString(sel.Exprs[1]) != "(* age 2)" {
+		t.Fatalf("select: %+v", sel.Exprs)
+	}
+	order := []OrderBy{{Col: "age", Desc: true}, {Col: "id"}, {Col: "name"}}
+	if exprString(sel.Where) != "(> age 1)" || !reflect.DeepEqual(sel.OrderBy, order) || sel.Limit != 10 || sel.Offset != 5 {
+		t.Fatalf("select: %+v", sel)
+	}
+
+	upd := stmts[7].(*Update)
+	if len(upd.Set) != 2 || upd.Set[1].Col != "name" || exprString(upd.Set[0].Value) != "(+ age 1)" || exprString(upd.Where) != "(= id 1)" {
+		t.Fatalf("update: %+v", upd)
+	}
+	if del := stmts[8].(*Delete); del.Table != "t" || del.Where != nil {
+		t.Fatalf("delete: %+v", del)
+	}
+	exp := stmts[9].(*Explain)
+	if del, ok := exp.Stmt.(*Delete); !ok || exprString(del.Where) != "(< id 2)" {
+		t.Fatalf("explain: %+v", exp.Stmt)
+	}
+
+	if stmts, err := Parse(" ;; -- nothing\n"); err != nil || len(stmts) != 0 {
+		t.Fatalf("empty: %v %v", stmts, err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct{ src, err string }{
+		// the lexer
+		{"SELECT # FROM t", `unexpected character '#' at 7`},
+		{"SELECT 'abc FROM t", `unterminated string at 7`},
+		{"SELECT a FROM t WHERE b = 'it''s", `unterminated string at 26`},
+		{"SELECT x'0g' FROM t", `bad hex string at 7`},
+		{"SELECT x'0' FROM t", `bad hex string at 7`},
+		// the parser
+		{"DROP TABLE t", `parse error at 0 near "DROP": unknown statement`},
+		{"SELECT 1 FROM t; DROP TABLE t", `parse error at 17 near "DROP": unknown statement`},
+		{"SELECT * FROM t u", `parse error at 16 near "u": expect ';'`},
+		{"SELECT FROM t", `parse error at 7 near "FROM": expect name`},
+		{"SELECT * FROM", `parse error at 13 near "end of input": expect name`},
+		{"SELECT * t", `parse error at 9 near "t": expect FROM`},
+		{"SELECT * FROM t WHERE", `parse error at 21 near "end of input": expect expression`},
+		{"SELECT * FROM t WHERE (a = 1", `parse error at 28 near "end of input": expect ')'`},
+		{"SELECT * FROM t WHERE a = )", `parse error at 26 near ")": expect expression`},
+		{"SELECT * FROM t ORDER id", `parse error at 22 near "id": expect BY`},
+		{"SELECT * FROM t ORDER BY 1", `parse error at 25 near "1": expect name`},
+		{"SELECT * FROM t LIMIT x", `parse error at 22 near "x": expect a number`},
+		{"SELECT * FROM t LIMIT -1", `parse error at 22 near "-": expect a number`},
+		{"SELECT * FROM t LIMIT 1 OFFSET", `parse error at 30 near "end of input": expect a number`},
+		{"SELECT * FROM t LIMIT 99999999999999999999", `parse error at 22 near "99999999999999999999": bad number`},
+		{"SELECT 9223372036854775808 FROM t", `parse error at 7 near "9223372036854775808": integer out of range`},
+		{"SELECT a AS FROM t", `parse error at 12 near "FROM": expect name`},
+		{"CREATE VIEW v", `parse error at 7 near "VIEW": expect TABLE or INDEX`},
+		{"CREATE TABLE t (a float PRIMARY KEY)", `parse error at 18 near "float": expect column type`},
+		{"CREATE TABLE t (a int PRIMARY)", `parse error at 29 near ")": expect KEY`},
+		{"CREATE TABLE t (a int PRIMARY KEY, a int)", `parse error at 40 near ")": duplicated column: a`},
+		{"CREATE TABLE t (a int PRIMARY KEY, b int PRIMARY KEY)", `parse error at 52 near ")": duplicated primary key`},
+		{"CREATE TABLE t (a int, b int, PRIMARY KEY (a) PRIMARY KEY (b))", `parse error at 46 near "PRIMARY": expect ','`},
+		{"CREATE TABLE t (a int)", `table t: no primary key`},
+		{"CREATE TABLE t (a int, PRIMARY KEY (b))", `table t: unknown primary key column: b`},
+		{"CREATE INDEX i t (a)", `parse error at 15 near "t": expect ON`},
+		{"CREATE INDEX ON t a", `parse error at 18 near "a": expect '('`},
+		{"CREATE INDEX ON t ()", `parse error at 19 near ")": expect name`},
+		{"INSERT t VALUES (1)", `parse error at 7 near "t": expect INTO`},
+		{"INSERT INTO t (a, b VALUES (1)", `parse error at 20 near "VALUES": expect ','`},
+		{"INSERT INTO t VALUES 1", `parse error at 21 near "1": expect '('`},
+		{"INSERT INTO t VALUES (1 2)", `parse error at 24 near "2": expect ','`},
+		{"UPDATE t SET a 1", `parse error at 15 near "1": expect '='`},
+		{"UPDATE t SET WHERE a = 1", `parse error at 13 near "WHERE": expect name`},
+		{"DELETE t", `parse error at 7 near "t": expect FROM`},
+		{"EXPLAIN", `parse error at 7 near "end of input": unknown statement`},
+	}
+	for _, tc := range cases {
+		_, err := Parse(tc.src)
+		if err == nil || err.Error() != tc.err {
+			t.Fatalf("%s: %v, want %s", tc.src, err, tc.err)
+		}
+		// the position points at the token
+		var pos int
+		var near string
+		if n, _ := fmt.Sscanf(tc.err, "parse error at %d near %q", &pos, &near); n == 2 && near != "end of input" {
+			if !strings.HasPrefix(tc.src[pos:], near) {
+				t.Fatalf("%s: %q is not at %d", tc.src, near, pos)
+			}
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+
+	"killerDB/btree"
+	"killerDB/table"
+)
+
+// how the rows are fetched. The WHERE clause is always checked on the
+// fetched rows, so the index range only needs to cover the matching rows.
+type plan struct {
+	index int // -1 for the primary key, >= 0 for a secondary index
+	eqs   int // number of leading index columns fixed by equality
+	scan  table.Scanner
+}
+
+// a condition on a column from the WHERE clause: col op value
+type condition struct {
+	col string
+	op  int
+	val table.Value
+}
+
+// split the WHERE clause into AND-ed conditions usable by an index
+func conditions(where *Expr) []condition {
+	if where == nil {
+		return nil
+	}
+	if where.Op == OP_AND {
+		return append(conditions(where.Left), conditions(where.Right)...)
+	}
+	flipped := map[int]int{OP_EQ: OP_EQ, OP_LT: OP_GT, OP_LE: OP_GE, OP_GT: OP_LT, OP_GE: OP_LE}
+	if _, ok := flipped[where.Op]; !ok {
+		return nil
+	}
+	col, val, op := where.Left, where.Right, where.Op
+	if col.Op != OP_COL {
+		col, val, op = val, col, flipped[op]
+	}
+	if col.Op != OP_COL || !isConst(val) {
+		return nil
+	}
+	v, err := eval(val, nil)
+	if err != nil {
+		return nil // reported when the WHERE clause is evaluated
+	}
+	return []condition{{col: col.Col, op: op, val: v}}
+}
+
+// choose the index that fixes the most leading columns, a range on the
+// column after the fixed ones counts as half. No usable condition means a
+// full scan by the primary key.
+func makePlan(tdef *table.TableDef, where *Expr) plan {
+	conds := conditions(where)
+	best := plan{index: -1, scan: table.Scanner{Cmp1: btree.CMP_GE, Cmp2: btree.CMP_LE}}
+	bestScore := 0
+	candidates := append([][]string{tdef.Cols[:tdef.PKeys]}, tdef.Indexes...)
+	for i, cols := range candidates {
+		p, score := planIndex(tdef, cols, conds)
+		p.index = i - 1
+		if score > bestScore {
+			best, bestScore = p, score
+		}
+	}
+	return best
+}
+
+func planIndex(tdef *table.TableDef, cols []string, conds []condition) (plan, int) {
+	p := plan{scan: table.Scanner{Cmp1: btree.CMP_GE, Cmp2: btree.CMP_LE}}
+	find := func(col string, ops ...int) *condition {
+		for i := range conds {
+			c := &conds[i]
+			if c.col != col {
+				continue
+			}
+			typ := tdef.Types[colPos(tdef, col)]
+			val, ok := coerce(c.val, typ)
+			if !ok {
+				continue
+			}
+			for _, op := range ops {
+				if c.op == op {
+					return &condition{col: col, op: op, val: val}
+				}
+			}
+		}
+		return nil
+	}
+
+	// the leading columns fixed by equality
+	for _, col := range cols {
+		c := find(col, OP_EQ)
+		if c == nil {
+			break
+		}
+		p.scan.Key1.Cols = append(p.scan.Key1.Cols, col)
+		p.scan.Key1.Vals = append(p.scan.Key1.Vals, c.val)
+		p.eqs++
+	}
+	p.scan.Key2 = table.Record{
+		Cols: append([]string{}, p.scan.Key1.Cols...),
+		Vals: append([]table.Value{}, p.scan.Key1.Vals...),
+	}
+	score := 2 * p.eqs
+	if p.eqs == len(cols) {
+		return p, score
+	}
+
+	// a range on the next column
+	col := cols[p.eqs]
+	if c := find(col, OP_GT, OP_GE); c != nil {
+		p.scan.Key1.Cols = append(p.scan.Key1.Cols, col)
+		p.scan.Key1.Vals = append(p.scan.Key1.Vals, c.val)
+		if c.op == OP_GT {
+			p.scan.Cmp1 = btree.CMP_GT
+		}
+		score = 2*p.eqs + 1
+	}
+	if c := find(col, OP_LT, OP_LE); c != nil {
+		p.scan.Key2.Cols = append(p.scan.Key2.Cols, col)
+		p.scan.Key2.Vals = append(p.scan.Key2.Vals, c.val)
+		if c.op == OP_LT {
+			p.scan.Cmp2 = btree.CMP_LT
+		}
+		score = 2*p.eqs + 1
+	}
+	return p, score
+}
+
+func colPos(tdef *table.TableDef, col string) int {
+	for i, c := range tdef.Cols {
+		if c == col {
+			return i
+		}
+	}
+	return -1
+}
+
+// describe the plan for EXPLAIN
+func (p plan) String(tdef *table.TableDef) string {
+	name := "primary key"
+	if p.index >= 0 {
+		name = fmt.Sprintf("index (%s)", strings.Join(tdef.Indexes[p.index], ", "))
+	}
+	if len(p.scan.Key1.Cols) == 0 && len(p.scan.Key2.Cols) == 0 {
+		return fmt.Sprintf("full scan of %s by %s", tdef.Name, name)
+	}
+	bound := func(key table.Record, cmp int) string {
+		if len(key.Cols) == 0 {
+			return "-"
+		}
+		ops := map[int]string{
+			btree.CMP_GE: ">=", btree.CMP_GT: ">", btree.CMP_LE: "<=", btree.CMP_LT: "<",
+		}
+		vals := []string{}
+		for _, v := range key.Vals {
+			vals = append(vals, formatValue(v))
+		}
+		return fmt.Sprintf("(%s) %s (%s)",
+			strings.Join(key.Cols, ", "), ops[cmp], strings.Join(vals, ", "))
+	}
+	return fmt.Sprintf("range scan of %s by %s: %s and %s",
+		tdef.Name, name, bound(p.scan.Key1, p.scan.Cmp1), bound(p.scan.Key2, p.scan.Cmp2))
+}
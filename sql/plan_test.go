@@ -0,0 +1,134 @@
+package sql
+
+import (
+	"fmt"
+	"testing"
+
+	"killerDB/btree"
+	"killerDB/table"
+)
+
+func TestPlan(t *testing.T) {
+	db := testDB(t)
+	reader := db.BeginRead()
+	defer db.EndRead(reader)
+	tdef, err := reader.TableDef("t")
+	if err != nil || tdef == nil {
+		t.Fatal(err)
+	}
+
+	// the index -1 is the primary key, 0 is (age, id) and 1 is (name, id)
+	cases := []struct {
+		where      string
+		index, eqs int
+		cmp1, cmp2 int
+		key1, key2 string
+	}{
+		{"", -1, 0, btree.CMP_GE, btree.CMP_LE, "", ""},
+		{"id = 7", -1, 1, btree.CMP_GE, btree.CMP_LE, "[id]=[7]", "[id]=[7]"},
+		{"7 = id", -1, 1, btree.CMP_GE, btree.CMP_LE, "[id]=[7]", "[id]=[7]"},
+		{"id = 1 + 2 * 3", -1, 1, btree.CMP_GE, btree.CMP_LE, "[id]=[7]", "[id]=[7]"},
+		{"id > 5 AND id < 10", -1, 0, btree.CMP_GT, btree.CMP_LT, "[id]=[5]", "[id]=[10]"},
+		{"id >= 5 AND id <= 10", -1, 0, btree.CMP_GE, btree.CMP_LE, "[id]=[5]", "[id]=[10]"},
+		{"10 >= id", -1, 0, btree.CMP_GE, btree.CMP_LE, "", "[id]=[10]"},
+		{"-5 < id", -1, 0, btree.CMP_GT, btree.CMP_LE, "[id]=[-5]", ""},
+		{"age = 3", 0, 1, btree.CMP_GE, btree.CMP_LE, "[age]=[3]", "[age]=[3]"},
+		{"age = 3 AND id > 20", 0, 1, btree.CMP_GT, btree.CMP_LE, "[age id]=[3 20]", "[age]=[3]"},
+		{"age = 3 AND id < 20 AND name = 'x'", 0, 1, btree.CMP_GE, btree.CMP_LT, "[age]=[3]", "[age id]=[3 20]"},
+		{"name = 'n01' AND id >= 20 AND id <= 30", 1, 1, btree.CMP_GE, btree.CMP_LE, "[name id]=[n01 20]", "[name id]=[n01 30]"},
+		{"name = x'6e3031'", 1, 1, btree.CMP_GE, btree.CMP_LE, "[name]=[n01]", "[name]=[n01]"},
+		// the primary key wins over a range on an index
+		{"id = 1 AND name > 'a'", -1, 1, btree.CMP_GE, btree.CMP_LE, "[id]=[1]", "[id]=[1]"},
+		// and on a tie
+		{"id > 1 AND age > 1", -1, 0, btree.CMP_GT, btree.CMP_LE, "[id]=[1]", ""},
+		{"name < 'n05' AND id < 10", -1, 0, btree.CMP_GE, btree.CMP_LT, "", "[id]=[10]"},
+		// not usable
+		{"id = 1 OR id = 2", -1, 0, btree.CMP_GE, btree.CMP_LE, "", ""},
+		{"NOT id = 1", -1, 0, btree.CMP_GE, btree.CMP_LE, "", ""},
+		{"id != 1", -1, 0, btree.CMP_GE, btree.CMP_LE, "", ""},
+		{"id = age", -1, 0, btree.CMP_GE, btree.CMP_LE, "", ""},
+		{"id + 0 = 1", -1, 0, btree.CMP_GE, btree.CMP_LE, "", ""},
+		{"age = 'x'", -1, 0, btree.CMP_GE, btree.CMP_LE, "", ""},
+		{"id = 1 / 0", -1, 0, btree.CMP_GE, btree.CMP_LE, "", ""},
+	}
+	keyString := func(key table.Record) string {
+		if len(key.Cols) == 0 {
+			return ""
+		}
+		vals := []string{}
+		for _, v := range key.Vals {
+			if v.Type == table.TYPE_INT64 {
+				vals = append(vals, fmt.Sprint(v.I64))
+			} else {
+				vals = append(vals, string(v.Str))
+			}
+		}
+		return fmt.Sprintf("%v=%v", key.Cols, vals)
+	}
+	for _, tc := range cases {
+		var where *Expr
+		if tc.where != "" {
+			where = parseOne(t, "SELECT * FROM t WHERE "+tc.where).(*Select).Where
+		}
+		p := makePlan(tdef, where)
+		sc := p.scan
+		if p.index != tc.index || p.eqs != tc.eqs || sc.Cmp1 != tc.cmp1 || sc.Cmp2 != tc.cmp2 {
+			t.Fatalf("%s: index %d, %d equal, cmp %d %d", tc.where, p.index, p.eqs, sc.Cmp1, sc.Cmp2)
+		}
+		if keyString(sc.Key1) != tc.key1 || keyString(sc.Key2) != tc.key2 {
+			t.Fatalf("%s: keys %s %s", tc.where, keyString(sc.Key1), keyString(sc.Key2))
+		}
+	}
+}
+
+// the strict bounds exclude the rows at the bounds
+func TestPlanBounds(t *testing.T) {
+	db := testDB(t)
+	cases := []struct{ where, want string }{
+		{"id > 5 AND id < 8", "6;7"},
+		{"id >= 5 AND id <= 8", "5;6;7;8"},
+		{"id > 47", "48;49"},
+		{"id < 2", "0;1"},
+		{"id > 5 AND id < 6", ""},
+		{"id > 8 AND id < 5", ""},
+		{"age = 3 AND id > 24", "31;38;45"},
+		{"age = 3 AND id >= 24 AND id < 38", "24;31"},
+		{"age > 5", "6;13;20;27;34;41;48"},
+		{"age < 1", "0;7;14;21;28;35;42;49"},
+		{"age >= 5 AND age < 6", "5;12;19;26;33;40;47"},
+		{"name > 'n08'", "9;19;29;39;49"},
+		{"name > 'n08' AND id <= 29", "9;19;29"},
+	}
+	for _, tc := range cases {
+		checkQuery(t, db, "SELECT id FROM t WHERE "+tc.where, tc.want)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	db := testDB(t)
+	cases := []struct{ src, want string }{
+		{"EXPLAIN SELECT * FROM t", "full scan of t by primary key"},
+		{"EXPLAIN SELECT * FROM t WHERE name = 'x' OR id = 1", "full scan of t by primary key"},
+		{"EXPLAIN SELECT * FROM t WHERE id = 1", "range scan of t by primary key: (id) >= (1) and (id) <= (1)"},
+		{"EXPLAIN SELECT * FROM t WHERE 10 >= id", "range scan of t by primary key: - and (id) <= (10)"},
+		{"EXPLAIN SELECT * FROM t WHERE id > -3 AND id < 10", "range scan of t by primary key: (id) > (-3) and (id) < (10)"},
+		{"EXPLAIN SELECT * FROM t WHERE age = 3 AND id > 20", "range scan of t by index (age, id): (age, id) > (3, 20) and (age) <= (3)"},
+		{"EXPLAIN UPDATE t SET age = 1 WHERE age < 3", "range scan of t by index (age, id): - and (age) < (3)"},
+		{"EXPLAIN DELETE FROM t WHERE name = 'it''s'", "range scan of t by index (name, id): (name) >= ('it''s') and (name) <= ('it''s')"},
+		{"EXPLAIN SELECT * FROM t WHERE name = x'00ff'", "range scan of t by index (name, id): (name) >= ('\x00\xff') and (name) <= ('\x00\xff')"},
+	}
+	for _, tc := range cases {
+		res := exec(t, db, tc.src)
+		if len(res[0].Cols) != 1 || res[0].Cols[0] != "plan" || len(res[0].Rows) != 1 {
+			t.Fatalf("%s: %+v", tc.src, res[0])
+		}
+		if got := string(res[0].Rows[0][0].Str); got != tc.want {
+			t.Fatalf("%s:\n got %s\nwant %s", tc.src, got, tc.want)
+		}
+	}
+	// nothing is changed
+	checkQuery(t, db, "SELECT age FROM t WHERE id = 1", "1")
+	if _, err := Exec(db, "EXPLAIN SELECT * FROM u"); err == nil {
+		t.Fatal("unknown table")
+	}
+}
@@ -0,0 +1,255 @@
+package table
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"killerDB/btree"
+)
+
+// table definition
+type TableDef struct {
+	Name  string
+	Types []uint32 // column types
+	Cols  []string // column names
+	PKeys int      // the first `PKeys` columns are the primary key
+	// secondary indexes, the missing primary key columns are appended
+	// to each index so that the index keys are unique
+	Indexes [][]string
+	// auto-assigned B-tree key prefixes
+	Prefix        uint32
+	IndexPrefixes []uint32
+}
+
+// internal table: metadata
+var TDEF_META = &TableDef{
+	Name:   "@meta",
+	Types:  []uint32{TYPE_STRING, TYPE_BYTES},
+	Cols:   []string{"key", "val"},
+	PKeys:  1,
+	Prefix: 1,
+}
+
+// internal table: table schemas
+var TDEF_TABLE = &TableDef{
+	Name:   "@table",
+	Types:  []uint32{TYPE_STRING, TYPE_BYTES},
+	Cols:   []string{"name", "def"},
+	PKeys:  1,
+	Prefix: 2,
+}
+
+// the prefixes below are reserved for the internal tables
+const TABLE_PREFIX_MIN = 100
+
+func tableDefCheck(tdef *TableDef) error {
+	bad := tdef.Name == "" || strings.HasPrefix(tdef.Name, "@")
+	bad = bad || len(tdef.Cols) == 0 || len(tdef.Cols) != len(tdef.Types)
+	bad = bad || !(1 <= tdef.PKeys && tdef.PKeys <= len(tdef.Cols))
+	if bad {
+		return fmt.Errorf("bad table definition: %s", tdef.Name)
+	}
+	seen := map[string]bool{}
+	for i, col := range tdef.Cols {
+		if col == "" || seen[col] {
+			return fmt.Errorf("bad column name: %q", col)
+		}
+		seen[col] = true
+		if _, ok := typeNames[tdef.Types[i]]; !ok {
+			return fmt.Errorf("bad column type: %s", col)
+		}
+	}
+	for i, index := range tdef.Indexes {
+		index, err := checkIndexKeys(tdef, index)
+		if err != nil {
+			return err
+		}
+		tdef.Indexes[i] = index
+	}
+	return nil
+}
+
+// check the index columns and append the missing primary key columns
+func checkIndexKeys(tdef *TableDef, index []string) ([]string, error) {
+	if len(index) == 0 {
+		return nil, fmt.Errorf("%s: empty index", tdef.Name)
+	}
+	seen := map[string]bool{}
+	for _, col := range index {
+		if colIndex(tdef, col) < 0 {
+			return nil, fmt.Errorf("%s: unknown index column: %s", tdef.Name, col)
+		}
+		if seen[col] {
+			return nil, fmt.Errorf("%s: duplicated index column: %s", tdef.Name, col)
+		}
+		seen[col] = true
+	}
+	for _, col := range tdef.Cols[:tdef.PKeys] {
+		if !seen[col] {
+			index = append(index, col)
+		}
+	}
+	return index, nil
+}
+
+// the position of a column in the table, -1 if not found
+func colIndex(tdef *TableDef, col string) int {
+	for i, c := range tdef.Cols {
+		if c == col {
+			return i
+		}
+	}
+	return -1
+}
+
+// get the table definition by name, nil if the table doesn't exist
+func getTableDef(kv kvReader, name string) (*TableDef, error) {
+	rec := (&Record{}).AddStr("name", name)
+	ok, err := dbGet(kv, TDEF_TABLE, rec)
+	if err != nil || !ok {
+		return nil, err
+	}
+	tdef := &TableDef{}
+	if err := json.Unmarshal(rec.Get("def").Str, tdef); err != nil {
+		return nil, fmt.Errorf("bad table definition %s: %w", name, err)
+	}
+	return tdef, nil
+}
+
+// look up a table that must exist
+func mustTableDef(kv kvReader, name string) (*TableDef, error) {
+	tdef, err := getTableDef(kv, name)
+	if err == nil && tdef == nil {
+		err = fmt.Errorf("table not found: %s", name)
+	}
+	return tdef, err
+}
+
+// create a new table, the prefix is assigned from the metadata table.
+func createTable(kv kvWriter, tdef *TableDef) error {
+	if err := tableDefCheck(tdef); err != nil {
+		return err
+	}
+	// check the existing table
+	table := (&Record{}).AddStr("name", tdef.Name)
+	ok, err := dbGet(kv, TDEF_TABLE, table)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return fmt.Errorf("table exists: %s", tdef.Name)
+	}
+
+	// allocate new prefixes for the table and its indexes
+	prefix, err := allocPrefixes(kv, 1+len(tdef.Indexes))
+	if err != nil {
+		return err
+	}
+	tdef.Prefix = prefix
+	tdef.IndexPrefixes = nil
+	for i := range tdef.Indexes {
+		tdef.IndexPrefixes = append(tdef.IndexPrefixes, prefix+1+uint32(i))
+	}
+
+	// store the definition
+	return storeTableDef(kv, tdef, MODE_INSERT_ONLY)
+}
+
+// add a secondary index to an existing table and populate it.
+func createIndex(kv kvWriter, table string, index []string) error {
+	tdef, err := mustTableDef(kv, table)
+	if err != nil {
+		return err
+	}
+	index, err = checkIndexKeys(tdef, append([]string{}, index...))
+	if err != nil {
+		return err
+	}
+	for _, existing := range tdef.Indexes {
+		if equalCols(existing, index) {
+			return fmt.Errorf("%s: index exists: %v", table, index)
+		}
+	}
+	prefix, err := allocPrefixes(kv, 1)
+	if err != nil {
+		return err
+	}
+	tdef.Indexes = append(tdef.Indexes, index)
+	tdef.IndexPrefixes = append(tdef.IndexPrefixes, prefix)
+
+	// index the existing rows
+	keys := [][]byte{}
+	sc := Scanner{Cmp1: btree.CMP_GE, Cmp2: btree.CMP_LE}
+	if err := dbScan(kv, tdef, &sc); err != nil {
+		return err
+	}
+	for ; sc.Valid(); sc.Next() {
+		rec := Record{}
+		if err := sc.Deref(&rec); err != nil {
+			return err
+		}
+		values, err := checkRecord(tdef, rec, len(tdef.Cols))
+		if err != nil {
+			return err
+		}
+		keys = append(keys, encodeIndexKey(tdef, len(tdef.Indexes)-1, values))
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	// the iterator is invalidated by updates, so they are done afterwards
+	for _, key := range keys {
+		if err := kv.Set(key, nil); err != nil {
+			return err
+		}
+	}
+	return storeTableDef(kv, tdef, MODE_UPDATE_ONLY)
+}
+
+func equalCols(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// reserve `n` consecutive prefixes from the metadata table
+func allocPrefixes(kv kvWriter, n int) (uint32, error) {
+	meta := (&Record{}).AddStr("key", "next_prefix")
+	ok, err := dbGet(kv, TDEF_META, meta)
+	if err != nil {
+		return 0, err
+	}
+	prefix := uint32(TABLE_PREFIX_MIN)
+	if ok {
+		val := meta.Get("val").Str
+		if len(val) != 4 {
+			return 0, errors.New("bad next_prefix")
+		}
+		prefix = binary.LittleEndian.Uint32(val)
+	}
+	next := binary.LittleEndian.AppendUint32(nil, prefix+uint32(n))
+	meta = (&Record{}).AddStr("key", "next_prefix").AddBytes("val", next)
+	if _, err := dbUpdate(kv, TDEF_META, *meta, MODE_UPSERT); err != nil {
+		return 0, err
+	}
+	return prefix, nil
+}
+
+func storeTableDef(kv kvWriter, tdef *TableDef, mode int) error {
+	val, err := json.Marshal(tdef)
+	if err != nil {
+		return err
+	}
+	table := (&Record{}).AddStr("name", tdef.Name).AddBytes("def", val)
+	_, err = dbUpdate(kv, TDEF_TABLE, *table, mode)
+	return err
+}
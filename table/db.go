@@ -0,0 +1,347 @@
+package table
+
+import (
+	"fmt"
+
+	"killerDB/btree"
+)
+
+// DB stores tables in a KV file
+type DB struct {
+	Path string
+	WAL  bool // see btree.KV.WAL
+	// internals
+	kv btree.KV
+}
+
+// DBTX is a read-write transaction over the tables
+type DBTX struct {
+	db *DB
+	kv *btree.KVTX
+}
+
+// DBReader is a read-only snapshot of the tables
+type DBReader struct {
+	kv *btree.KVReader
+}
+
+// the KV operations used by the table layer,
+// implemented by both snapshot readers and transactions.
+type kvReader interface {
+	Get(key []byte) ([]byte, bool, error)
+	Scan(start []byte, end []byte) *btree.KVIter
+}
+
+type kvWriter interface {
+	kvReader
+	Set(key []byte, val []byte) error
+	Update(req *btree.UpdateReq) (bool, error)
+	Del(key []byte) (bool, error)
+}
+
+// modes of the updates, same as the KV
+const (
+	MODE_UPSERT      = btree.MODE_UPSERT      // insert or replace
+	MODE_UPDATE_ONLY = btree.MODE_UPDATE_ONLY // update existing rows
+	MODE_INSERT_ONLY = btree.MODE_INSERT_ONLY // only add new rows
+)
+
+func (db *DB) Open() error {
+	db.kv.Path = db.Path
+	db.kv.WAL = db.WAL
+	return db.kv.Open()
+}
+
+func (db *DB) Close() {
+	db.kv.Close()
+}
+
+func (db *DB) Begin() *DBTX {
+	return &DBTX{db: db, kv: db.kv.Begin()}
+}
+
+func (tx *DBTX) Commit() error {
+	return tx.kv.Commit()
+}
+
+func (tx *DBTX) Abort() {
+	tx.kv.Abort()
+}
+
+// start a snapshot read, see btree.KV.BeginRead
+func (db *DB) BeginRead() *DBReader {
+	return &DBReader{kv: db.kv.BeginRead()}
+}
+
+func (db *DB) EndRead(reader *DBReader) {
+	db.kv.EndRead(reader.kv)
+}
+
+// get a table definition, nil if the table doesn't exist
+func (reader *DBReader) TableDef(table string) (*TableDef, error) {
+	return getTableDef(reader.kv, table)
+}
+
+func (reader *DBReader) Get(table string, rec *Record) (bool, error) {
+	tdef, err := mustTableDef(reader.kv, table)
+	if err != nil {
+		return false, err
+	}
+	return dbGet(reader.kv, tdef, rec)
+}
+
+// start a range scan, the scanner is valid until the reader is ended
+func (reader *DBReader) Scan(table string, req *Scanner) error {
+	tdef, err := mustTableDef(reader.kv, table)
+	if err != nil {
+		return err
+	}
+	return dbScan(reader.kv, tdef, req)
+}
+
+// get a table definition, nil if the table doesn't exist
+func (tx *DBTX) TableDef(table string) (*TableDef, error) {
+	return getTableDef(tx.kv, table)
+}
+
+func (tx *DBTX) CreateTable(tdef *TableDef) error {
+	return createTable(tx.kv, tdef)
+}
+
+// get a single row by the primary key, the columns are filled in
+func (tx *DBTX) Get(table string, rec *Record) (bool, error) {
+	tdef, err := mustTableDef(tx.kv, table)
+	if err != nil {
+		return false, err
+	}
+	return dbGet(tx.kv, tdef, rec)
+}
+
+// add a row, returns false if the primary key exists
+func (tx *DBTX) Insert(table string, rec Record) (bool, error) {
+	return tx.update(table, rec, MODE_INSERT_ONLY)
+}
+
+// replace a row, returns false if the primary key doesn't exist
+func (tx *DBTX) Update(table string, rec Record) (bool, error) {
+	return tx.update(table, rec, MODE_UPDATE_ONLY)
+}
+
+// add or replace a row, returns true if the row was added
+func (tx *DBTX) Upsert(table string, rec Record) (bool, error) {
+	return tx.update(table, rec, MODE_UPSERT)
+}
+
+// delete a row by the primary key, returns false if it doesn't exist
+func (tx *DBTX) Delete(table string, rec Record) (bool, error) {
+	tdef, err := mustTableDef(tx.kv, table)
+	if err != nil {
+		return false, err
+	}
+	return dbDelete(tx.kv, tdef, rec)
+}
+
+// add a secondary index to an existing table, the existing rows are indexed
+func (tx *DBTX) CreateIndex(table string, index []string) error {
+	return createIndex(tx.kv, table, index)
+}
+
+// start a range scan, the scanner is invalidated by updates
+func (tx *DBTX) Scan(table string, req *Scanner) error {
+	tdef, err := mustTableDef(tx.kv, table)
+	if err != nil {
+		return err
+	}
+	return dbScan(tx.kv, tdef, req)
+}
+
+func (tx *DBTX) update(table string, rec Record, mode int) (bool, error) {
+	tdef, err := mustTableDef(tx.kv, table)
+	if err != nil {
+		return false, err
+	}
+	return dbUpdate(tx.kv, tdef, rec, mode)
+}
+
+// the single operation versions of the above, each in its own transaction.
+func (db *DB) CreateTable(tdef *TableDef) error {
+	_, err := db.update(func(tx *DBTX) (bool, error) {
+		return true, tx.CreateTable(tdef)
+	})
+	return err
+}
+
+func (db *DB) CreateIndex(table string, index []string) error {
+	_, err := db.update(func(tx *DBTX) (bool, error) {
+		return true, tx.CreateIndex(table, index)
+	})
+	return err
+}
+
+// reads the last committed version
+func (db *DB) Get(table string, rec *Record) (bool, error) {
+	reader := db.BeginRead()
+	defer db.EndRead(reader)
+	return reader.Get(table, rec)
+}
+
+// scan the last committed version, the scanner must be closed
+func (db *DB) Scan(table string, req *Scanner) error {
+	reader := db.BeginRead()
+	if err := reader.Scan(table, req); err != nil {
+		db.EndRead(reader)
+		return err
+	}
+	req.reader = reader
+	req.db = db
+	return nil
+}
+
+func (db *DB) Insert(table string, rec Record) (bool, error) {
+	return db.update(func(tx *DBTX) (bool, error) {
+		return tx.Insert(table, rec)
+	})
+}
+
+func (db *DB) Update(table string, rec Record) (bool, error) {
+	return db.update(func(tx *DBTX) (bool, error) {
+		return tx.Update(table, rec)
+	})
+}
+
+func (db *DB) Upsert(table string, rec Record) (bool, error) {
+	return db.update(func(tx *DBTX) (bool, error) {
+		return tx.Upsert(table, rec)
+	})
+}
+
+func (db *DB) Delete(table string, rec Record) (bool, error) {
+	return db.update(func(tx *DBTX) (bool, error) {
+		return tx.Delete(table, rec)
+	})
+}
+
+func (db *DB) update(fn func(tx *DBTX) (bool, error)) (bool, error) {
+	tx := db.Begin()
+	ok, err := fn(tx)
+	if err != nil {
+		tx.Abort()
+		return false, err
+	}
+	return ok, tx.Commit()
+}
+
+// reorder the record into the column order of the table and check types.
+// n == tdef.PKeys: the record is exactly the primary key.
+// n == len(tdef.Cols): the record contains all columns.
+func checkRecord(tdef *TableDef, rec Record, n int) ([]Value, error) {
+	if len(rec.Cols) != len(rec.Vals) {
+		return nil, fmt.Errorf("bad record: %d columns, %d values", len(rec.Cols), len(rec.Vals))
+	}
+	if len(rec.Cols) != n {
+		return nil, fmt.Errorf("%s: expected %d columns, got %d", tdef.Name, n, len(rec.Cols))
+	}
+	vals := make([]Value, n)
+	for i := 0; i < n; i++ {
+		v := rec.Get(tdef.Cols[i])
+		if v == nil {
+			return nil, fmt.Errorf("%s: missing column: %s", tdef.Name, tdef.Cols[i])
+		}
+		if v.Type != tdef.Types[i] {
+			return nil, fmt.Errorf("%s: bad type of column: %s", tdef.Name, tdef.Cols[i])
+		}
+		vals[i] = *v
+	}
+	return vals, nil
+}
+
+// get a single row by the primary key
+func dbGet(kv kvReader, tdef *TableDef, rec *Record) (bool, error) {
+	values, err := checkRecord(tdef, *rec, tdef.PKeys)
+	if err != nil {
+		return false, err
+	}
+	key := encodeKey(nil, tdef.Prefix, values)
+	val, ok, err := kv.Get(key)
+	if err != nil || !ok {
+		return false, err
+	}
+	values, err = decodeRow(tdef, values, val)
+	if err != nil {
+		return false, err
+	}
+	rec.Cols = append([]string{}, tdef.Cols...)
+	rec.Vals = values
+	return true, nil
+}
+
+// combine the primary key and the stored value into a full row
+func decodeRow(tdef *TableDef, pkey []Value, val []byte) ([]Value, error) {
+	values := append([]Value{}, pkey...)
+	for i := tdef.PKeys; i < len(tdef.Cols); i++ {
+		values = append(values, Value{Type: tdef.Types[i]})
+	}
+	if err := decodeValues(val, values[tdef.PKeys:]); err != nil {
+		return nil, fmt.Errorf("%s: %w", tdef.Name, err)
+	}
+	return values, nil
+}
+
+// add or replace a row depending on the mode
+func dbUpdate(kv kvWriter, tdef *TableDef, rec Record, mode int) (bool, error) {
+	values, err := checkRecord(tdef, rec, len(tdef.Cols))
+	if err != nil {
+		return false, err
+	}
+	key := encodeKey(nil, tdef.Prefix, values[:tdef.PKeys])
+	val := encodeValues(nil, values[tdef.PKeys:])
+
+	req := &btree.UpdateReq{Key: key, Val: val, Mode: mode}
+	updated, err := kv.Update(req)
+	if err != nil {
+		return false, err
+	}
+	if updated && len(tdef.Indexes) > 0 {
+		// the old row is needed to remove the old index entries
+		var old []Value
+		if req.Found {
+			old, err = decodeRow(tdef, values[:tdef.PKeys], req.Old)
+			if err != nil {
+				return false, err
+			}
+		}
+		if err := indexOp(kv, tdef, old, values); err != nil {
+			return false, err
+		}
+	}
+	if mode == MODE_UPDATE_ONLY {
+		return req.Found, nil
+	}
+	return !req.Found, nil
+}
+
+// delete a row by the primary key
+func dbDelete(kv kvWriter, tdef *TableDef, rec Record) (bool, error) {
+	values, err := checkRecord(tdef, rec, tdef.PKeys)
+	if err != nil {
+		return false, err
+	}
+	key := encodeKey(nil, tdef.Prefix, values)
+	if len(tdef.Indexes) == 0 {
+		return kv.Del(key)
+	}
+
+	// the old row is needed to remove the index entries
+	val, ok, err := kv.Get(key)
+	if err != nil || !ok {
+		return false, err
+	}
+	old, err := decodeRow(tdef, values, val)
+	if err != nil {
+		return false, err
+	}
+	if _, err := kv.Del(key); err != nil {
+		return false, err
+	}
+	return true, indexOp(kv, tdef, old, nil)
+}
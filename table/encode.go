@@ -0,0 +1,56 @@
+package table
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"killerDB/keyenc"
+)
+
+// The values are serialized with the order-preserving encodings from
+// keyenc, so that bytes.Compare on the encoded keys gives the same order
+// as comparing the values column by column. The column types are known
+// from the table definition, so the values are not tagged.
+func encodeValues(out []byte, vals []Value) []byte {
+	for _, v := range vals {
+		switch v.Type {
+		case TYPE_INT64:
+			out = keyenc.AppendInt64(out, v.I64)
+		case TYPE_BYTES, TYPE_STRING:
+			out = keyenc.AppendBytes(out, v.Str)
+		default:
+			// the values are checked against the schema before
+			panic(fmt.Sprintf("table: bad value type %d", v.Type))
+		}
+	}
+	return out
+}
+
+// the decoded values must have their types set
+func decodeValues(in []byte, out []Value) error {
+	var err error
+	for i := range out {
+		switch out[i].Type {
+		case TYPE_INT64:
+			out[i].I64, in, err = keyenc.DecodeInt64(in)
+		case TYPE_BYTES, TYPE_STRING:
+			out[i].Str, in, err = keyenc.DecodeBytes(in)
+		default:
+			return fmt.Errorf("bad value type %d", out[i].Type)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(in) != 0 {
+		return errors.New("trailing bytes after the encoded values")
+	}
+	return nil
+}
+
+// the B-tree key of a row: the table prefix followed by the primary key
+func encodeKey(out []byte, prefix uint32, vals []Value) []byte {
+	out = binary.BigEndian.AppendUint32(out, prefix)
+	return encodeValues(out, vals)
+}
@@ -0,0 +1,47 @@
+package table
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncodeValues(t *testing.T) {
+	vals := []Value{
+		{Type: TYPE_INT64, I64: -42},
+		{Type: TYPE_STRING, Str: []byte("a\x00b")},
+		{Type: TYPE_BYTES, Str: []byte{}},
+	}
+	data := encodeValues(nil, vals)
+	out := []Value{{Type: TYPE_INT64}, {Type: TYPE_STRING}, {Type: TYPE_BYTES}}
+	if err := decodeValues(data, out); err != nil {
+		t.Fatal(err)
+	}
+	if out[0].I64 != -42 || string(out[1].Str) != "a\x00b" || len(out[2].Str) != 0 {
+		t.Fatalf("decoded: %+v", out)
+	}
+
+	// the order of the encoded values is that of the values
+	small := encodeValues(nil, []Value{{Type: TYPE_INT64, I64: -1}, {Type: TYPE_STRING, Str: []byte("b")}})
+	large := encodeValues(nil, []Value{{Type: TYPE_INT64, I64: 1}, {Type: TYPE_STRING, Str: []byte("a")}})
+	if bytes.Compare(small, large) >= 0 {
+		t.Fatal("order")
+	}
+
+	if err := decodeValues(append(data, 0), out); err == nil {
+		t.Fatal("trailing bytes accepted")
+	}
+	bad := []Value{{Type: TYPE_INT64}, {Type: TYPE_ERROR}}
+	if err := decodeValues(data, bad); err == nil || !strings.Contains(err.Error(), "bad value type 0") {
+		t.Fatalf("bad type: %v", err)
+	}
+}
+
+func TestEncodeBadType(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(r.(string), "bad value type 7") {
+			t.Fatalf("panic: %v", r)
+		}
+	}()
+	encodeValues(nil, []Value{{Type: 7}})
+}
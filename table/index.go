@@ -0,0 +1,30 @@
+package table
+
+// the B-tree key of a secondary index entry:
+// the index prefix followed by the index columns.
+// `values` is a full row in the column order of the table.
+func encodeIndexKey(tdef *TableDef, idx int, values []Value) []byte {
+	key := make([]Value, len(tdef.Indexes[idx]))
+	for i, col := range tdef.Indexes[idx] {
+		key[i] = values[colIndex(tdef, col)]
+	}
+	return encodeKey(nil, tdef.IndexPrefixes[idx], key)
+}
+
+// maintain the secondary indexes after a row is changed.
+// `old` or `new` is nil when the row is added or deleted.
+func indexOp(kv kvWriter, tdef *TableDef, old []Value, new []Value) error {
+	for i := range tdef.Indexes {
+		if old != nil {
+			if _, err := kv.Del(encodeIndexKey(tdef, i, old)); err != nil {
+				return err
+			}
+		}
+		if new != nil {
+			if err := kv.Set(encodeIndexKey(tdef, i, new), nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
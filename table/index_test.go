@@ -0,0 +1,339 @@
+package table
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"killerDB/btree"
+	"killerDB/keyenc"
+)
+
+func openDB(t *testing.T) *DB {
+	t.Helper()
+	db := &DB{Path: filepath.Join(t.TempDir(), "db")}
+	if err := db.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	return db
+}
+
+// person: id, name, age, city, note. only the note is not indexed.
+func personDef() *TableDef {
+	return &TableDef{
+		Name:    "person",
+		Cols:    []string{"id", "name", "age", "city", "note"},
+		Types:   []uint32{TYPE_INT64, TYPE_STRING, TYPE_INT64, TYPE_BYTES, TYPE_STRING},
+		PKeys:   1,
+		Indexes: [][]string{{"age"}, {"city", "name"}},
+	}
+}
+
+func person(id int64, name string, age int64, city string, note string) Record {
+	rec := (&Record{}).AddInt64("id", id).AddStr("name", name).AddInt64("age", age)
+	return *rec.AddBytes("city", []byte(city)).AddStr("note", note)
+}
+
+// the keys in the range of a prefix
+func prefixKeys(t *testing.T, db *DB, prefix uint32) [][]byte {
+	t.Helper()
+	reader := db.kv.BeginRead()
+	defer db.kv.EndRead(reader)
+	start := encodeKey(nil, prefix, nil)
+	keys := [][]byte{}
+	iter := reader.Scan(start, keyenc.PrefixEnd(start))
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		if len(iter.Val()) != 0 {
+			t.Fatalf("index entry %q with a value", iter.Key())
+		}
+		keys = append(keys, bytes.Clone(iter.Key()))
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// the index entries are exactly those of the rows, returns the number of rows
+func checkIndexes(t *testing.T, db *DB, table string) int {
+	t.Helper()
+	reader := db.BeginRead()
+	defer db.EndRead(reader)
+	tdef, err := reader.TableDef(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := [][]Value{}
+	sc := Scanner{Cmp1: btree.CMP_GE, Cmp2: btree.CMP_LE}
+	if err := reader.Scan(table, &sc); err != nil {
+		t.Fatal(err)
+	}
+	for ; sc.Valid(); sc.Next() {
+		rec := Record{}
+		if err := sc.Deref(&rec); err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, rec.Vals)
+	}
+	for i := range tdef.Indexes {
+		want := [][]byte{}
+		for _, row := range rows {
+			want = append(want, encodeIndexKey(tdef, i, row))
+		}
+		sort.Slice(want, func(a, b int) bool { return bytes.Compare(want[a], want[b]) < 0 })
+		got := prefixKeys(t, db, tdef.IndexPrefixes[i])
+		if len(got) != len(want) {
+			t.Fatalf("index %v: %d entries, %d rows", tdef.Indexes[i], len(got), len(want))
+		}
+		for j := range got {
+			if !bytes.Equal(got[j], want[j]) {
+				t.Fatalf("index %v: entry %d is %q, want %q", tdef.Indexes[i], j, got[j], want[j])
+			}
+		}
+	}
+	return len(rows)
+}
+
+// the ids of the rows by an index lookup
+func lookupIDs(t *testing.T, db *DB, table string, key Record) []int64 {
+	t.Helper()
+	sc := Scanner{Cmp1: btree.CMP_GE, Cmp2: btree.CMP_LE, Key1: key, Key2: key}
+	if err := db.Scan(table, &sc); err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+	ids := []int64{}
+	for ; sc.Valid(); sc.Next() {
+		rec := Record{}
+		if err := sc.Deref(&rec); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, rec.Get("id").I64)
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func checkIDs(t *testing.T, db *DB, key Record, want ...int64) {
+	t.Helper()
+	if got := lookupIDs(t, db, "person", key); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("%v: %v, want %v", key.Vals, got, want)
+	}
+}
+
+func byAge(age int64) Record {
+	return *(&Record{}).AddInt64("age", age)
+}
+
+func byCity(city string, name string) Record {
+	rec := (&Record{}).AddBytes("city", []byte(city))
+	if name != "" {
+		rec.AddStr("name", name)
+	}
+	return *rec
+}
+
+func TestIndexMaintenance(t *testing.T) {
+	db := openDB(t)
+	if err := db.CreateTable(personDef()); err != nil {
+		t.Fatal(err)
+	}
+	cities := []string{"paris", "rome", "oslo"}
+	for i := int64(0); i < 100; i++ {
+		rec := person(i, fmt.Sprintf("p%02d", i%20), 20+i%10, cities[i%3], "")
+		if ok, err := db.Insert("person", rec); err != nil || !ok {
+			t.Fatalf("insert %d: %v %v", i, ok, err)
+		}
+	}
+	if n := checkIndexes(t, db, "person"); n != 100 {
+		t.Fatalf("%d rows", n)
+	}
+	checkIDs(t, db, byAge(25), 5, 15, 25, 35, 45, 55, 65, 75, 85, 95)
+	checkIDs(t, db, byCity("rome", "p01"), 1, 61)
+	checkIDs(t, db, byCity("paris", "p01"), 21, 81)
+
+	// a duplicate is not inserted and the entries of the row are kept
+	if ok, err := db.Insert("person", person(5, "x", 99, "x", "")); err != nil || ok {
+		t.Fatalf("duplicate: %v %v", ok, err)
+	}
+	checkIndexes(t, db, "person")
+	checkIDs(t, db, byAge(99))
+
+	// a column that is not indexed
+	if ok, err := db.Update("person", person(1, "p01", 21, "rome", "note")); err != nil || !ok {
+		t.Fatalf("update: %v %v", ok, err)
+	}
+	checkIndexes(t, db, "person")
+	checkIDs(t, db, byCity("rome", "p01"), 1, 61)
+
+	// the indexed columns, the old entries are removed
+	if ok, err := db.Update("person", person(1, "new", 99, "oslo", "")); err != nil || !ok {
+		t.Fatalf("update: %v %v", ok, err)
+	}
+	if ok, err := db.Update("person", person(21, "p01", 99, "rome", "")); err != nil || !ok {
+		t.Fatalf("update: %v %v", ok, err)
+	}
+	checkIndexes(t, db, "person")
+	checkIDs(t, db, byAge(21), 11, 31, 41, 51, 61, 71, 81, 91)
+	checkIDs(t, db, byAge(99), 1, 21)
+	checkIDs(t, db, byCity("rome", "p01"), 21, 61)
+	checkIDs(t, db, byCity("oslo", "new"), 1)
+	checkIDs(t, db, byCity("paris", "p01"), 81)
+
+	// a missing row is not added
+	if ok, err := db.Update("person", person(1000, "x", 99, "x", "")); err != nil || ok {
+		t.Fatalf("update missing: %v %v", ok, err)
+	}
+	// upsert: replaced and added
+	if ok, err := db.Upsert("person", person(2, "p02", 98, "rome", "")); err != nil || ok {
+		t.Fatalf("upsert: %v %v", ok, err)
+	}
+	if ok, err := db.Upsert("person", person(1000, "p02", 98, "rome", "")); err != nil || !ok {
+		t.Fatalf("upsert new: %v %v", ok, err)
+	}
+	if n := checkIndexes(t, db, "person"); n != 101 {
+		t.Fatalf("%d rows", n)
+	}
+	checkIDs(t, db, byAge(98), 2, 1000)
+	checkIDs(t, db, byCity("rome", "p02"), 2, 22, 82, 1000)
+	checkIDs(t, db, byCity("oslo", "p02"), 62)
+
+	// delete
+	for _, id := range []int64{1, 2, 5, 1000} {
+		key := *(&Record{}).AddInt64("id", id)
+		if ok, err := db.Delete("person", key); err != nil || !ok {
+			t.Fatalf("delete %d: %v %v", id, ok, err)
+		}
+	}
+	if ok, err := db.Delete("person", *(&Record{}).AddInt64("id", 5)); err != nil || ok {
+		t.Fatalf("delete again: %v %v", ok, err)
+	}
+	if n := checkIndexes(t, db, "person"); n != 97 {
+		t.Fatalf("%d rows", n)
+	}
+	checkIDs(t, db, byAge(99), 21)
+	checkIDs(t, db, byAge(98))
+	checkIDs(t, db, byAge(25), 15, 25, 35, 45, 55, 65, 75, 85, 95)
+
+	// an aborted transaction leaves no entries
+	tx := db.Begin()
+	if _, err := tx.Insert("person", person(2000, "x", 77, "x", "")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Update("person", person(21, "y", 77, "y", "")); err != nil {
+		t.Fatal(err)
+	}
+	tx.Abort()
+	if n := checkIndexes(t, db, "person"); n != 97 {
+		t.Fatalf("%d rows", n)
+	}
+	checkIDs(t, db, byAge(77))
+}
+
+func TestCreateIndex(t *testing.T) {
+	db := openDB(t)
+	tdef := personDef()
+	tdef.Indexes = nil
+	if err := db.CreateTable(tdef); err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(0); i < 300; i++ {
+		rec := person(i, fmt.Sprintf("p%02d", i%20), i%50, fmt.Sprint("c", i%7), "")
+		if _, err := db.Insert("person", rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.CreateIndex("person", []string{"age"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateIndex("person", []string{"city", "name", "id"}); err != nil {
+		t.Fatal(err)
+	}
+	reader := db.BeginRead()
+	tdef, err := reader.TableDef("person")
+	db.EndRead(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the primary key is appended
+	if fmt.Sprint(tdef.Indexes) != "[[age id] [city name id]]" || len(tdef.IndexPrefixes) != 2 {
+		t.Fatalf("indexes %v, prefixes %v", tdef.Indexes, tdef.IndexPrefixes)
+	}
+	if n := checkIndexes(t, db, "person"); n != 300 {
+		t.Fatalf("%d rows", n)
+	}
+	checkIDs(t, db, byAge(7), 7, 57, 107, 157, 207, 257)
+	checkIDs(t, db, byCity("c3", "p10"), 10, 150, 290)
+
+	// the new index is maintained
+	if _, err := db.Update("person", person(7, "p07", 8, "c0", "")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Delete("person", *(&Record{}).AddInt64("id", 57)); err != nil {
+		t.Fatal(err)
+	}
+	checkIndexes(t, db, "person")
+	checkIDs(t, db, byAge(7), 107, 157, 207, 257)
+
+	errs := []struct {
+		table string
+		index []string
+		err   string
+	}{
+		{"person", []string{"age"}, "person: index exists: [age id]"},
+		{"person", []string{"age", "id"}, "person: index exists: [age id]"},
+		{"person", []string{"x"}, "person: unknown index column: x"},
+		{"person", []string{"age", "age"}, "person: duplicated index column: age"},
+		{"person", nil, "person: empty index"},
+		{"nobody", []string{"age"}, "table not found: nobody"},
+	}
+	for _, tc := range errs {
+		if err := db.CreateIndex(tc.table, tc.index); err == nil || err.Error() != tc.err {
+			t.Fatalf("%v: %v, want %s", tc.index, err, tc.err)
+		}
+	}
+
+	// in the same transaction as the rows
+	tx := db.Begin()
+	for i := int64(300); i < 310; i++ {
+		if _, err := tx.Insert("person", person(i, "q", i, "c9", "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.CreateIndex("person", []string{"note"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Delete("person", *(&Record{}).AddInt64("id", 309)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if n := checkIndexes(t, db, "person"); n != 308 {
+		t.Fatalf("%d rows", n)
+	}
+	if ids := lookupIDs(t, db, "person", *(&Record{}).AddStr("note", "")); len(ids) != 308 {
+		t.Fatalf("%d rows by note", len(ids))
+	}
+
+	// an empty table
+	if err := db.CreateTable(&TableDef{Name: "empty", Cols: []string{"k", "v"}, Types: []uint32{TYPE_BYTES, TYPE_INT64}, PKeys: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateIndex("empty", []string{"v"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Insert("empty", *(&Record{}).AddBytes("k", []byte("a")).AddInt64("v", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if n := checkIndexes(t, db, "empty"); n != 1 {
+		t.Fatalf("%d rows", n)
+	}
+}
@@ -0,0 +1,204 @@
+package table
+
+import (
+	"fmt"
+
+	"killerDB/btree"
+	"killerDB/keyenc"
+)
+
+// Scanner iterates over a range of rows in the order of the primary key or
+// of a secondary index. The columns of Key1 and Key2 are both a prefix of
+// the primary key or of the same index, an empty key leaves that side of
+// the range open. A point lookup by an index is CMP_GE and CMP_LE with the
+// same key.
+type Scanner struct {
+	// the range, from Key1 to Key2
+	Cmp1 int // btree.CMP_GE or btree.CMP_GT
+	Cmp2 int // btree.CMP_LE or btree.CMP_LT
+	Key1 Record
+	Key2 Record
+	// internal
+	kv    kvReader
+	tdef  *TableDef
+	index int // which index is used, -1 for the primary key
+	iter  *btree.KVIter
+	// the snapshot owned by a scanner from DB.Scan
+	db     *DB
+	reader *DBReader
+}
+
+func dbScan(kv kvReader, tdef *TableDef, req *Scanner) error {
+	switch {
+	case req.Cmp1 != btree.CMP_GE && req.Cmp1 != btree.CMP_GT:
+		return fmt.Errorf("bad range: Cmp1 %d", req.Cmp1)
+	case req.Cmp2 != btree.CMP_LE && req.Cmp2 != btree.CMP_LT:
+		return fmt.Errorf("bad range: Cmp2 %d", req.Cmp2)
+	}
+
+	// the index is chosen by the longer key
+	longer := req.Key1.Cols
+	if len(req.Key2.Cols) > len(longer) {
+		longer = req.Key2.Cols
+	}
+	index, cols, err := findIndex(tdef, longer)
+	if err != nil {
+		return err
+	}
+	prefix := tdef.Prefix
+	if index >= 0 {
+		prefix = tdef.IndexPrefixes[index]
+	}
+
+	vals1, err := checkKey(tdef, req.Key1, cols)
+	if err != nil {
+		return err
+	}
+	vals2, err := checkKey(tdef, req.Key2, cols)
+	if err != nil {
+		return err
+	}
+	// turn the range into [start, end)
+	start := encodeKey(nil, prefix, vals1)
+	if len(vals1) > 0 && req.Cmp1 == btree.CMP_GT {
+		start = keyenc.PrefixEnd(start) // past the keys beginning with Key1
+	}
+	end := encodeKey(nil, prefix, vals2)
+	if len(vals2) == 0 || req.Cmp2 == btree.CMP_LE {
+		end = keyenc.PrefixEnd(end) // include the keys beginning with Key2
+	}
+
+	req.kv = kv
+	req.tdef = tdef
+	req.index = index
+	req.iter = kv.Scan(start, end)
+	return nil
+}
+
+// find the primary key or the index beginning with the columns,
+// returns the columns in the order of the index.
+func findIndex(tdef *TableDef, cols []string) (int, []string, error) {
+	if prefixOf(tdef.Cols[:tdef.PKeys], cols) {
+		return -1, tdef.Cols[:len(cols)], nil
+	}
+	for i, index := range tdef.Indexes {
+		if prefixOf(index, cols) {
+			return i, index[:len(cols)], nil
+		}
+	}
+	return -2, nil, fmt.Errorf("%s: no index for the columns: %v", tdef.Name, cols)
+}
+
+// the columns are a prefix of the index in any order
+func prefixOf(index []string, cols []string) bool {
+	return len(cols) <= len(index) && sameCols(index[:len(cols)], cols)
+}
+
+func sameCols(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, col := range a {
+		found := false
+		for _, c := range b {
+			found = found || c == col
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// reorder the key values into the index order and check types.
+// the key is a prefix of the index columns.
+func checkKey(tdef *TableDef, key Record, cols []string) ([]Value, error) {
+	if len(key.Cols) != len(key.Vals) {
+		return nil, fmt.Errorf("bad record: %d columns, %d values", len(key.Cols), len(key.Vals))
+	}
+	if !prefixOf(cols, key.Cols) {
+		return nil, fmt.Errorf("%s: the range keys are not on the same index", tdef.Name)
+	}
+	vals := make([]Value, len(key.Cols))
+	for i, col := range cols[:len(key.Cols)] {
+		v := key.Get(col)
+		if v.Type != tdef.Types[colIndex(tdef, col)] {
+			return nil, fmt.Errorf("%s: bad type of column: %s", tdef.Name, col)
+		}
+		vals[i] = *v
+	}
+	return vals, nil
+}
+
+// within the range or not
+func (sc *Scanner) Valid() bool {
+	return sc.iter.Valid()
+}
+
+// move the underlying B-tree iterator
+func (sc *Scanner) Next() {
+	sc.iter.Next()
+}
+
+// the error that ended the scan early
+func (sc *Scanner) Err() error {
+	return sc.iter.Err()
+}
+
+// fetch the current row
+func (sc *Scanner) Deref(rec *Record) error {
+	tdef := sc.tdef
+	key := sc.iter.Key()[4:] // strip the prefix
+	if sc.index < 0 {
+		// the primary key
+		pkey := make([]Value, tdef.PKeys)
+		for i := range pkey {
+			pkey[i].Type = tdef.Types[i]
+		}
+		if err := decodeValues(key, pkey); err != nil {
+			return fmt.Errorf("%s: %w", tdef.Name, err)
+		}
+		val := sc.iter.Val()
+		if err := sc.iter.Err(); err != nil {
+			return err
+		}
+		values, err := decodeRow(tdef, pkey, val)
+		if err != nil {
+			return err
+		}
+		rec.Cols = append([]string{}, tdef.Cols...)
+		rec.Vals = values
+		return nil
+	}
+
+	// a secondary index, the primary key is a part of the index key
+	index := tdef.Indexes[sc.index]
+	ivals := make([]Value, len(index))
+	for i, col := range index {
+		ivals[i].Type = tdef.Types[colIndex(tdef, col)]
+	}
+	if err := decodeValues(key, ivals); err != nil {
+		return fmt.Errorf("%s: %w", tdef.Name, err)
+	}
+	*rec = Record{}
+	for i, col := range index {
+		if colIndex(tdef, col) < tdef.PKeys {
+			rec.Cols = append(rec.Cols, col)
+			rec.Vals = append(rec.Vals, ivals[i])
+		}
+	}
+	ok, err := dbGet(sc.kv, tdef, rec)
+	if err == nil && !ok {
+		err = fmt.Errorf("%s: the index entry has no row", tdef.Name)
+	}
+	return err
+}
+
+// release the snapshot held by a scanner from DB.Scan,
+// a no-op for scanners from transactions.
+func (sc *Scanner) Close() {
+	if sc.reader != nil {
+		sc.db.EndRead(sc.reader)
+		sc.reader = nil
+	}
+}
@@ -0,0 +1,303 @@
+package table
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"killerDB/btree"
+)
+
+// the rows of a scan as "col=val,col=val;..." with only the listed columns
+func scanRows(t *testing.T, db *DB, table string, sc Scanner, cols ...string) string {
+	t.Helper()
+	if err := db.Scan(table, &sc); err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+	rows := []string{}
+	for ; sc.Valid(); sc.Next() {
+		rec := Record{}
+		if err := sc.Deref(&rec); err != nil {
+			t.Fatal(err)
+		}
+		cells := []string{}
+		for _, col := range cols {
+			v := rec.Get(col)
+			if v.Type == TYPE_INT64 {
+				cells = append(cells, fmt.Sprint(v.I64))
+			} else {
+				cells = append(cells, string(v.Str))
+			}
+		}
+		rows = append(rows, strings.Join(cells, ","))
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(rows, ";")
+}
+
+func intKey(col string, v int64) Record {
+	return *(&Record{}).AddInt64(col, v)
+}
+
+// the table num of the even ids 0..98, with the index (sq) of id*id%97
+func numDB(t *testing.T) *DB {
+	t.Helper()
+	db := openDB(t)
+	tdef := &TableDef{
+		Name:    "num",
+		Cols:    []string{"id", "sq"},
+		Types:   []uint32{TYPE_INT64, TYPE_INT64},
+		PKeys:   1,
+		Indexes: [][]string{{"sq"}},
+	}
+	if err := db.CreateTable(tdef); err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(0); i < 100; i += 2 {
+		if _, err := db.Insert("num", *(&Record{}).AddInt64("id", i).AddInt64("sq", i*i%97)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func evens(from int64, to int64) string {
+	ids := []string{}
+	for i := from; i <= to; i += 2 {
+		ids = append(ids, fmt.Sprint(i))
+	}
+	return strings.Join(ids, ";")
+}
+
+func TestScanPrimaryKey(t *testing.T) {
+	db := numDB(t)
+	none := Record{}
+	cases := []struct {
+		cmp1 int
+		key1 Record
+		cmp2 int
+		key2 Record
+		want string
+	}{
+		// closed
+		{btree.CMP_GE, intKey("id", 10), btree.CMP_LE, intKey("id", 20), evens(10, 20)},
+		{btree.CMP_GE, intKey("id", 11), btree.CMP_LE, intKey("id", 19), evens(12, 18)},
+		{btree.CMP_GE, intKey("id", 10), btree.CMP_LE, intKey("id", 10), "10"},
+		{btree.CMP_GE, intKey("id", -5), btree.CMP_LE, intKey("id", 3), evens(0, 2)},
+		// strict
+		{btree.CMP_GT, intKey("id", 10), btree.CMP_LT, intKey("id", 20), evens(12, 18)},
+		{btree.CMP_GT, intKey("id", 11), btree.CMP_LT, intKey("id", 19), evens(12, 18)},
+		{btree.CMP_GT, intKey("id", 10), btree.CMP_LE, intKey("id", 20), evens(12, 20)},
+		{btree.CMP_GE, intKey("id", 10), btree.CMP_LT, intKey("id", 20), evens(10, 18)},
+		{btree.CMP_GT, intKey("id", 10), btree.CMP_LT, intKey("id", 12), ""},
+		{btree.CMP_GT, intKey("id", 10), btree.CMP_LT, intKey("id", 10), ""},
+		{btree.CMP_GE, intKey("id", 20), btree.CMP_LE, intKey("id", 10), ""},
+		// open
+		{btree.CMP_GE, none, btree.CMP_LE, none, evens(0, 98)},
+		{btree.CMP_GT, intKey("id", 90), btree.CMP_LE, none, evens(92, 98)},
+		{btree.CMP_GE, intKey("id", 90), btree.CMP_LT, none, evens(90, 98)},
+		{btree.CMP_GE, none, btree.CMP_LT, intKey("id", 6), evens(0, 4)},
+		{btree.CMP_GT, none, btree.CMP_LE, intKey("id", 6), evens(0, 6)},
+		{btree.CMP_GT, intKey("id", 98), btree.CMP_LE, none, ""},
+		{btree.CMP_GE, none, btree.CMP_LT, intKey("id", 0), ""},
+	}
+	for _, tc := range cases {
+		sc := Scanner{Cmp1: tc.cmp1, Key1: tc.key1, Cmp2: tc.cmp2, Key2: tc.key2}
+		if got := scanRows(t, db, "num", sc, "id"); got != tc.want {
+			t.Fatalf("%d %v %d %v: %s, want %s", tc.cmp1, tc.key1.Vals, tc.cmp2, tc.key2.Vals, got, tc.want)
+		}
+	}
+}
+
+func TestScanIndex(t *testing.T) {
+	db := numDB(t)
+	// the rows ordered by sq, then by id for the same sq
+	all := scanRows(t, db, "num", Scanner{Cmp1: btree.CMP_GE, Cmp2: btree.CMP_LE, Key2: intKey("sq", 1000)}, "sq", "id")
+	rows := strings.Split(all, ";")
+	if len(rows) != 50 {
+		t.Fatalf("%d rows: %s", len(rows), all)
+	}
+	for i := 1; i < len(rows); i++ {
+		var sq1, id1, sq2, id2 int64
+		fmt.Sscanf(rows[i-1], "%d,%d", &sq1, &id1)
+		fmt.Sscanf(rows[i], "%d,%d", &sq2, &id2)
+		if sq1 > sq2 || sq1 == sq2 && id1 >= id2 {
+			t.Fatalf("out of order: %s %s", rows[i-1], rows[i])
+		}
+	}
+	// sq between the bounds
+	between := func(lo int64, loIncl bool, hi int64, hiIncl bool) string {
+		out := []string{}
+		for _, row := range rows {
+			var sq, id int64
+			fmt.Sscanf(row, "%d,%d", &sq, &id)
+			if (sq > lo || loIncl && sq == lo) && (sq < hi || hiIncl && sq == hi) {
+				out = append(out, row)
+			}
+		}
+		return strings.Join(out, ";")
+	}
+
+	// the values at the bounds exist: 16 and 64
+	cases := []struct {
+		cmp1 int
+		lo   int64
+		cmp2 int
+		hi   int64
+		want string
+	}{
+		{btree.CMP_GE, 16, btree.CMP_LE, 64, between(16, true, 64, true)},
+		{btree.CMP_GT, 16, btree.CMP_LE, 64, between(16, false, 64, true)},
+		{btree.CMP_GE, 16, btree.CMP_LT, 64, between(16, true, 64, false)},
+		{btree.CMP_GT, 16, btree.CMP_LT, 64, between(16, false, 64, false)},
+		{btree.CMP_GE, 64, btree.CMP_LE, 64, between(64, true, 64, true)},
+		{btree.CMP_GT, 64, btree.CMP_LT, 64, ""},
+	}
+	for _, tc := range cases {
+		sc := Scanner{Cmp1: tc.cmp1, Key1: intKey("sq", tc.lo), Cmp2: tc.cmp2, Key2: intKey("sq", tc.hi)}
+		got := scanRows(t, db, "num", sc, "sq", "id")
+		if got != tc.want {
+			t.Fatalf("%d %d %d %d: %s, want %s", tc.cmp1, tc.lo, tc.cmp2, tc.hi, got, tc.want)
+		}
+	}
+	if between(16, true, 16, true) == "" || between(64, true, 64, true) == "" {
+		t.Fatal("no rows at the bounds")
+	}
+
+	// open on one side
+	sc := Scanner{Cmp1: btree.CMP_GT, Key1: intKey("sq", 90), Cmp2: btree.CMP_LE}
+	if got := scanRows(t, db, "num", sc, "sq", "id"); got != between(90, false, 1000, true) {
+		t.Fatal(got)
+	}
+	sc = Scanner{Cmp1: btree.CMP_GE, Cmp2: btree.CMP_LT, Key2: intKey("sq", 4)}
+	if got := scanRows(t, db, "num", sc, "sq", "id"); got != between(-1, false, 4, false) || got != "0,0;1,96;1,98;2,14;3,10" {
+		t.Fatal(got)
+	}
+
+	// the index key is (sq, id), a bound on both columns
+	var sq, id int64
+	fmt.Sscanf(rows[20], "%d,%d", &sq, &id)
+	key := *(&Record{}).AddInt64("id", id).AddInt64("sq", sq) // in any order
+	sc = Scanner{Cmp1: btree.CMP_GT, Key1: key, Cmp2: btree.CMP_LE, Key2: intKey("sq", 1000)}
+	if got := scanRows(t, db, "num", sc, "sq", "id"); got != strings.Join(rows[21:], ";") {
+		t.Fatal(got)
+	}
+	sc = Scanner{Cmp1: btree.CMP_GE, Cmp2: btree.CMP_LE, Key1: key, Key2: key}
+	if got := scanRows(t, db, "num", sc, "sq", "id"); got != rows[20] {
+		t.Fatal(got)
+	}
+}
+
+// a composite primary key and an index on a string column
+func TestScanComposite(t *testing.T) {
+	db := openDB(t)
+	tdef := &TableDef{
+		Name:    "event",
+		Cols:    []string{"user", "ts", "kind"},
+		Types:   []uint32{TYPE_STRING, TYPE_INT64, TYPE_STRING},
+		PKeys:   2,
+		Indexes: [][]string{{"kind"}},
+	}
+	if err := db.CreateTable(tdef); err != nil {
+		t.Fatal(err)
+	}
+	users := []string{"a", "ab", "b", "b\x00"}
+	for _, user := range users {
+		for ts := int64(1); ts <= 3; ts++ {
+			rec := (&Record{}).AddStr("user", user).AddInt64("ts", ts).AddStr("kind", fmt.Sprint("k", ts%2))
+			if _, err := db.Insert("event", *rec); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	user := func(u string) Record { return *(&Record{}).AddStr("user", u) }
+	userTs := func(u string, ts int64) Record { return *(&Record{}).AddInt64("ts", ts).AddStr("user", u) }
+	kind := func(k string) Record { return *(&Record{}).AddStr("kind", k) }
+	cases := []struct {
+		cmp1 int
+		key1 Record
+		cmp2 int
+		key2 Record
+		want string
+	}{
+		// a prefix of the primary key, "a" doesn't include "ab"
+		{btree.CMP_GE, user("a"), btree.CMP_LE, user("a"), "a,1;a,2;a,3"},
+		{btree.CMP_GT, user("a"), btree.CMP_LT, user("b"), "ab,1;ab,2;ab,3"},
+		{btree.CMP_GT, user("ab"), btree.CMP_LE, user("b"), "b,1;b,2;b,3"},
+		{btree.CMP_GT, user("b"), btree.CMP_LE, Record{}, "b\x00,1;b\x00,2;b\x00,3"},
+		// the whole key and a prefix
+		{btree.CMP_GT, userTs("a", 1), btree.CMP_LE, user("a"), "a,2;a,3"},
+		{btree.CMP_GE, userTs("a", 2), btree.CMP_LT, userTs("ab", 2), "a,2;a,3;ab,1"},
+		{btree.CMP_GT, userTs("b", 3), btree.CMP_LT, userTs("b\x00", 2), "b\x00,1"},
+		// the index, then the primary key
+		{btree.CMP_GE, kind("k0"), btree.CMP_LE, kind("k0"), "a,2;ab,2;b,2;b\x00,2"},
+		{btree.CMP_GT, kind("k0"), btree.CMP_LE, Record{}, "a,1;a,3;ab,1;ab,3;b,1;b,3;b\x00,1;b\x00,3"},
+		{btree.CMP_GE, Record{}, btree.CMP_LT, kind("k1"), "a,2;ab,2;b,2;b\x00,2"},
+	}
+	for _, tc := range cases {
+		sc := Scanner{Cmp1: tc.cmp1, Key1: tc.key1, Cmp2: tc.cmp2, Key2: tc.key2}
+		if got := scanRows(t, db, "event", sc, "user", "ts"); got != tc.want {
+			t.Fatalf("%d %v %d %v: %q, want %q", tc.cmp1, tc.key1, tc.cmp2, tc.key2, got, tc.want)
+		}
+	}
+}
+
+func TestScanErrors(t *testing.T) {
+	db := numDB(t)
+	str := *(&Record{}).AddStr("id", "1")
+	cases := []struct {
+		sc  Scanner
+		err string
+	}{
+		{Scanner{Cmp1: btree.CMP_LE, Cmp2: btree.CMP_LE}, "bad range: Cmp1"},
+		{Scanner{Cmp1: btree.CMP_GE, Cmp2: btree.CMP_GT}, "bad range: Cmp2"},
+		{Scanner{Cmp1: btree.CMP_GE, Cmp2: btree.CMP_LE, Key1: intKey("x", 1)}, "num: no index for the columns: [x]"},
+		{Scanner{Cmp1: btree.CMP_GE, Cmp2: btree.CMP_LE, Key1: intKey("id", 1), Key2: intKey("sq", 1)}, "num: the range keys are not on the same index"},
+		{Scanner{Cmp1: btree.CMP_GE, Cmp2: btree.CMP_LE, Key1: str}, "num: bad type of column: id"},
+		{Scanner{Cmp1: btree.CMP_GE, Cmp2: btree.CMP_LE, Key1: Record{Cols: []string{"id"}}}, "bad record: 1 columns, 0 values"},
+	}
+	for _, tc := range cases {
+		sc := tc.sc
+		if err := db.Scan("num", &sc); err == nil || !strings.HasPrefix(err.Error(), tc.err) {
+			t.Fatalf("%v, want %s", err, tc.err)
+		}
+	}
+	sc := Scanner{Cmp1: btree.CMP_GE, Cmp2: btree.CMP_LE}
+	if err := db.Scan("nothing", &sc); err == nil {
+		t.Fatal("no error for a missing table")
+	}
+}
+
+// the scanner from DB.Scan reads a snapshot until it's closed
+func TestScanSnapshot(t *testing.T) {
+	db := numDB(t)
+	sc := Scanner{Cmp1: btree.CMP_GE, Cmp2: btree.CMP_LE, Key1: intKey("sq", 0), Key2: intKey("sq", 0)}
+	if err := db.Scan("num", &sc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Delete("num", intKey("id", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Insert("num", *(&Record{}).AddInt64("id", 97).AddInt64("sq", 0)); err != nil {
+		t.Fatal(err)
+	}
+	ids := []int64{}
+	for ; sc.Valid(); sc.Next() {
+		rec := Record{}
+		if err := sc.Deref(&rec); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, rec.Get("id").I64)
+	}
+	sc.Close()
+	sc.Close() // twice
+	if fmt.Sprint(ids) != "[0]" {
+		t.Fatalf("snapshot: %v", ids)
+	}
+	if ids := lookupIDs(t, db, "num", intKey("sq", 0)); fmt.Sprint(ids) != "[97]" {
+		t.Fatalf("after: %v", ids)
+	}
+}
@@ -0,0 +1,56 @@
+package table
+
+// column types
+const (
+	TYPE_ERROR  = 0
+	TYPE_BYTES  = 1
+	TYPE_INT64  = 2
+	TYPE_STRING = 3
+)
+
+var typeNames = map[uint32]string{
+	TYPE_BYTES:  "bytes",
+	TYPE_INT64:  "int64",
+	TYPE_STRING: "string",
+}
+
+// table cell
+type Value struct {
+	Type uint32
+	I64  int64
+	Str  []byte // for TYPE_BYTES and TYPE_STRING
+}
+
+// table row
+type Record struct {
+	Cols []string
+	Vals []Value
+}
+
+func (rec *Record) AddBytes(col string, val []byte) *Record {
+	rec.Cols = append(rec.Cols, col)
+	rec.Vals = append(rec.Vals, Value{Type: TYPE_BYTES, Str: val})
+	return rec
+}
+
+func (rec *Record) AddStr(col string, val string) *Record {
+	rec.Cols = append(rec.Cols, col)
+	rec.Vals = append(rec.Vals, Value{Type: TYPE_STRING, Str: []byte(val)})
+	return rec
+}
+
+func (rec *Record) AddInt64(col string, val int64) *Record {
+	rec.Cols = append(rec.Cols, col)
+	rec.Vals = append(rec.Vals, Value{Type: TYPE_INT64, I64: val})
+	return rec
+}
+
+// get the value of a column, nil if the column is not in the record
+func (rec *Record) Get(col string) *Value {
+	for i, c := range rec.Cols {
+		if c == col {
+			return &rec.Vals[i]
+		}
+	}
+	return nil
+}